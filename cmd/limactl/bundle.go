@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lima-vm/lima/pkg/bundle"
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/lima-vm/lima/pkg/limatmpl"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newBundleCommand() *cobra.Command {
+	bundleCommand := &cobra.Command{
+		Use:   "bundle",
+		Short: "Create and import offline bundles for hosts without the internet connection",
+		Long: `Create and import offline bundles for hosts without the internet connection.
+
+A bundle contains a template, and the images, kernels, containerd archives, and firmware referred by the template.
+Importing a bundle pre-populates the download cache, so that "limactl start" works offline.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		GroupID:       advancedCommand,
+	}
+	bundleCommand.AddCommand(
+		newBundleCreateCommand(),
+		newBundleImportCommand(),
+	)
+	return bundleCommand
+}
+
+func newBundleCreateCommand() *cobra.Command {
+	bundleCreateCommand := &cobra.Command{
+		Use: "create TEMPLATE",
+		Example: `
+To create a bundle of the default template for the architecture of the host:
+$ limactl bundle create template://default -o default.tar
+
+To create a bundle for both x86_64 and aarch64 hosts:
+$ limactl bundle create template://default --arch x86_64 --arch aarch64 -o default.tar
+`,
+		Short:             "Create an offline bundle of a template",
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              bundleCreateAction,
+		ValidArgsFunction: bundleCreateBashComplete,
+	}
+	bundleCreateCommand.Flags().StringP("output", "o", "", "output file (\"-\" for stdout)")
+	_ = bundleCreateCommand.MarkFlagRequired("output")
+	bundleCreateCommand.Flags().StringSlice("arch", nil, "architectures to bundle (default: the architecture of the template)")
+	return bundleCreateCommand
+}
+
+func bundleCreateAction(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	archs, err := cmd.Flags().GetStringSlice("arch")
+	if err != nil {
+		return err
+	}
+	tmpl, err := limatmpl.Read(cmd.Context(), "", args[0])
+	if err != nil {
+		return err
+	}
+	if len(tmpl.Bytes) == 0 {
+		return fmt.Errorf("don't know how to interpret %q as a template locator", args[0])
+	}
+	if tmpl.Name == "" {
+		return fmt.Errorf("can't determine instance name from template locator %q", args[0])
+	}
+	// The base templates may not be available on the offline host
+	if err := tmpl.Embed(cmd.Context()); err != nil {
+		return err
+	}
+	limaDir, err := dirnames.LimaDir()
+	if err != nil {
+		return err
+	}
+	// Load() fills the default containerd archives, etc.
+	y, err := limayaml.Load(tmpl.Bytes, filepath.Join(limaDir, tmpl.Name))
+	if err != nil {
+		return err
+	}
+	if len(archs) == 0 {
+		archs = []string{*y.Arch}
+	}
+	wanted := make(map[limayaml.Arch]bool)
+	for _, arch := range archs {
+		wanted[limayaml.NewArch(arch)] = true
+	}
+	var files []limayaml.File
+	for _, f := range downloader.FilesByCacheKey(y) {
+		if wanted[f.Arch] {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("template %q has no files for %v", args[0], archs)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Location < files[j].Location
+	})
+
+	w := cmd.OutOrStdout()
+	var f *os.File
+	if output != "-" {
+		f, err = os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := bundle.Create(cmd.Context(), w, tmpl.Name, tmpl.Bytes, files, y.ChecksumSignature); err != nil {
+		if f != nil {
+			_ = os.RemoveAll(output)
+		}
+		return err
+	}
+	if f != nil {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		logrus.Infof("Created the bundle %q with %d files", output, len(files))
+	}
+	return nil
+}
+
+func bundleCreateBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteTemplateNames(cmd)
+}
+
+func newBundleImportCommand() *cobra.Command {
+	bundleImportCommand := &cobra.Command{
+		Use: "import BUNDLE",
+		Example: `
+To import a bundle, and to start an instance from the template in the bundle:
+$ limactl bundle import default.tar
+$ limactl start ./default.yaml
+`,
+		Short: "Import an offline bundle into the download cache",
+		Long: `Import an offline bundle into the download cache.
+The template in the bundle is written to the current directory, or to the file specified with --output.`,
+		Args: WrapArgsError(cobra.ExactArgs(1)),
+		RunE: bundleImportAction,
+	}
+	bundleImportCommand.Flags().StringP("output", "o", "", "output file of the template (\"-\" for stdout)")
+	return bundleImportCommand
+}
+
+func bundleImportAction(cmd *cobra.Command, args []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	var r io.Reader = cmd.InOrStdin()
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	b, err := bundle.Import(r, downloader.WithCache())
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Imported %d files into the cache", len(b.Manifest.Files))
+	if output == "-" {
+		_, err = cmd.OutOrStdout().Write(b.Template)
+		return err
+	}
+	if output == "" {
+		output = filepath.Base(b.Manifest.Template)
+	}
+	if _, err := os.Stat(output); err == nil {
+		return fmt.Errorf("template %q already exists, specify another file with --output", output)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.WriteFile(output, b.Template, 0o644); err != nil {
+		return err
+	}
+	logrus.Infof("Wrote the template to %q. Run `limactl start %s` to start an instance", output, output)
+	return nil
+}
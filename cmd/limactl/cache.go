@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// shortCacheKeyLength is the length of the cache keys printed by `limactl cache ls`.
+const shortCacheKeyLength = 12
+
+func newCacheCommand() *cobra.Command {
+	cacheCommand := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the download cache",
+		Long: `Manage the download cache of images, kernels, and archives.
+
+The size of the cache can be limited by setting $LIMA_CACHE_MAX_SIZE (e.g., "20GiB").
+The least recently used entries are evicted after each download, when the cache exceeds the size.
+The entries referred by instances or templates are not evicted.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		GroupID:       advancedCommand,
+	}
+	cacheCommand.AddCommand(
+		newCacheListCommand(),
+		newCacheDiskUsageCommand(),
+		newCacheRemoveCommand(),
+		newCacheGCCommand(),
+	)
+	return cacheCommand
+}
+
+func newCacheListCommand() *cobra.Command {
+	cacheListCommand := &cobra.Command{
+		Use:               "list",
+		Aliases:           []string{"ls"},
+		Short:             "List the cache entries, from the least recently used",
+		Args:              WrapArgsError(cobra.NoArgs),
+		RunE:              cacheListAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	cacheListCommand.Flags().Bool("json", false, "JSONify output")
+	return cacheListCommand
+}
+
+// cacheEntryJSON is the JSON output of `limactl cache ls --json`.
+type cacheEntryJSON struct {
+	downloader.CacheEntry
+	ReferredBy []string `json:"referredBy,omitempty"`
+}
+
+func cacheListAction(cmd *cobra.Command, _ []string) error {
+	jsonFormat, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	entries, err := downloader.ListCacheEntries(downloader.WithCache())
+	if err != nil {
+		return err
+	}
+	referrers, err := downloader.CacheReferrers()
+	if err != nil {
+		return err
+	}
+
+	if jsonFormat {
+		for _, entry := range entries {
+			j, err := json.Marshal(cacheEntryJSON{CacheEntry: entry, ReferredBy: referrers[entry.Key]})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(j))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSIZE\tLAST USED\tREFERRED BY\tURL")
+	for _, entry := range entries {
+		referredBy := strings.Join(referrers[entry.Key], ",")
+		if referredBy == "" {
+			referredBy = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", entry.Key[:min(len(entry.Key), shortCacheKeyLength)],
+			units.BytesSize(float64(entry.Size)), units.HumanDuration(time.Since(entry.LastUsed))+" ago",
+			referredBy, entry.URL)
+	}
+	return w.Flush()
+}
+
+func newCacheDiskUsageCommand() *cobra.Command {
+	cacheDiskUsageCommand := &cobra.Command{
+		Use:               "du",
+		Short:             "Show the disk usage of the cache",
+		Args:              WrapArgsError(cobra.NoArgs),
+		RunE:              cacheDiskUsageAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	return cacheDiskUsageCommand
+}
+
+func cacheDiskUsageAction(cmd *cobra.Command, _ []string) error {
+	entries, err := downloader.ListCacheEntries(downloader.WithCache())
+	if err != nil {
+		return err
+	}
+	referrers, err := downloader.CacheReferrers()
+	if err != nil {
+		return err
+	}
+	var total, referred int64
+	var referredCount int
+	for _, entry := range entries {
+		total += entry.Size
+		if _, ok := referrers[entry.Key]; ok {
+			referred += entry.Size
+			referredCount++
+		}
+	}
+	maxSize, err := downloader.MaxCacheSize()
+	if err != nil {
+		return err
+	}
+	limit := "unlimited"
+	if maxSize > 0 {
+		limit = units.BytesSize(float64(maxSize))
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tENTRIES\tSIZE")
+	fmt.Fprintf(w, "Referred\t%d\t%s\n", referredCount, units.BytesSize(float64(referred)))
+	fmt.Fprintf(w, "Unreferred\t%d\t%s\n", len(entries)-referredCount, units.BytesSize(float64(total-referred)))
+	fmt.Fprintf(w, "Total\t%d\t%s (max: %s)\n", len(entries), units.BytesSize(float64(total)), limit)
+	return w.Flush()
+}
+
+func newCacheRemoveCommand() *cobra.Command {
+	cacheRemoveCommand := &cobra.Command{
+		Use: "rm KEY|URL [KEY|URL, ...]",
+		Example: `
+To remove a cache entry by the key (or its unique prefix) printed by "limactl cache ls":
+$ limactl cache rm 0123456789ab
+
+To remove a cache entry by the URL:
+$ limactl cache rm https://cloud-images.ubuntu.com/releases/24.04/release/ubuntu-24.04-server-cloudimg-amd64.img
+`,
+		Aliases:           []string{"remove"},
+		Short:             "Remove cache entries",
+		Args:              WrapArgsError(cobra.MinimumNArgs(1)),
+		RunE:              cacheRemoveAction,
+		ValidArgsFunction: cacheBashComplete,
+	}
+	cacheRemoveCommand.Flags().BoolP("force", "f", false, "remove the entries referred by instances or templates too")
+	return cacheRemoveCommand
+}
+
+// findCacheEntry finds the cache entry by the URL, the key, or the unique prefix of the key.
+func findCacheEntry(entries []downloader.CacheEntry, arg string) (*downloader.CacheEntry, error) {
+	var matches []downloader.CacheEntry
+	for _, entry := range entries {
+		if entry.URL == arg || entry.Key == arg {
+			return &entry, nil
+		}
+		if strings.HasPrefix(entry.Key, arg) {
+			matches = append(matches, entry)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no cache entry matches %q", arg)
+	case 1:
+		return &matches[0], nil
+	default:
+		return nil, fmt.Errorf("%d cache entries match %q, specify a longer key", len(matches), arg)
+	}
+}
+
+func cacheRemoveAction(cmd *cobra.Command, args []string) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	entries, err := downloader.ListCacheEntries(downloader.WithCache())
+	if err != nil {
+		return err
+	}
+	referrers, err := downloader.CacheReferrers()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, arg := range args {
+		entry, err := findCacheEntry(entries, arg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if names := referrers[entry.Key]; len(names) > 0 && !force {
+			errs = append(errs, fmt.Errorf("cannot remove the cache entry %q referred by %v (hint: use --force)", entry.URL, names))
+			continue
+		}
+		if err := downloader.RemoveCacheEntry(*entry); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		logrus.Infof("Removed %q (%s)", entry.URL, units.BytesSize(float64(entry.Size)))
+	}
+	return errors.Join(errs...)
+}
+
+func newCacheGCCommand() *cobra.Command {
+	cacheGCCommand := &cobra.Command{
+		Use:   "gc",
+		Short: "Evict the least recently used cache entries",
+		Long: `Evict the least recently used cache entries, until the size of the cache is within the maximum size.
+The maximum size defaults to $LIMA_CACHE_MAX_SIZE.`,
+		Example: `
+To shrink the cache to 10GiB, keeping the entries referred by instances or templates:
+$ limactl cache gc --max-size 10GiB
+`,
+		Args:              WrapArgsError(cobra.NoArgs),
+		RunE:              cacheGCAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	cacheGCCommand.Flags().String("max-size", "", "maximum size of the cache (default: $LIMA_CACHE_MAX_SIZE)")
+	cacheGCCommand.Flags().Bool("keep-referred", true, "keep the entries referred by instances or templates")
+	return cacheGCCommand
+}
+
+func cacheGCAction(cmd *cobra.Command, _ []string) error {
+	maxSizeS, err := cmd.Flags().GetString("max-size")
+	if err != nil {
+		return err
+	}
+	keepReferred, err := cmd.Flags().GetBool("keep-referred")
+	if err != nil {
+		return err
+	}
+	var maxSize int64
+	if maxSizeS != "" {
+		maxSize, err = units.RAMInBytes(maxSizeS)
+		if err != nil {
+			return fmt.Errorf("invalid max size %q: %w", maxSizeS, err)
+		}
+	} else {
+		maxSize, err = downloader.MaxCacheSize()
+		if err != nil {
+			return err
+		}
+		if maxSize == 0 {
+			return errors.New("the maximum size must be specified with --max-size or $LIMA_CACHE_MAX_SIZE")
+		}
+	}
+	var keep func(downloader.CacheEntry) bool
+	if keepReferred {
+		referrers, err := downloader.CacheReferrers()
+		if err != nil {
+			return err
+		}
+		keep = func(entry downloader.CacheEntry) bool {
+			_, ok := referrers[entry.Key]
+			return ok
+		}
+	}
+	evicted, err := downloader.EvictCache(maxSize, keep, downloader.WithCache())
+	var freed int64
+	for _, entry := range evicted {
+		logrus.Infof("Evicted %q (%s)", entry.URL, units.BytesSize(float64(entry.Size)))
+		freed += entry.Size
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Evicted %d entries, freed %s", len(evicted), units.BytesSize(float64(freed)))
+	return nil
+}
+
+func cacheBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteCacheKeys(cmd)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lima-vm/lima/cmd/limactl/editflags"
+	"github.com/lima-vm/lima/pkg/cluster"
+	"github.com/lima-vm/lima/pkg/instance"
+	"github.com/lima-vm/lima/pkg/limatmpl"
+	"github.com/lima-vm/lima/pkg/networks"
+	reconcile "github.com/lima-vm/lima/pkg/networks/reconcile"
+	"github.com/lima-vm/lima/pkg/networks/usernet"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/yqutil"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+func newClusterCommand() *cobra.Command {
+	clusterCommand := &cobra.Command{
+		Use:   "cluster",
+		Short: "Manage groups of instances on a dedicated network",
+		Example: `  Create and start a cluster of 3 instances "k8s-0", "k8s-1", and "k8s-2":
+  $ limactl cluster create k8s --nodes 3 --template k8s
+
+  Stop and restart all the instances of the cluster:
+  $ limactl cluster stop k8s
+  $ limactl cluster start k8s
+
+  Delete the instances and the network of the cluster:
+  $ limactl cluster delete k8s`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		GroupID:       advancedCommand,
+	}
+	clusterCommand.AddCommand(
+		newClusterCreateCommand(),
+		newClusterStartCommand(),
+		newClusterStopCommand(),
+		newClusterDeleteCommand(),
+		newClusterListCommand(),
+	)
+	return clusterCommand
+}
+
+func newClusterCreateCommand() *cobra.Command {
+	clusterCreateCommand := &cobra.Command{
+		Use:   "create CLUSTER",
+		Short: "Create and start a cluster of instances",
+		Long: fmt.Sprintf(`Create a cluster of instances "CLUSTER-0" ... "CLUSTER-<N-1>" from the same template, and start them in parallel.
+
+The instances are connected to a dedicated user-v2 network %q, on which each node has a fixed IPv4 address.
+The nodes receive the following `+"`param`"+` values, which are also written to %s in the guest:
+  CLUSTER_NAME          the name of the cluster
+  CLUSTER_NODE_INDEX    the index of the node, starting from 0
+  CLUSTER_NODE_COUNT    the number of nodes
+  CLUSTER_NODE_IP       the IPv4 address of the node on the cluster network
+  CLUSTER_PEERS         the comma-separated IPv4 addresses of all the nodes
+  CLUSTER_PEER_NAMES    the comma-separated hostnames of all the nodes`, cluster.NetworkName("CLUSTER"), cluster.EnvFile),
+		Example: `
+To create a cluster of 3 instances from the default template:
+$ limactl cluster create foo --nodes 3
+
+To create a cluster from a template with modified parameters:
+$ limactl cluster create foo --nodes 2 --template template://fedora --cpus 2 --memory 4
+`,
+		Args: WrapArgsError(cobra.ExactArgs(1)),
+		RunE: clusterCreateAction,
+	}
+	flags := clusterCreateCommand.Flags()
+	flags.Int("nodes", 1, "number of instances")
+	flags.String("template", "template://default", "template name, YAML file path, or URL")
+	_ = clusterCreateCommand.RegisterFlagCompletionFunc("template", func(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+		return bashCompleteTemplateNames(cmd)
+	})
+	flags.String("gateway", "", "gateway IP address of the cluster network, with a prefix length (default: a free 192.168.x.1/24)")
+	flags.Bool("start", true, "start the instances")
+	flags.Duration("timeout", instance.DefaultWatchHostAgentEventsTimeout, "duration to wait for the instances to be running before timing out")
+	editflags.RegisterCreate(clusterCreateCommand, "")
+	return clusterCreateCommand
+}
+
+func clusterCreateAction(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	flags := cmd.Flags()
+	nodes, err := flags.GetInt("nodes")
+	if err != nil {
+		return err
+	}
+	template, err := flags.GetString("template")
+	if err != nil {
+		return err
+	}
+	if isTemplateURL, _ := limatmpl.SeemsTemplateURL(template); !isTemplateURL &&
+		!limatmpl.SeemsHTTPURL(template) && !limatmpl.SeemsFileURL(template) && !limatmpl.SeemsYAMLPath(template) {
+		template = "template://" + template
+	}
+	gateway, err := flags.GetString("gateway")
+	if err != nil {
+		return err
+	}
+	start, err := flags.GetBool("start")
+	if err != nil {
+		return err
+	}
+	yqExprs, err := editflags.YQExpressions(flags, true)
+	if err != nil {
+		return err
+	}
+
+	if _, err := cluster.Load(name); err == nil {
+		return fmt.Errorf("cluster %q already exists", name)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return err
+	}
+	var gatewayNet *net.IPNet
+	if gateway != "" {
+		ip, ipNet, err := net.ParseCIDR(gateway)
+		if err != nil {
+			return fmt.Errorf("failed to parse --gateway %q: %w", gateway, err)
+		}
+		gatewayNet = &net.IPNet{IP: ip, Mask: ipNet.Mask}
+	} else if gatewayNet, err = cluster.FreeGateway(&cfg); err != nil {
+		return err
+	}
+	c, err := cluster.New(name, template, nodes, gatewayNet)
+	if err != nil {
+		return err
+	}
+	for _, node := range c.Nodes {
+		if _, err := store.Inspect(node.Name); err == nil {
+			return fmt.Errorf("instance %q already exists", node.Name)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	nw, err := c.NetworkConfig()
+	if err != nil {
+		return err
+	}
+	if err := networks.AddNetwork(c.Network, nw); err != nil {
+		return err
+	}
+	if err := usernet.AddStaticLeases(c.Network, c.Leases()); err != nil {
+		return err
+	}
+	if err := c.Save(); err != nil {
+		return err
+	}
+	logrus.Infof("Created network %q (gateway %s) for cluster %q", c.Network, c.Gateway, name)
+
+	ctx := cmd.Context()
+	for _, node := range c.Nodes {
+		tmpl, err := limatmpl.Read(ctx, node.Name, c.Template)
+		if err != nil {
+			return err
+		}
+		if len(tmpl.Bytes) == 0 {
+			return fmt.Errorf("failed to read template %q", c.Template)
+		}
+		if err := tmpl.Embed(ctx); err != nil {
+			return err
+		}
+		nodeExpr, err := c.YQExpression(node)
+		if err != nil {
+			return err
+		}
+		if err := modifyInPlace(tmpl, yqutil.Join(append(yqExprs, nodeExpr))); err != nil {
+			return err
+		}
+		inst, err := instance.Create(ctx, node.Name, tmpl.Bytes, false)
+		if err != nil {
+			return fmt.Errorf("failed to create instance %q (Hint: run `limactl cluster delete %s` to clean up): %w", node.Name, name, err)
+		}
+		if _, err := instance.Prepare(ctx, inst); err != nil {
+			return err
+		}
+		logrus.Infof("Created instance %q (%s)", node.Name, node.IP)
+	}
+	if !start {
+		logrus.Infof("Run `limactl cluster start %s` to start the instances.", name)
+		return nil
+	}
+	return startCluster(cmd, c)
+}
+
+func newClusterStartCommand() *cobra.Command {
+	clusterStartCommand := &cobra.Command{
+		Use:               "start CLUSTER",
+		Short:             "Start the instances of a cluster in parallel",
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              clusterStartAction,
+		ValidArgsFunction: clusterBashComplete,
+	}
+	clusterStartCommand.Flags().Duration("timeout", instance.DefaultWatchHostAgentEventsTimeout, "duration to wait for the instances to be running before timing out")
+	return clusterStartCommand
+}
+
+func clusterStartAction(cmd *cobra.Command, args []string) error {
+	c, err := cluster.Load(args[0])
+	if err != nil {
+		return err
+	}
+	return startCluster(cmd, c)
+}
+
+func startCluster(cmd *cobra.Command, c *cluster.Cluster) error {
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	var insts []*store.Instance
+	for _, node := range c.Nodes {
+		inst, err := store.Inspect(node.Name)
+		if err != nil {
+			return err
+		}
+		if len(inst.Errors) > 0 {
+			return fmt.Errorf("errors inspecting instance %q: %+v", inst.Name, inst.Errors)
+		}
+		if inst.Status == store.StatusRunning {
+			logrus.Infof("The instance %q is already running", inst.Name)
+			continue
+		}
+		insts = append(insts, inst)
+	}
+	if len(insts) == 0 {
+		return nil
+	}
+	// All the nodes share the cluster network, so the network only needs to be started for one of them
+	if err := reconcile.Reconcile(ctx, insts[0].Name); err != nil {
+		return err
+	}
+	if timeout > 0 {
+		ctx = instance.WithWatchHostAgentTimeout(ctx, timeout)
+	}
+	var g errgroup.Group
+	for _, inst := range insts {
+		g.Go(func() error {
+			if err := instance.Start(ctx, inst, "", false); err != nil {
+				return fmt.Errorf("failed to start instance %q: %w", inst.Name, err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	logrus.Infof("Started %d instances of cluster %q", len(insts), c.Name)
+	return nil
+}
+
+func newClusterStopCommand() *cobra.Command {
+	clusterStopCommand := &cobra.Command{
+		Use:               "stop CLUSTER",
+		Short:             "Stop the instances of a cluster",
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              clusterStopAction,
+		ValidArgsFunction: clusterBashComplete,
+	}
+	clusterStopCommand.Flags().BoolP("force", "f", false, "force stop the instances")
+	return clusterStopCommand
+}
+
+func clusterStopAction(cmd *cobra.Command, args []string) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	c, err := cluster.Load(args[0])
+	if err != nil {
+		return err
+	}
+	var g errgroup.Group
+	for _, node := range c.Nodes {
+		inst, err := store.Inspect(node.Name)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				logrus.Warnf("Ignoring non-existent instance %q", node.Name)
+				continue
+			}
+			return err
+		}
+		if inst.Status == store.StatusStopped {
+			continue
+		}
+		g.Go(func() error {
+			if force {
+				instance.StopForcibly(inst)
+				return nil
+			}
+			if err := instance.StopGracefully(inst); err != nil {
+				return fmt.Errorf("failed to stop instance %q: %w", inst.Name, err)
+			}
+			return nil
+		})
+	}
+	err = g.Wait()
+	// Stop the cluster network, even when some of the instances failed to stop
+	return errors.Join(err, reconcile.Reconcile(cmd.Context(), ""))
+}
+
+func newClusterDeleteCommand() *cobra.Command {
+	clusterDeleteCommand := &cobra.Command{
+		Use:               "delete CLUSTER [CLUSTER, ...]",
+		Aliases:           []string{"remove", "rm"},
+		Short:             "Delete the instances and the network of one or more clusters",
+		Args:              WrapArgsError(cobra.MinimumNArgs(1)),
+		RunE:              clusterDeleteAction,
+		ValidArgsFunction: clusterBashComplete,
+	}
+	clusterDeleteCommand.Flags().BoolP("force", "f", false, "forcibly kill the processes")
+	return clusterDeleteCommand
+}
+
+func clusterDeleteAction(cmd *cobra.Command, args []string) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	ctx := cmd.Context()
+	for _, name := range args {
+		c, err := cluster.Load(name)
+		if err != nil {
+			return err
+		}
+		for _, node := range c.Nodes {
+			inst, err := store.Inspect(node.Name)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return err
+			}
+			if err := instance.Delete(ctx, inst, force); err != nil {
+				return fmt.Errorf("failed to delete instance %q: %w", inst.Name, err)
+			}
+			logrus.Infof("Deleted %q (%q)", inst.Name, inst.Dir)
+		}
+		// Stop the cluster network before removing it
+		if err := reconcile.Reconcile(ctx, ""); err != nil {
+			return err
+		}
+		cfg, err := networks.LoadConfig()
+		if err != nil {
+			return err
+		}
+		if _, ok := cfg.Networks[c.Network]; ok {
+			if err := networks.RemoveNetwork(c.Network); err != nil {
+				return err
+			}
+		}
+		if err := cluster.Remove(name); err != nil {
+			return err
+		}
+		logrus.Infof("Deleted cluster %q", name)
+	}
+	return nil
+}
+
+func newClusterListCommand() *cobra.Command {
+	clusterListCommand := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List clusters",
+		Args:    WrapArgsError(cobra.NoArgs),
+		RunE:    clusterListAction,
+	}
+	return clusterListCommand
+}
+
+func clusterListAction(cmd *cobra.Command, _ []string) error {
+	names, err := cluster.Clusters()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "NAME\tNETWORK\tGATEWAY\tRUNNING\tNODES")
+	for _, name := range names {
+		c, err := cluster.Load(name)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to load cluster %q", name)
+			continue
+		}
+		running := 0
+		nodeNames := make([]string, len(c.Nodes))
+		for i, node := range c.Nodes {
+			nodeNames[i] = node.Name
+			if inst, err := store.Inspect(node.Name); err == nil && inst.Status == store.StatusRunning {
+				running++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\n", c.Name, c.Network, c.Gateway, running, len(c.Nodes), strings.Join(nodeNames, ","))
+	}
+	return w.Flush()
+}
+
+func clusterBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteClusterNames(cmd)
+}
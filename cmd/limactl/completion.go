@@ -1,6 +1,9 @@
 package main
 
 import (
+	"github.com/lima-vm/lima/pkg/cluster"
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/lima-vm/lima/pkg/networks"
 	"github.com/lima-vm/lima/pkg/store"
 	"github.com/lima-vm/lima/pkg/templatestore"
 	"github.com/spf13/cobra"
@@ -31,3 +34,31 @@ func bashCompleteDiskNames(_ *cobra.Command) ([]string, cobra.ShellCompDirective
 	}
 	return disks, cobra.ShellCompDirectiveNoFileComp
 }
+
+func bashCompleteNetworkNames(_ *cobra.Command) ([]string, cobra.ShellCompDirective) {
+	config, err := networks.LoadConfig()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return networkNames(config), cobra.ShellCompDirectiveNoFileComp
+}
+
+func bashCompleteClusterNames(_ *cobra.Command) ([]string, cobra.ShellCompDirective) {
+	clusters, err := cluster.Clusters()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return clusters, cobra.ShellCompDirectiveNoFileComp
+}
+
+func bashCompleteCacheKeys(_ *cobra.Command) ([]string, cobra.ShellCompDirective) {
+	entries, err := downloader.ListCacheEntries(downloader.WithCache())
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	var comp []string
+	for _, entry := range entries {
+		comp = append(comp, entry.Key[:min(len(entry.Key), shortCacheKeyLength)]+"\t"+entry.URL)
+	}
+	return comp, cobra.ShellCompDirectiveNoFileComp
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
+	hostagentclient "github.com/lima-vm/lima/pkg/hostagent/api/client"
 	"github.com/lima-vm/lima/pkg/hostagent/dns"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/store/filenames"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -17,6 +25,7 @@ func newDebugCommand() *cobra.Command {
 		Hidden: true,
 	}
 	cmd.AddCommand(newDebugDNSCommand())
+	cmd.AddCommand(newDebugDNSQueriesCommand())
 	return cmd
 }
 
@@ -29,6 +38,8 @@ func newDebugDNSCommand() *cobra.Command {
 		RunE:  debugDNSAction,
 	}
 	cmd.Flags().BoolP("ipv6", "6", false, "lookup IPv6 addresses too")
+	cmd.Flags().StringSlice("upstream", nil, "encrypted upstream, e.g., \"tls://1.1.1.1\" or \"https://1.1.1.1/dns-query\"")
+	cmd.Flags().StringArray("domain", nil, "server for a domain, e.g., \"corp.example.com=10.0.0.53\"")
 	return cmd
 }
 
@@ -37,6 +48,22 @@ func debugDNSAction(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	upstreams, err := cmd.Flags().GetStringSlice("upstream")
+	if err != nil {
+		return err
+	}
+	domainFlags, err := cmd.Flags().GetStringArray("domain")
+	if err != nil {
+		return err
+	}
+	domains := make(map[string][]string)
+	for _, f := range domainFlags {
+		domain, server, ok := strings.Cut(f, "=")
+		if !ok {
+			return fmt.Errorf("invalid --domain %q, expected DOMAIN=SERVER", f)
+		}
+		domains[domain] = append(domains[domain], server)
+	}
 	udpLocalPort, err := strconv.Atoi(args[0])
 	if err != nil {
 		return err
@@ -55,6 +82,8 @@ func debugDNSAction(cmd *cobra.Command, args []string) error {
 		HandlerOptions: dns.HandlerOptions{
 			IPv6:        ipv6,
 			StaticHosts: map[string]string{},
+			Upstreams:   upstreams,
+			Domains:     domains,
 		},
 	}
 	srv, err := dns.Start(srvOpts)
@@ -66,3 +95,62 @@ func debugDNSAction(cmd *cobra.Command, args []string) error {
 		time.Sleep(time.Hour)
 	}
 }
+
+func newDebugDNSQueriesCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "dns-queries INSTANCE",
+		Short:             "Show the recent queries to the built-in DNS (requires hostResolver.queryLog)",
+		Long:              "DO NOT USE! THE COMMAND SYNTAX IS SUBJECT TO CHANGE!",
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              debugDNSQueriesAction,
+		ValidArgsFunction: debugDNSQueriesBashComplete,
+	}
+	cmd.Flags().Bool("json", false, "JSONify output")
+	return cmd
+}
+
+func debugDNSQueriesAction(cmd *cobra.Command, args []string) error {
+	jsonFormat, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	inst, err := store.Inspect(args[0])
+	if err != nil {
+		return err
+	}
+	if inst.Status != store.StatusRunning {
+		return fmt.Errorf("instance %q is not running", inst.Name)
+	}
+	haClient, err := hostagentclient.NewHostAgentClient(filepath.Join(inst.Dir, filenames.HostAgentSock))
+	if err != nil {
+		return err
+	}
+	queries, err := haClient.DNSQueries(cmd.Context())
+	if err != nil {
+		return err
+	}
+	if jsonFormat {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		for _, q := range queries {
+			if err := enc.Encode(q); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "TIME\tNAME\tTYPE\tRCODE\tLATENCY\tANSWERS")
+	for _, q := range queries {
+		latency := q.Latency.Round(time.Microsecond).String()
+		if q.Cached {
+			latency += " (cached)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			q.Time.Format(time.TimeOnly), q.Name, q.Type, q.Rcode, latency, strings.Join(q.Answers, ", "))
+	}
+	return w.Flush()
+}
+
+func debugDNSQueriesBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteInstanceNames(cmd)
+}
@@ -115,6 +115,12 @@ func editAction(cmd *cobra.Command, args []string) error {
 		logrus.Info("Aborting, no changes made to the instance")
 		return nil
 	}
+	// The base templates added by the edit are embedded, as the instance configuration must be self-contained
+	tmpl := &limatmpl.Template{Locator: filePath, Bytes: yBytes}
+	if err := tmpl.Embed(cmd.Context()); err != nil {
+		return err
+	}
+	yBytes = tmpl.Bytes
 	y, err := limayaml.LoadWithWarnings(yBytes, filePath)
 	if err != nil {
 		return err
@@ -57,6 +57,8 @@ func registerEdit(cmd *cobra.Command, commentPrefix string) {
 		return []string{"lima:shared", "lima:bridged", "lima:host", "lima:user-v2", "vzNAT"}, cobra.ShellCompDirectiveNoFileComp
 	})
 
+	flags.StringArray("param", nil, commentPrefix+"set a template param, e.g., \"--param KEY=VALUE\"")
+
 	flags.Bool("rosetta", false, commentPrefix+"enable Rosetta (for vz instances)")
 
 	flags.String("set", "", commentPrefix+"modify the template inplace, using yq syntax")
@@ -186,6 +188,26 @@ func YQExpressions(flags *flag.FlagSet, newInstance bool) ([]string, error) {
 			false,
 			false,
 		},
+		{
+			"param",
+			func(_ *flag.Flag) (string, error) {
+				ss, err := flags.GetStringArray("param")
+				if err != nil {
+					return "", err
+				}
+				var exprs []string
+				for _, s := range ss {
+					k, v, ok := strings.Cut(s, "=")
+					if !ok || k == "" {
+						return "", fmt.Errorf("param must be specified as \"KEY=VALUE\", got %q", s)
+					}
+					exprs = append(exprs, fmt.Sprintf(`.param[%q] = %q`, k, v))
+				}
+				return strings.Join(exprs, " | "), nil
+			},
+			false,
+			false,
+		},
 		{
 			"rosetta",
 			func(_ *flag.Flag) (string, error) {
@@ -3,6 +3,8 @@ package editflags
 import (
 	"testing"
 
+	"github.com/lima-vm/lima/pkg/yqutil"
+	"github.com/spf13/cobra"
 	"gotest.tools/v3/assert"
 )
 
@@ -20,3 +22,20 @@ func TestCompleteMemoryGiB(t *testing.T) {
 	assert.DeepEqual(t, []float32{1, 2, 4}, completeMemoryGiB(8<<30))
 	assert.DeepEqual(t, []float32{1, 2, 4, 8, 10}, completeMemoryGiB(20<<30))
 }
+
+func TestParamExpressions(t *testing.T) {
+	cmd := &cobra.Command{}
+	RegisterEdit(cmd)
+	assert.NilError(t, cmd.ParseFlags([]string{"--param", "distro=debian", "--param", "greeting=a=b c"}))
+	exprs, err := YQExpressions(cmd.Flags(), true)
+	assert.NilError(t, err)
+	out, err := yqutil.EvaluateExpression(yqutil.Join(exprs), []byte("param:\n  distro: ubuntu\n"))
+	assert.NilError(t, err)
+	assert.Equal(t, string(out), "param:\n  distro: debian\n  greeting: a=b c\n")
+
+	cmd = &cobra.Command{}
+	RegisterEdit(cmd)
+	assert.NilError(t, cmd.ParseFlags([]string{"--param", "distro"}))
+	_, err = YQExpressions(cmd.Flags(), true)
+	assert.ErrorContains(t, err, `param must be specified as "KEY=VALUE"`)
+}
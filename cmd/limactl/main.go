@@ -141,6 +141,8 @@ func newApp() *cobra.Command {
 		newValidateCommand(),
 		newSudoersCommand(),
 		newPruneCommand(),
+		newCacheCommand(),
+		newBundleCommand(),
 		newHostagentCommand(),
 		newInfoCommand(),
 		newShowSSHCommand(),
@@ -149,6 +151,8 @@ func newApp() *cobra.Command {
 		newFactoryResetCommand(),
 		newDiskCommand(),
 		newUsernetCommand(),
+		newNetworkCommand(),
+		newClusterCommand(),
 		newGenDocCommand(),
 		newGenSchemaCommand(),
 		newSnapshotCommand(),
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/lima-vm/lima/pkg/networks/usernet"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newNetworkCommand() *cobra.Command {
+	networkCommand := &cobra.Command{
+		Use:   "network",
+		Short: "Lima network management",
+		Example: `  List networks:
+  $ limactl network ls
+
+  Create a network:
+  $ limactl network create foo --gateway 192.168.42.1/24
+
+  Connect VM instances to the newly created network:
+  $ limactl create --network lima:foo --name vm1
+  $ limactl create --network lima:foo --name vm2
+
+  Show the instances and the daemons of a network:
+  $ limactl network inspect foo
+
+  Capture the DNS traffic of a user-v2 network:
+  $ limactl network capture user-v2 -w dns.pcap udp port 53
+
+  Delete a network:
+  $ limactl network delete foo`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		GroupID:       advancedCommand,
+	}
+	networkCommand.AddCommand(
+		newNetworkListCommand(),
+		newNetworkCreateCommand(),
+		newNetworkDeleteCommand(),
+		newNetworkInspectCommand(),
+		newNetworkCaptureCommand(),
+	)
+	return networkCommand
+}
+
+func newNetworkListCommand() *cobra.Command {
+	networkListCommand := &cobra.Command{
+		Use:     "list",
+		Short:   "List Lima networks",
+		Aliases: []string{"ls"},
+		Args:    WrapArgsError(cobra.NoArgs),
+		RunE:    networkListAction,
+	}
+	networkListCommand.Flags().Bool("json", false, "JSONify output")
+	return networkListCommand
+}
+
+func networkListAction(cmd *cobra.Command, _ []string) error {
+	jsonFormat, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return err
+	}
+	names := networkNames(cfg)
+
+	if jsonFormat {
+		for _, name := range names {
+			j, err := json.Marshal(struct {
+				Name string `json:"name"`
+				networks.Network
+			}{Name: name, Network: cfg.Networks[name]})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(j))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMODE\tGATEWAY\tINTERFACE")
+	for _, name := range names {
+		nw := cfg.Networks[name]
+		gateway := "-"
+		if subnet := nw.Subnet(); subnet != nil {
+			ones, _ := subnet.Mask.Size()
+			gateway = fmt.Sprintf("%s/%d", nw.Gateway, ones)
+		}
+		intf := "-"
+		if nw.Interface != "" {
+			intf = nw.Interface
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, nw.Mode, gateway, intf)
+	}
+	return w.Flush()
+}
+
+func newNetworkCreateCommand() *cobra.Command {
+	networkCreateCommand := &cobra.Command{
+		Use: "create NETWORK",
+		Example: `
+To create a user-v2 network:
+$ limactl network create foo --gateway 192.168.42.1/24
+
+To create a user-v2 network with IPv6 enabled:
+$ limactl network create foo --gateway 192.168.42.1/24 --ipv6-subnet fd00:6c69:6d61::/64
+
+To create a shared network (requires socket_vmnet):
+$ limactl network create bar --mode shared --gateway 192.168.43.1/24
+
+To create a bridged network (requires socket_vmnet):
+$ limactl network create baz --mode bridged --interface en1
+`,
+		Short: "Create a Lima network",
+		Args:  WrapArgsError(cobra.ExactArgs(1)),
+		RunE:  networkCreateAction,
+	}
+	flags := networkCreateCommand.Flags()
+	flags.String("mode", networks.ModeUserV2, fmt.Sprintf("mode, one of: %s", strings.Join(networkModes(), ", ")))
+	_ = networkCreateCommand.RegisterFlagCompletionFunc("mode", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return networkModes(), cobra.ShellCompDirectiveNoFileComp
+	})
+	flags.String("gateway", "", "gateway IP address, optionally with a prefix length (e.g., 192.168.42.1/24)")
+	flags.String("netmask", "", "netmask (default: derived from --gateway, or 255.255.255.0)")
+	flags.String("dhcp-end", "", "last IP address assigned by DHCP (only for host and shared modes)")
+	flags.String("interface", "", "host interface (only for bridged mode)")
+	flags.String("ipv6-subnet", "", "IPv6 /64 subnet, enables IPv6 (only for user-v2 mode)")
+	return networkCreateCommand
+}
+
+func networkModes() []string {
+	return []string{networks.ModeUserV2, networks.ModeHost, networks.ModeShared, networks.ModeBridged}
+}
+
+func networkCreateAction(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	flags := cmd.Flags()
+	mode, err := flags.GetString("mode")
+	if err != nil {
+		return err
+	}
+	gateway, err := flags.GetString("gateway")
+	if err != nil {
+		return err
+	}
+	netmask, err := flags.GetString("netmask")
+	if err != nil {
+		return err
+	}
+	dhcpEnd, err := flags.GetString("dhcp-end")
+	if err != nil {
+		return err
+	}
+	intf, err := flags.GetString("interface")
+	if err != nil {
+		return err
+	}
+	ipv6Subnet, err := flags.GetString("ipv6-subnet")
+	if err != nil {
+		return err
+	}
+
+	nw := networks.Network{
+		Mode:       mode,
+		Interface:  intf,
+		IPv6Subnet: ipv6Subnet,
+	}
+	if gateway != "" {
+		if strings.Contains(gateway, "/") {
+			ip, ipNet, err := net.ParseCIDR(gateway)
+			if err != nil {
+				return fmt.Errorf("failed to parse --gateway %q: %w", gateway, err)
+			}
+			nw.Gateway = ip
+			nw.NetMask = net.IP(ipNet.Mask)
+		} else if nw.Gateway = net.ParseIP(gateway); nw.Gateway == nil {
+			return fmt.Errorf("failed to parse --gateway %q as an IP address", gateway)
+		}
+	}
+	if netmask != "" {
+		if nw.NetMask = net.ParseIP(netmask); nw.NetMask == nil {
+			return fmt.Errorf("failed to parse --netmask %q", netmask)
+		}
+	}
+	if dhcpEnd != "" {
+		if nw.DHCPEnd = net.ParseIP(dhcpEnd); nw.DHCPEnd == nil {
+			return fmt.Errorf("failed to parse --dhcp-end %q as an IP address", dhcpEnd)
+		}
+	}
+	if mode != networks.ModeBridged && nw.Gateway != nil && nw.NetMask == nil {
+		nw.NetMask = net.IPv4(255, 255, 255, 0)
+	}
+
+	if err := networks.AddNetwork(name, nw); err != nil {
+		return err
+	}
+	if mode != networks.ModeUserV2 {
+		cfg, err := networks.LoadConfig()
+		if err != nil {
+			return err
+		}
+		// Networks other than user-v2 are managed via socket_vmnet, so the paths must be secure too.
+		if err := cfg.Validate(); err != nil {
+			logrus.WithError(err).Warnf("Network %q was created, but it cannot be started yet. Please check %s for more information.", name, networksURL)
+		} else {
+			logrus.Infof("Run `limactl sudoers` to update the sudoers file for network %q", name)
+		}
+	}
+	logrus.Infof("Created network %q", name)
+	return nil
+}
+
+func newNetworkDeleteCommand() *cobra.Command {
+	networkDeleteCommand := &cobra.Command{
+		Use:               "delete NETWORK [NETWORK, ...]",
+		Short:             "Delete one or more Lima networks",
+		Aliases:           []string{"remove", "rm"},
+		Args:              WrapArgsError(cobra.MinimumNArgs(1)),
+		RunE:              networkDeleteAction,
+		ValidArgsFunction: networkBashComplete,
+	}
+	networkDeleteCommand.Flags().BoolP("force", "f", false, "delete the network even when it is referenced by instances")
+	return networkDeleteCommand
+}
+
+func networkDeleteAction(cmd *cobra.Command, args []string) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return err
+	}
+	for _, name := range args {
+		if err := cfg.Check(name); err != nil {
+			return err
+		}
+		info, err := inspectNetwork(&cfg, name)
+		if err != nil {
+			return err
+		}
+		for _, daemon := range info.Daemons {
+			if daemon.Running {
+				return fmt.Errorf("network %q is in use: %s daemon is running (pid %d)", name, daemon.Name, daemon.PID)
+			}
+		}
+		if len(info.Instances) > 0 {
+			if !force {
+				return fmt.Errorf("network %q is referenced by instances %v; use --force to delete it anyway", name, info.Instances)
+			}
+			logrus.Warnf("Deleting network %q, which is still referenced by instances %v", name, info.Instances)
+		}
+		if err := networks.RemoveNetwork(name); err != nil {
+			return err
+		}
+		logrus.Infof("Deleted network %q", name)
+	}
+	return nil
+}
+
+func newNetworkInspectCommand() *cobra.Command {
+	networkInspectCommand := &cobra.Command{
+		Use:               "inspect [NETWORK, ...]",
+		Short:             "Display detailed information on one or more Lima networks",
+		Long:              "Display the configuration, the referencing instances, and the daemon status of Lima networks as JSON.",
+		Args:              WrapArgsError(cobra.ArbitraryArgs),
+		RunE:              networkInspectAction,
+		ValidArgsFunction: networkBashComplete,
+	}
+	return networkInspectCommand
+}
+
+// networkDaemon describes a daemon serving a network.
+type networkDaemon struct {
+	Name    string `json:"name"`
+	Running bool   `json:"running"`
+	PID     int    `json:"pid,omitempty"`
+}
+
+// networkInfo is the output of `limactl network inspect`.
+type networkInfo struct {
+	Name      string           `json:"name"`
+	Config    networks.Network `json:"config"`
+	Instances []string         `json:"instances"`
+	Daemons   []networkDaemon  `json:"daemons"`
+}
+
+func networkInspectAction(cmd *cobra.Command, args []string) error {
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return err
+	}
+	names := args
+	if len(names) == 0 {
+		names = networkNames(cfg)
+	}
+	infos := make([]networkInfo, 0, len(names))
+	for _, name := range names {
+		if err := cfg.Check(name); err != nil {
+			return err
+		}
+		info, err := inspectNetwork(&cfg, name)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, *info)
+	}
+	j, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.OutOrStdout(), string(j))
+	return nil
+}
+
+func newNetworkCaptureCommand() *cobra.Command {
+	networkCaptureCommand := &cobra.Command{
+		Use:   "capture NETWORK [FILTER]",
+		Short: "Capture the frames of a user-v2 network in pcap format",
+		Long: `Capture the frames passing the virtual switch of a running user-v2 network in pcap format.
+
+The optional FILTER expression supports a subset of the pcap-filter(7) syntax:
+  arp, ip, ip6, icmp, icmp6, tcp, udp
+  [src|dst] host IP
+  [src|dst] net CIDR
+  [tcp|udp] [src|dst] port PORT
+  ether [src|dst] host MAC
+Primitives can be combined with "and", "or", "not", and parentheses.
+
+The capture runs until interrupted with Ctrl-C, or until --count frames have been captured.`,
+		Example: `
+To capture DNS and DHCP traffic into a file:
+$ limactl network capture user-v2 -w dns.pcap 'udp port 53 or udp port 67'
+
+To watch the traffic of a guest with Wireshark:
+$ limactl network capture user-v2 -w - host 192.168.104.3 | wireshark -k -i -
+`,
+		Args:              WrapArgsError(cobra.MinimumNArgs(1)),
+		RunE:              networkCaptureAction,
+		ValidArgsFunction: networkBashComplete,
+	}
+	networkCaptureCommand.Flags().StringP("write", "w", "", "write the frames to the file (\"-\" for stdout)")
+	_ = networkCaptureCommand.MarkFlagRequired("write")
+	networkCaptureCommand.Flags().Uint64P("count", "c", 0, "exit after capturing the specified number of frames")
+	return networkCaptureCommand
+}
+
+func networkCaptureAction(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	filter := strings.Join(args[1:], " ")
+	target, err := cmd.Flags().GetString("write")
+	if err != nil {
+		return err
+	}
+	count, err := cmd.Flags().GetUint64("count")
+	if err != nil {
+		return err
+	}
+	if _, err := usernet.ParseCaptureFilter(filter); err != nil {
+		return err
+	}
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if isUsernet, err := cfg.Usernet(name); err != nil {
+		return err
+	} else if !isUsernet {
+		return fmt.Errorf("network %q is not a %s network; only %s networks can be captured", name, networks.ModeUserV2, networks.ModeUserV2)
+	}
+	pidFile, err := usernet.PIDFile(name)
+	if err != nil {
+		return err
+	}
+	if pid, _ := store.ReadPIDFile(pidFile); pid == 0 {
+		return fmt.Errorf("network %q is not running; start an instance connected to it first", name)
+	}
+	client := usernet.NewClientByName(name)
+	if client == nil {
+		return fmt.Errorf("failed to create a client for network %q", name)
+	}
+
+	writer := cmd.OutOrStdout()
+	if target != "-" {
+		file, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+		logrus.Infof("Capturing frames of network %q into %q, press Ctrl-C to stop", name, target)
+	}
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	return client.Capture(ctx, writer, filter, count)
+}
+
+func inspectNetwork(cfg *networks.Config, name string) (*networkInfo, error) {
+	info := &networkInfo{
+		Name:      name,
+		Config:    cfg.Networks[name],
+		Instances: []string{},
+		Daemons:   []networkDaemon{},
+	}
+	instNames, err := store.Instances()
+	if err != nil {
+		return nil, err
+	}
+	for _, instName := range instNames {
+		inst, err := store.Inspect(instName)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, nw := range inst.Networks {
+			if nw.Lima == name {
+				info.Instances = append(info.Instances, instName)
+				break
+			}
+		}
+	}
+
+	var pidFile string
+	daemon := networks.SocketVMNet
+	if info.Config.Mode == networks.ModeUserV2 {
+		daemon = "usernet"
+		if pidFile, err = usernet.PIDFile(name); err != nil {
+			return nil, err
+		}
+	} else if cfg.Paths.VarRun != "" {
+		pidFile = cfg.PIDFile(name, daemon)
+	}
+	d := networkDaemon{Name: daemon}
+	if pidFile != "" {
+		if d.PID, err = store.ReadPIDFile(pidFile); err != nil {
+			logrus.WithError(err).Debugf("failed to read %q", pidFile)
+		}
+		d.Running = d.PID != 0
+	}
+	info.Daemons = append(info.Daemons, d)
+	return info, nil
+}
+
+func networkNames(cfg networks.Config) []string {
+	names := make([]string, 0, len(cfg.Networks))
+	for name := range cfg.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func networkBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteNetworkNames(cmd)
+}
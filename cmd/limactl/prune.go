@@ -4,9 +4,6 @@ import (
 	"os"
 
 	"github.com/lima-vm/lima/pkg/downloader"
-	"github.com/lima-vm/lima/pkg/limayaml"
-	"github.com/lima-vm/lima/pkg/store"
-	"github.com/lima-vm/lima/pkg/templatestore"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -39,13 +36,13 @@ func pruneAction(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
-	knownLocations, err := knownLocations()
+	referrers, err := downloader.CacheReferrers()
 	if err != nil {
 		return err
 	}
 	for cacheKey, cachePath := range cacheEntries {
-		if file, exists := knownLocations[cacheKey]; exists {
-			logrus.Debugf("Keep %q caching %q", cacheKey, file.Location)
+		if names, exists := referrers[cacheKey]; exists {
+			logrus.Debugf("Keep %q referred by %v", cacheKey, names)
 		} else {
 			logrus.Debug("Deleting ", cacheKey)
 			if err := os.RemoveAll(cachePath); err != nil {
@@ -56,62 +53,3 @@ func pruneAction(cmd *cobra.Command, _ []string) error {
 	}
 	return nil
 }
-
-func knownLocations() (map[string]limayaml.File, error) {
-	locations := make(map[string]limayaml.File)
-
-	// Collect locations from instances
-	instances, err := store.Instances()
-	if err != nil {
-		return nil, err
-	}
-	for _, instanceName := range instances {
-		instance, err := store.Inspect(instanceName)
-		if err != nil {
-			return nil, err
-		}
-		for k, v := range locationsFromLimaYAML(instance.Config) {
-			locations[k] = v
-		}
-	}
-
-	// Collect locations from templates
-	templates, err := templatestore.Templates()
-	if err != nil {
-		return nil, err
-	}
-	for _, t := range templates {
-		b, err := templatestore.Read(t.Name)
-		if err != nil {
-			return nil, err
-		}
-		y, err := limayaml.Load(b, t.Name)
-		if err != nil {
-			return nil, err
-		}
-		for k, v := range locationsFromLimaYAML(y) {
-			locations[k] = v
-		}
-	}
-	return locations, nil
-}
-
-func locationsFromLimaYAML(y *limayaml.LimaYAML) map[string]limayaml.File {
-	locations := make(map[string]limayaml.File)
-	for _, f := range y.Images {
-		locations[downloader.CacheKey(f.Location)] = f.File
-		if f.Kernel != nil {
-			locations[downloader.CacheKey(f.Kernel.Location)] = f.Kernel.File
-		}
-		if f.Initrd != nil {
-			locations[downloader.CacheKey(f.Initrd.Location)] = *f.Initrd
-		}
-	}
-	for _, f := range y.Containerd.Archives {
-		locations[downloader.CacheKey(f.Location)] = f
-	}
-	for _, f := range y.Firmware.Images {
-		locations[downloader.CacheKey(f.Location)] = f.File
-	}
-	return locations
-}
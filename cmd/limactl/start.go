@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/containerd/containerd/identifiers"
@@ -198,6 +200,10 @@ func loadOrCreateInstance(cmd *cobra.Command, args []string, createOnly bool) (*
 		return nil, err
 	}
 
+	// The base templates are embedded before applying the flags, so that the flags take precedence over them
+	if err := tmpl.Embed(cmd.Context()); err != nil {
+		return nil, err
+	}
 	yqExprs, err := editflags.YQExpressions(flags, true)
 	if err != nil {
 		return nil, err
@@ -205,7 +211,7 @@ func loadOrCreateInstance(cmd *cobra.Command, args []string, createOnly bool) (*
 	yq := yqutil.Join(yqExprs)
 	if tty {
 		var err error
-		tmpl, err = chooseNextCreatorState(tmpl, yq)
+		tmpl, err = chooseNextCreatorState(cmd.Context(), tmpl, yq)
 		if err != nil {
 			return nil, err
 		}
@@ -215,6 +221,15 @@ func loadOrCreateInstance(cmd *cobra.Command, args []string, createOnly bool) (*
 			return nil, err
 		}
 	}
+	// The template may have been replaced, or edited to have base templates
+	if err := tmpl.Embed(cmd.Context()); err != nil {
+		return nil, err
+	}
+	if tty {
+		if err := promptParams(tmpl); err != nil {
+			return nil, err
+		}
+	}
 	saveBrokenYAML := tty
 	return instance.Create(cmd.Context(), tmpl.Name, tmpl.Bytes, saveBrokenYAML)
 }
@@ -276,7 +291,7 @@ func (exitSuccessError) ExitCode() int {
 	return 0
 }
 
-func chooseNextCreatorState(tmpl *limatmpl.Template, yq string) (*limatmpl.Template, error) {
+func chooseNextCreatorState(ctx context.Context, tmpl *limatmpl.Template, yq string) (*limatmpl.Template, error) {
 	for {
 		if err := modifyInPlace(tmpl, yq); err != nil {
 			logrus.WithError(err).Warn("Failed to evaluate yq expression")
@@ -343,10 +358,14 @@ func chooseNextCreatorState(tmpl *limatmpl.Template, yq string) (*limatmpl.Templ
 					return nil, err
 				}
 			}
+			tmpl.Locator = yamlPath
 			tmpl.Bytes, err = os.ReadFile(yamlPath)
 			if err != nil {
 				return nil, err
 			}
+			if err := tmpl.Embed(ctx); err != nil {
+				return nil, err
+			}
 			continue
 		case 3: // "Exit"
 			return nil, exitSuccessError{Msg: "Choosing to exit"}
@@ -356,6 +375,64 @@ func chooseNextCreatorState(tmpl *limatmpl.Template, yq string) (*limatmpl.Templ
 	}
 }
 
+// promptParams prompts for the params in the `paramSchema` of the template that are not set in the template
+// nor with `--param`. The defaults of the schema are proposed as the default answers.
+func promptParams(tmpl *limatmpl.Template) error {
+	var y limayaml.LimaYAML
+	if err := limayaml.Unmarshal(tmpl.Bytes, &y, fmt.Sprintf("template %q", tmpl.Locator)); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(y.ParamSchema))
+	for k := range y.ParamSchema {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	var exprs []string
+	for _, k := range keys {
+		if _, ok := y.Param[k]; ok {
+			continue
+		}
+		schema := y.ParamSchema[k]
+		message := fmt.Sprintf("param %q", k)
+		if len(schema.Enum) > 0 {
+			message += fmt.Sprintf(" (%s)", strings.Join(schema.Enum, ", "))
+		} else if schema.Type != nil && *schema.Type != limayaml.ParamTypeString {
+			message += fmt.Sprintf(" (%s)", *schema.Type)
+		}
+		var help, def string
+		if schema.Description != nil {
+			help = *schema.Description
+		}
+		if schema.Default != nil {
+			def = *schema.Default
+		}
+		required := schema.Required != nil && *schema.Required
+		ans, err := uiutil.Input(message, help, def, func(s string) error {
+			if s == "" {
+				if required {
+					return errors.New("required")
+				}
+				return nil
+			}
+			return limayaml.ValidateParamValue(schema, s)
+		})
+		if err != nil {
+			if errors.Is(err, uiutil.InterruptErr) {
+				logrus.Fatal("Interrupted by user")
+			}
+			logrus.WithError(err).Warn("Failed to open TUI")
+			return nil
+		}
+		if ans != "" {
+			exprs = append(exprs, fmt.Sprintf(`.param[%q] = %q`, k, ans))
+		}
+	}
+	if len(exprs) == 0 {
+		return nil
+	}
+	return modifyInPlace(tmpl, yqutil.Join(exprs))
+}
+
 // createStartActionCommon is shared by createAction and startAction.
 func createStartActionCommon(cmd *cobra.Command, _ []string) (exit bool, err error) {
 	if listTemplates, err := cmd.Flags().GetBool("list-templates"); err != nil {
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/lima-vm/lima/pkg/limatmpl"
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/lima-vm/lima/pkg/store/dirnames"
+	"github.com/lima-vm/lima/pkg/templatestore"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -20,24 +24,139 @@ func newTemplateCommand() *cobra.Command {
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		GroupID:       advancedCommand,
-		// The template command is still hidden because the subcommands and options are still under development
-		// and subject to change at any time.
-		Hidden: true,
 	}
 	templateCommand.AddCommand(
+		newTemplateListCommand(),
+		newTemplateShowCommand(),
 		newTemplateCopyCommand(),
 		newTemplateValidateCommand(),
+		newTemplateRepoCommand(),
 	)
 	return templateCommand
 }
 
-// The validate command exists for backwards compatibility, and because the template command is still hidden.
+// The validate command exists for backwards compatibility.
 func newValidateCommand() *cobra.Command {
 	validateCommand := newTemplateValidateCommand()
 	validateCommand.GroupID = advancedCommand
 	return validateCommand
 }
 
+func newTemplateListCommand() *cobra.Command {
+	templateListCommand := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the templates",
+		Long: `List the templates in the template search path.
+
+The description and the tags are read from the "metadata" field of the template.
+The description defaults to the first comment line of the template.`,
+		Args:              WrapArgsError(cobra.NoArgs),
+		RunE:              templateListAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	templateListCommand.Flags().Bool("json", false, "JSONify output")
+	return templateListCommand
+}
+
+func templateListAction(cmd *cobra.Command, _ []string) error {
+	jsonFormat, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	templates, err := templatestore.Templates()
+	if err != nil {
+		return err
+	}
+	var infos []*limatmpl.Info
+	for _, t := range templates {
+		info, err := limatmpl.Inspect(cmd.Context(), t)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to inspect template %q", t.Name)
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	if jsonFormat {
+		for _, info := range infos {
+			j, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), string(j))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	fmt.Fprintln(w, "NAME\tOS\tARCH\tVMTYPE\tTAGS\tDESCRIPTION")
+	for _, info := range infos {
+		tags := strings.Join(info.Tags, ",")
+		if tags == "" {
+			tags = "-"
+		}
+		description := info.Description
+		if info.Deprecated {
+			description = strings.TrimSpace("(deprecated) " + description)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", info.Name, info.OS, strings.Join(info.Arch, ","),
+			strings.Join(info.VMTypes, ","), tags, description)
+	}
+	return w.Flush()
+}
+
+func newTemplateShowCommand() *cobra.Command {
+	templateShowCommand := &cobra.Command{
+		Use:   "show TEMPLATE",
+		Short: "Show the template with the defaults filled",
+		Long: `Show the template with the base templates embedded and the defaults filled.
+TEMPLATE is a template name (e.g., "docker"), or a template locator.`,
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              templateShowAction,
+		ValidArgsFunction: templateShowBashComplete,
+	}
+	return templateShowCommand
+}
+
+func templateShowAction(cmd *cobra.Command, args []string) error {
+	locator := args[0]
+	if !strings.Contains(locator, "://") && !limatmpl.SeemsYAMLPath(locator) && locator != "-" {
+		locator = "template://" + locator
+	}
+	tmpl, err := limatmpl.Read(cmd.Context(), "", locator)
+	if err != nil {
+		return err
+	}
+	if len(tmpl.Bytes) == 0 {
+		return fmt.Errorf("don't know how to interpret %q as a template locator", args[0])
+	}
+	if tmpl.Name == "" {
+		return fmt.Errorf("can't determine instance name from template locator %q", args[0])
+	}
+	if err := tmpl.Embed(cmd.Context()); err != nil {
+		return err
+	}
+	limaDir, err := dirnames.LimaDir()
+	if err != nil {
+		return err
+	}
+	y, err := limayaml.Load(tmpl.Bytes, filepath.Join(limaDir, tmpl.Name))
+	if err != nil {
+		return err
+	}
+	b, err := limayaml.Marshal(y, false)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(cmd.OutOrStdout(), string(b))
+	return err
+}
+
+func templateShowBashComplete(cmd *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	return bashCompleteTemplateNames(cmd)
+}
+
 var templateCopyExample = `  Template locators are local files, file://, https://, or template:// URLs
 
   # Copy default template to STDOUT
@@ -45,6 +164,9 @@ var templateCopyExample = `  Template locators are local files, file://, https:/
 
   # Copy template from web location to local file
   limactl template copy https://example.com/lima.yaml mighty-machine.yaml
+
+  # Copy template with the base templates embedded
+  limactl template copy --embed ./docker-dev.yaml docker-dev-full.yaml
 `
 
 func newTemplateCopyCommand() *cobra.Command {
@@ -56,10 +178,15 @@ func newTemplateCopyCommand() *cobra.Command {
 		Args:    WrapArgsError(cobra.ExactArgs(2)),
 		RunE:    templateCopyAction,
 	}
+	templateCopyCommand.Flags().Bool("embed", false, "embed the base templates")
 	return templateCopyCommand
 }
 
 func templateCopyAction(cmd *cobra.Command, args []string) error {
+	embed, err := cmd.Flags().GetBool("embed")
+	if err != nil {
+		return err
+	}
 	tmpl, err := limatmpl.Read(cmd.Context(), "", args[0])
 	if err != nil {
 		return err
@@ -67,6 +194,11 @@ func templateCopyAction(cmd *cobra.Command, args []string) error {
 	if len(tmpl.Bytes) == 0 {
 		return fmt.Errorf("don't know how to interpret %q as a template locator", args[0])
 	}
+	if embed {
+		if err := tmpl.Embed(cmd.Context()); err != nil {
+			return err
+		}
+	}
 	writer := cmd.OutOrStdout()
 	target := args[1]
 	if target != "-" {
@@ -113,6 +245,9 @@ func templateValidateAction(cmd *cobra.Command, args []string) error {
 		if tmpl.Name == "" {
 			return fmt.Errorf("can't determine instance name from template locator %q", arg)
 		}
+		if err := tmpl.Embed(cmd.Context()); err != nil {
+			return err
+		}
 		// Load() will merge the template with override.yaml and default.yaml via FillDefaults().
 		// FillDefaults() needs the potential instance directory to validate host templates using {{.Dir}}.
 		instDir := filepath.Join(limaDir, tmpl.Name)
@@ -135,3 +270,126 @@ func templateValidateAction(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+func newTemplateRepoCommand() *cobra.Command {
+	templateRepoCommand := &cobra.Command{
+		Use:   "repo",
+		Short: "Manage template repositories",
+		Long: `Manage template repositories.
+
+A template repository is a catalog index file (YAML or JSON) published over HTTPS:
+
+  templates:
+  - name: dev
+    url: dev.yaml  # resolved against the URL of the catalog
+    digest: sha256:...
+
+The templates of a repository "team" are referred as "template://team/dev".
+The catalog and the templates are cached in the download cache, and the templates are verified by the digest.`,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	templateRepoCommand.AddCommand(
+		newTemplateRepoAddCommand(),
+		newTemplateRepoListCommand(),
+		newTemplateRepoRemoveCommand(),
+	)
+	return templateRepoCommand
+}
+
+func newTemplateRepoAddCommand() *cobra.Command {
+	templateRepoAddCommand := &cobra.Command{
+		Use:               "add NAME URL",
+		Example:           "  limactl template repo add team https://example.com/lima/index.yaml",
+		Short:             "Add a template repository",
+		Args:              WrapArgsError(cobra.ExactArgs(2)),
+		RunE:              templateRepoAddAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	return templateRepoAddCommand
+}
+
+func templateRepoAddAction(cmd *cobra.Command, args []string) error {
+	catalog, err := limatmpl.AddRepo(cmd.Context(), limatmpl.Repo{Name: args[0], URL: args[1]})
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Added the template repository %q with %d templates", args[0], len(catalog.Templates))
+	return nil
+}
+
+func newTemplateRepoListCommand() *cobra.Command {
+	templateRepoListCommand := &cobra.Command{
+		Use:               "list",
+		Aliases:           []string{"ls"},
+		Short:             "List the template repositories",
+		Args:              WrapArgsError(cobra.NoArgs),
+		RunE:              templateRepoListAction,
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+	templateRepoListCommand.Flags().Bool("templates", false, "list the templates of the repositories")
+	return templateRepoListCommand
+}
+
+func templateRepoListAction(cmd *cobra.Command, _ []string) error {
+	listTemplates, err := cmd.Flags().GetBool("templates")
+	if err != nil {
+		return err
+	}
+	repos, err := limatmpl.Repos()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 4, 8, 4, ' ', 0)
+	if !listTemplates {
+		fmt.Fprintln(w, "NAME\tURL")
+		for _, repo := range repos {
+			fmt.Fprintf(w, "%s\t%s\n", repo.Name, repo.URL)
+		}
+		return w.Flush()
+	}
+	fmt.Fprintln(w, "TEMPLATE\tDESCRIPTION")
+	for _, repo := range repos {
+		catalog, err := limatmpl.FetchCatalog(cmd.Context(), repo)
+		if err != nil {
+			logrus.WithError(err).Warnf("Failed to fetch the catalog of the template repository %q", repo.Name)
+			continue
+		}
+		for _, e := range catalog.Templates {
+			fmt.Fprintf(w, "template://%s/%s\t%s\n", repo.Name, e.Name, e.Description)
+		}
+	}
+	return w.Flush()
+}
+
+func newTemplateRepoRemoveCommand() *cobra.Command {
+	templateRepoRemoveCommand := &cobra.Command{
+		Use:               "remove NAME",
+		Aliases:           []string{"rm"},
+		Short:             "Remove a template repository",
+		Args:              WrapArgsError(cobra.ExactArgs(1)),
+		RunE:              templateRepoRemoveAction,
+		ValidArgsFunction: templateRepoRemoveBashComplete,
+	}
+	return templateRepoRemoveCommand
+}
+
+func templateRepoRemoveAction(_ *cobra.Command, args []string) error {
+	if err := limatmpl.RemoveRepo(args[0]); err != nil {
+		return err
+	}
+	logrus.Infof("Removed the template repository %q", args[0])
+	return nil
+}
+
+func templateRepoRemoveBashComplete(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	repos, err := limatmpl.Repos()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	var comp []string
+	for _, repo := range repos {
+		comp = append(comp, repo.Name)
+	}
+	return comp, cobra.ShellCompDirectiveNoFileComp
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 
+	"github.com/lima-vm/lima/pkg/networks"
 	"github.com/lima-vm/lima/pkg/networks/usernet"
 	"github.com/spf13/cobra"
 )
@@ -22,8 +25,9 @@ func newUsernetCommand() *cobra.Command {
 	hostagentCommand.Flags().StringP("endpoint", "e", "", "exposes usernet api(s) on this endpoint")
 	hostagentCommand.Flags().String("listen-qemu", "", "listen for qemu connections")
 	hostagentCommand.Flags().String("listen", "", "listen on a Unix socket and receive Bess-compatible FDs as SCM_RIGHTS messages")
-	hostagentCommand.Flags().String("subnet", "192.168.5.0/24", "sets subnet value for the usernet network")
+	hostagentCommand.Flags().StringSlice("subnet", []string{"192.168.5.0/24"}, "sets subnet value for the usernet network, specify an additional IPv6 /64 subnet to enable IPv6")
 	hostagentCommand.Flags().Int("mtu", 1500, "mtu")
+	hostagentCommand.Flags().String("egress-policy", "", "restrict the connections to the outside with the egress policy, in JSON")
 	hostagentCommand.Flags().StringToString("leases", nil, "pass default static leases for startup. Eg: '192.168.104.1=52:55:55:b3:bc:d9,192.168.104.2=5a:94:ef:e4:0c:df' ")
 	return hostagentCommand
 }
@@ -54,10 +58,31 @@ func usernetAction(cmd *cobra.Command, _ []string) error {
 	if err != nil {
 		return err
 	}
-	subnet, err := cmd.Flags().GetString("subnet")
+	subnets, err := cmd.Flags().GetStringSlice("subnet")
 	if err != nil {
 		return err
 	}
+	var subnet, subnetIPv6 string
+	for _, s := range subnets {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("invalid subnet %q: %w", s, err)
+		}
+		if prefix.Addr().Is4() {
+			if subnet != "" {
+				return fmt.Errorf("only one IPv4 subnet can be specified, got %q and %q", subnet, s)
+			}
+			subnet = s
+		} else {
+			if subnetIPv6 != "" {
+				return fmt.Errorf("only one IPv6 subnet can be specified, got %q and %q", subnetIPv6, s)
+			}
+			subnetIPv6 = s
+		}
+	}
+	if subnet == "" {
+		return errors.New("an IPv4 subnet must be specified")
+	}
 
 	leases, err := cmd.Flags().GetStringToString("leases")
 	if err != nil {
@@ -69,6 +94,20 @@ func usernetAction(cmd *cobra.Command, _ []string) error {
 		return err
 	}
 
+	egressPolicyJSON, err := cmd.Flags().GetString("egress-policy")
+	if err != nil {
+		return err
+	}
+	var egressPolicy *networks.EgressPolicy
+	if egressPolicyJSON != "" {
+		if err := json.Unmarshal([]byte(egressPolicyJSON), &egressPolicy); err != nil {
+			return fmt.Errorf("failed to parse --egress-policy: %w", err)
+		}
+		if err := egressPolicy.Validate(); err != nil {
+			return fmt.Errorf("invalid --egress-policy: %w", err)
+		}
+	}
+
 	os.RemoveAll(endpoint)
 	os.RemoveAll(qemuSocket)
 	os.RemoveAll(fdSocket)
@@ -82,6 +121,8 @@ func usernetAction(cmd *cobra.Command, _ []string) error {
 		QemuSocket:    qemuSocket,
 		FdSocket:      fdSocket,
 		Subnet:        subnet,
+		SubnetIPv6:    subnetIPv6,
+		EgressPolicy:  egressPolicy,
 		DefaultLeases: leases,
 	})
 }
@@ -0,0 +1,229 @@
+// Package bundle creates and imports offline bundles, which contain a template and the files
+// that the template refers to (images, kernels, containerd archives, and firmware),
+// so that instances can be started on hosts without the internet connection.
+//
+// A bundle is a tar archive with the following entries, in this order:
+//   - "lima-bundle.json": the Manifest
+//   - "<NAME>.yaml": the template
+//   - "files/<SHA256_OF_URL>": the files, in the order of Manifest.Files
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/lima-vm/lima/pkg/fileutils"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/yqutil"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ManifestName is the name of the manifest entry in the bundle.
+	ManifestName = "lima-bundle.json"
+	// Version is the version of the bundle format.
+	Version = 1
+)
+
+// Manifest describes the contents of the bundle.
+type Manifest struct {
+	Version  int    `json:"version"`
+	Template string `json:"template"` // name of the template entry, e.g., "default.yaml"
+	Files    []File `json:"files"`
+}
+
+// File is a file in the bundle.
+type File struct {
+	Location string        `json:"location"`
+	Arch     limayaml.Arch `json:"arch,omitempty"`
+	Digest   digest.Digest `json:"digest"` // sha256 digest of the data, computed when the bundle is created
+	Size     int64         `json:"size"`
+	Path     string        `json:"path"` // name of the entry in the bundle
+	// LastModified and ContentType are the headers of the HTTP response, used to check the update of digest-less files
+	LastModified time.Time `json:"lastModified"`
+	ContentType  string    `json:"contentType,omitempty"`
+}
+
+// Create downloads the files into the cache, and writes the bundle of the template and the files into w.
+//
+// The `<ALGO>sums:URL` digests in the template are replaced with the digests of the files,
+// as the checksum files cannot be fetched on offline hosts.
+func Create(ctx context.Context, w io.Writer, name string, template []byte, files []limayaml.File, sig limayaml.ChecksumSignature) error {
+	manifest := Manifest{
+		Version:  Version,
+		Template: name + ".yaml",
+	}
+	cachePaths := make(map[string]string)
+	for _, f := range files {
+		if _, ok := cachePaths[f.Location]; ok {
+			continue
+		}
+		if downloader.IsLocal(f.Location) {
+			return fmt.Errorf("local file %q cannot be bundled", f.Location)
+		}
+		cachePath, err := fileutils.DownloadFile(ctx, "", f, false, "the file", f.Arch, sig)
+		if err != nil {
+			return err
+		}
+		d, size, err := digestFile(cachePath)
+		if err != nil {
+			return err
+		}
+		cached, err := downloader.Cached(f.Location, downloader.WithCache())
+		if err != nil {
+			return err
+		}
+		if f.Digest != "" && strings.HasSuffix(f.Digest.Algorithm().String(), "sums") {
+			expr := fmt.Sprintf(`(.. | select(tag == "!!map" and .location == %q and .digest == %q) | .digest) = %q`, f.Location, f.Digest, d)
+			template, err = yqutil.EvaluateExpression(expr, template)
+			if err != nil {
+				return err
+			}
+		}
+		cachePaths[f.Location] = cachePath
+		manifest.Files = append(manifest.Files, File{
+			Location: f.Location,
+			Arch:     f.Arch,
+			Digest:   d,
+			Size:     size,
+			Path:     path.Join("files", downloader.CacheKey(f.Location)),
+
+			LastModified: cached.LastModified,
+			ContentType:  cached.ContentType,
+		})
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := writeEntry(tw, ManifestName, int64(len(manifestJSON)), bytes.NewReader(manifestJSON)); err != nil {
+		return err
+	}
+	if err := writeEntry(tw, manifest.Template, int64(len(template)), bytes.NewReader(template)); err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		logrus.Infof("Adding %q (%s) to the bundle", f.Location, f.Arch)
+		if err := writeFileEntry(tw, f.Path, f.Size, cachePaths[f.Location]); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func digestFile(p string) (digest.Digest, int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	digester := digest.SHA256.Digester()
+	size, err := io.Copy(digester.Hash(), f)
+	if err != nil {
+		return "", 0, err
+	}
+	return digester.Digest(), size, nil
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+func writeFileEntry(tw *tar.Writer, name string, size int64, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The file may be replaced in the cache after computing the digest; the digest is verified on importing
+	return writeEntry(tw, name, size, io.LimitReader(f, size))
+}
+
+// Bundle is the result of Import.
+type Bundle struct {
+	Manifest Manifest
+	Template []byte
+}
+
+// Import imports the files in the bundle read from r into the cache, and returns the manifest and the template.
+// The files are verified with the digests in the manifest.
+func Import(r io.Reader, opts ...downloader.Opt) (*Bundle, error) {
+	tr := tar.NewReader(r)
+	var b Bundle
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the bundle: %w", err)
+	}
+	if hdr.Name != ManifestName {
+		return nil, fmt.Errorf("not a Lima bundle: the first entry must be %q, got %q", ManifestName, hdr.Name)
+	}
+	if err := json.NewDecoder(io.LimitReader(tr, 16<<20)).Decode(&b.Manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode %q: %w", ManifestName, err)
+	}
+	if b.Manifest.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Manifest.Version)
+	}
+	files := make(map[string]File)
+	for _, f := range b.Manifest.Files {
+		files[f.Path] = f
+	}
+	imported := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the bundle: %w", err)
+		}
+		switch {
+		case hdr.Name == b.Manifest.Template:
+			b.Template, err = io.ReadAll(io.LimitReader(tr, 16<<20))
+			if err != nil {
+				return nil, err
+			}
+		case files[hdr.Name].Location != "":
+			f := files[hdr.Name]
+			logrus.Infof("Importing %q (%s) into the cache", f.Location, f.Arch)
+			if err := downloader.ImportCache(f.Location, tr, f.Digest, f.LastModified, f.ContentType, opts...); err != nil {
+				return nil, fmt.Errorf("failed to import %q: %w", f.Location, err)
+			}
+			imported[hdr.Name] = true
+		default:
+			logrus.Warnf("Ignoring unknown entry %q in the bundle", hdr.Name)
+		}
+	}
+	if b.Template == nil {
+		return nil, fmt.Errorf("the bundle does not contain the template %q", b.Manifest.Template)
+	}
+	for _, f := range b.Manifest.Files {
+		if !imported[f.Path] {
+			return nil, fmt.Errorf("the bundle does not contain %q", f.Location)
+		}
+	}
+	return &b, nil
+}
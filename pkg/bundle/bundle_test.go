@@ -0,0 +1,98 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/v3/assert"
+)
+
+func TestCreateAndImport(t *testing.T) {
+	downloader.HideProgress = true
+	// fileutils.DownloadFile uses the user cache dir
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	dir := t.TempDir()
+	image := []byte("disk image")
+	kernel := []byte("kernel")
+	imageDigest := digest.FromBytes(image)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "image.img"), image, 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "vmlinuz"), kernel, 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "SHA256SUMS"), []byte(imageDigest.Encoded()+"  image.img\n"), 0o644))
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(ts.Close)
+
+	sumsDigest := digest.Digest("sha256sums:" + ts.URL + "/SHA256SUMS")
+	template := []byte(fmt.Sprintf(`# comment
+images:
+- location: %q
+  arch: x86_64
+  digest: %q
+  kernel:
+    location: %q
+`, ts.URL+"/image.img", sumsDigest, ts.URL+"/vmlinuz"))
+	files := []limayaml.File{
+		{Location: ts.URL + "/image.img", Arch: limayaml.X8664, Digest: sumsDigest},
+		{Location: ts.URL + "/vmlinuz", Arch: limayaml.X8664},
+	}
+	var buf bytes.Buffer
+	assert.NilError(t, Create(context.Background(), &buf, "test", template, files, limayaml.ChecksumSignature{}))
+	ts.Close()
+
+	cacheDir := t.TempDir()
+	b, err := Import(bytes.NewReader(buf.Bytes()), downloader.WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, b.Manifest.Template, "test.yaml")
+	assert.Equal(t, len(b.Manifest.Files), 2)
+	// The checksum file cannot be fetched offline
+	assert.Assert(t, !strings.Contains(string(b.Template), "sha256sums:"))
+	assert.Assert(t, strings.Contains(string(b.Template), imageDigest.String()))
+	assert.Assert(t, strings.Contains(string(b.Template), "# comment"))
+
+	// The files are available offline
+	localPath := filepath.Join(t.TempDir(), "image")
+	r, err := downloader.Download(context.Background(), localPath, files[0].Location,
+		downloader.WithCacheDir(cacheDir), downloader.WithExpectedDigest(imageDigest))
+	assert.NilError(t, err)
+	assert.Equal(t, r.Status, downloader.StatusUsedCache)
+	got, err := os.ReadFile(localPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(got), string(image))
+	r, err = downloader.Download(context.Background(), "", files[1].Location, downloader.WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, r.Status, downloader.StatusUsedCache)
+}
+
+func TestImportTampered(t *testing.T) {
+	manifest := fmt.Sprintf(`{"version": 1, "template": "test.yaml", "files": [{"location": "https://example.com/image.img", "digest": %q, "path": "files/image"}]}`,
+		digest.FromString("disk image"))
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range []struct{ name, data string }{
+		{ManifestName, manifest},
+		{"test.yaml", "images: []\n"},
+		{"files/image", "tampered disk image"},
+	} {
+		assert.NilError(t, writeEntry(tw, entry.name, int64(len(entry.data)), strings.NewReader(entry.data)))
+	}
+	assert.NilError(t, tw.Close())
+
+	_, err := Import(bytes.NewReader(buf.Bytes()), downloader.WithCacheDir(t.TempDir()))
+	assert.ErrorContains(t, err, "expected digest")
+
+	_, err = Import(io.LimitReader(bytes.NewReader(buf.Bytes()), 10), downloader.WithCacheDir(t.TempDir()))
+	assert.ErrorContains(t, err, "failed to read the bundle")
+}
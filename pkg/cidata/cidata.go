@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
@@ -153,6 +154,18 @@ func templateArgs(bootScripts bool, instDir, name string, instConfig *limayaml.L
 		}
 		args.SlirpGateway = usernet.GatewayIP(subnet)
 		args.SlirpDNS = usernet.GatewayIP(subnet)
+		subnetIPv6, err := usernet.IPv6Subnet(usernetName)
+		if err != nil {
+			return nil, err
+		}
+		if subnetIPv6.IsValid() {
+			addr, err := usernet.IPv6Address(subnetIPv6, limayaml.MACAddress(instDir))
+			if err != nil {
+				return nil, err
+			}
+			args.SlirpIPv6Address = netip.PrefixFrom(addr, subnetIPv6.Bits()).String()
+			args.SlirpIPv6Gateway = usernet.IPv6GatewayIP(subnetIPv6).String()
+		}
 	} else {
 		subnet, _, err = net.ParseCIDR(networks.SlirpNetwork)
 		if err != nil {
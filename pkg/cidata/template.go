@@ -75,6 +75,8 @@ type TemplateArgs struct {
 	SlirpGateway                    string
 	SlirpDNS                        string
 	SlirpIPAddress                  string
+	SlirpIPv6Address                string // with the prefix length, e.g. "fd00:6c69:6d61:0:5055:55ff:fe12:3456/64"
+	SlirpIPv6Gateway                string
 	UDPDNSLocalPort                 int
 	TCPDNSLocalPort                 int
 	Env                             map[string]string
@@ -115,3 +115,34 @@ func TestTemplate9p(t *testing.T) {
 		}
 	}
 }
+
+func TestTemplateIPv6(t *testing.T) {
+	args := &TemplateArgs{
+		Name: "default",
+		User: "foo",
+		UID:  501,
+		Home: "/home/foo.linux",
+		SSHPubKeys: []string{
+			"ssh-rsa dummy foo@example.com",
+		},
+		MountType: "reverse-sshfs",
+		CACerts: CACerts{
+			RemoveDefaults: &defaultRemoveDefaults,
+		},
+		Networks:         []Network{{MACAddress: "52:55:55:12:34:56", Interface: "eth0", Metric: 200}},
+		SlirpNICName:     "eth0",
+		SlirpIPv6Address: "fd00:6c69:6d61:0:5055:55ff:fe12:3456/64",
+		SlirpIPv6Gateway: "fd00:6c69:6d61::2",
+	}
+	layout, err := ExecuteTemplateCIDataISO(args)
+	assert.NilError(t, err)
+	for _, f := range layout {
+		if f.Path == "network-config" {
+			b, err := io.ReadAll(f.Reader)
+			assert.NilError(t, err)
+			t.Log(string(b))
+			assert.Assert(t, strings.Contains(string(b), "    addresses:\n    - fd00:6c69:6d61:0:5055:55ff:fe12:3456/64\n"))
+			assert.Assert(t, strings.Contains(string(b), "    routes:\n    - to: \"::/0\"\n      via: fd00:6c69:6d61::2\n"))
+		}
+	}
+}
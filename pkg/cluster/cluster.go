@@ -0,0 +1,277 @@
+// Package cluster manages groups of instances that are created from the same template,
+// and connected to a dedicated user-v2 network.
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apparentlymart/go-cidr/cidr"
+	"github.com/containerd/containerd/identifiers"
+	"github.com/goccy/go-yaml"
+	"github.com/lima-vm/lima/pkg/identifierutil"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/lima-vm/lima/pkg/networks/usernet"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
+)
+
+// firstNodeHost is the host number of the address of the first node in the subnet of the cluster network.
+// The lower addresses are reserved for the gateway and the DNS server.
+const firstNodeHost = 10
+
+// EnvFile is the file in the guest that contains the cluster parameters in the KEY=VALUE format.
+const EnvFile = "/etc/lima-cluster.env"
+
+// Cluster is a group of instances ("nodes") connected to a dedicated user-v2 network.
+type Cluster struct {
+	Name     string `yaml:"name" json:"name"`
+	Template string `yaml:"template" json:"template"`
+	Network  string `yaml:"network" json:"network"`
+	Gateway  string `yaml:"gateway" json:"gateway"` // e.g. "192.168.106.1/24"
+	Nodes    []Node `yaml:"nodes" json:"nodes"`
+}
+
+// Node is an instance of a cluster. The address of the node is fixed by a static DHCP lease.
+type Node struct {
+	Name       string `yaml:"name" json:"name"`
+	Index      int    `yaml:"index" json:"index"`
+	IP         string `yaml:"ip" json:"ip"`
+	MACAddress string `yaml:"macAddress" json:"macAddress"`
+}
+
+// NetworkName returns the name of the dedicated network of the cluster.
+func NetworkName(name string) string {
+	return "cluster-" + name
+}
+
+// NodeName returns the instance name of the node with the index, starting from 0.
+func NodeName(name string, index int) string {
+	return fmt.Sprintf("%s-%d", name, index)
+}
+
+// New plans a cluster of the nodes, with the gateway address and the prefix length of the cluster network.
+// New does not create the network or the instances.
+func New(name, template string, nodes int, gateway *net.IPNet) (*Cluster, error) {
+	if err := identifiers.Validate(name); err != nil {
+		return nil, fmt.Errorf("invalid cluster name %q: %w", name, err)
+	}
+	if nodes < 1 {
+		return nil, fmt.Errorf("the number of nodes must be at least 1, got %d", nodes)
+	}
+	if gateway.IP.To4() == nil {
+		return nil, fmt.Errorf("gateway %s must be an IPv4 address", gateway)
+	}
+	subnet := &net.IPNet{IP: gateway.IP.Mask(gateway.Mask), Mask: gateway.Mask}
+	if cidr.AddressCount(subnet) < uint64(firstNodeHost+nodes+1) {
+		return nil, fmt.Errorf("subnet %s is too small for %d nodes", subnet, nodes)
+	}
+	c := &Cluster{
+		Name:     name,
+		Template: template,
+		Network:  NetworkName(name),
+		Gateway:  gateway.String(),
+	}
+	for i := range nodes {
+		ip, err := cidr.Host(subnet, firstNodeHost+i)
+		if err != nil {
+			return nil, err
+		}
+		nodeName := NodeName(name, i)
+		if err := identifiers.Validate(nodeName); err != nil {
+			return nil, fmt.Errorf("invalid node name %q: %w", nodeName, err)
+		}
+		c.Nodes = append(c.Nodes, Node{
+			Name:       nodeName,
+			Index:      i,
+			IP:         ip.String(),
+			MACAddress: limayaml.MACAddress("cluster/" + nodeName),
+		})
+	}
+	return c, nil
+}
+
+// NetworkConfig returns the networks.yaml definition of the cluster network.
+func (c *Cluster) NetworkConfig() (networks.Network, error) {
+	ip, ipNet, err := net.ParseCIDR(c.Gateway)
+	if err != nil {
+		return networks.Network{}, err
+	}
+	return networks.Network{
+		Mode:    networks.ModeUserV2,
+		Gateway: ip,
+		NetMask: net.IP(ipNet.Mask),
+	}, nil
+}
+
+// Leases returns the static DHCP leases of the nodes, mapping the IP addresses to the MAC addresses.
+func (c *Cluster) Leases() map[string]string {
+	leases := make(map[string]string, len(c.Nodes))
+	for _, node := range c.Nodes {
+		leases[node.IP] = node.MACAddress
+	}
+	return leases
+}
+
+// Params returns the `param` values of the node:
+//
+//	CLUSTER_NAME          the name of the cluster
+//	CLUSTER_NODE_INDEX    the index of the node, starting from 0
+//	CLUSTER_NODE_COUNT    the number of nodes
+//	CLUSTER_NODE_IP       the IPv4 address of the node on the cluster network
+//	CLUSTER_PEERS         the comma-separated IPv4 addresses of all the nodes, in the order of the indexes
+//	CLUSTER_PEER_NAMES    the comma-separated hostnames of all the nodes, in the order of the indexes
+func (c *Cluster) Params(node Node) map[string]string {
+	peers := make([]string, len(c.Nodes))
+	peerNames := make([]string, len(c.Nodes))
+	for i, n := range c.Nodes {
+		peers[i] = n.IP
+		peerNames[i] = identifierutil.HostnameFromInstName(n.Name) + "." + usernet.InstanceHostsDomain
+	}
+	return map[string]string{
+		"CLUSTER_NAME":       c.Name,
+		"CLUSTER_NODE_INDEX": strconv.Itoa(node.Index),
+		"CLUSTER_NODE_COUNT": strconv.Itoa(len(c.Nodes)),
+		"CLUSTER_NODE_IP":    node.IP,
+		"CLUSTER_PEERS":      strings.Join(peers, ","),
+		"CLUSTER_PEER_NAMES": strings.Join(peerNames, ","),
+	}
+}
+
+// YQExpression returns the yq expression that turns the template into the lima.yaml of the node.
+// The node is attached to the cluster network, and receives the Params as `param` values,
+// which are also written to EnvFile by a system provisioning script that runs before the scripts of the template.
+func (c *Cluster) YQExpression(node Node) (string, error) {
+	nw, err := json.Marshal(limayaml.Network{Lima: c.Network, MACAddress: node.MACAddress})
+	if err != nil {
+		return "", err
+	}
+	params := c.Params(node)
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	exprs := []string{fmt.Sprintf(".networks = [%s] + (.networks // [])", nw)}
+	script := "#!/bin/sh\nset -eu\ncat >" + EnvFile + " <<EOF\n"
+	for _, key := range keys {
+		value, err := json.Marshal(params[key])
+		if err != nil {
+			return "", err
+		}
+		exprs = append(exprs, fmt.Sprintf(".param.%s = %s", key, value))
+		script += fmt.Sprintf("%s=${PARAM_%s}\n", key, key)
+	}
+	script += "EOF\n"
+	provision, err := json.Marshal(limayaml.Provision{Mode: limayaml.ProvisionModeSystem, Script: script})
+	if err != nil {
+		return "", err
+	}
+	exprs = append(exprs, fmt.Sprintf(".provision = [%s] + (.provision // [])", provision))
+	return strings.Join(exprs, " | "), nil
+}
+
+// FreeGateway returns the first 192.168.x.1/24 gateway, starting from 192.168.106.1/24,
+// whose subnet does not overlap with the networks in the config.
+func FreeGateway(cfg *networks.Config) (*net.IPNet, error) {
+	for x := 106; x < 255; x++ {
+		subnet := &net.IPNet{IP: net.IPv4(192, 168, byte(x), 0).To4(), Mask: net.CIDRMask(24, 32)}
+		overlaps := false
+		for _, nw := range cfg.Networks {
+			if other := nw.Subnet(); other != nil && (other.Contains(subnet.IP) || subnet.Contains(other.IP)) {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			return &net.IPNet{IP: net.IPv4(192, 168, byte(x), 1).To4(), Mask: subnet.Mask}, nil
+		}
+	}
+	return nil, errors.New("no free 192.168.x.0/24 subnet is left for the cluster network")
+}
+
+func file(name string) (string, error) {
+	dir, err := dirnames.LimaClustersDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+".yaml"), nil
+}
+
+// Save writes the cluster definition to $LIMA_HOME/_clusters/<NAME>.yaml.
+func (c *Cluster) Save() error {
+	path, err := file(c.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// Load reads the cluster definition. The error wraps os.ErrNotExist when the cluster does not exist.
+func Load(name string) (*Cluster, error) {
+	if err := identifiers.Validate(name); err != nil {
+		return nil, fmt.Errorf("invalid cluster name %q: %w", name, err)
+	}
+	path, err := file(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("cluster %q does not exist: %w", name, err)
+		}
+		return nil, err
+	}
+	var c Cluster
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Remove removes the cluster definition. The network and the instances of the cluster are not removed.
+func Remove(name string) error {
+	path, err := file(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Clusters returns the names of the clusters, sorted.
+func Clusters() ([]string, error) {
+	dir, err := dirnames.LimaClustersDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".yaml"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,118 @@
+package cluster
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/lima-vm/lima/pkg/yqutil"
+	"gotest.tools/v3/assert"
+)
+
+func testGateway(t *testing.T, s string) *net.IPNet {
+	ip, ipNet, err := net.ParseCIDR(s)
+	assert.NilError(t, err)
+	return &net.IPNet{IP: ip, Mask: ipNet.Mask}
+}
+
+func TestNew(t *testing.T) {
+	c, err := New("foo", "template://default", 3, testGateway(t, "192.168.106.1/24"))
+	assert.NilError(t, err)
+	assert.Equal(t, c.Network, "cluster-foo")
+	assert.Equal(t, c.Gateway, "192.168.106.1/24")
+	assert.Equal(t, len(c.Nodes), 3)
+	for i, node := range c.Nodes {
+		assert.Equal(t, node.Index, i)
+		assert.Equal(t, node.Name, NodeName("foo", i))
+	}
+	assert.Equal(t, c.Nodes[0].IP, "192.168.106.10")
+	assert.Equal(t, c.Nodes[2].IP, "192.168.106.12")
+	assert.Assert(t, c.Nodes[0].MACAddress != c.Nodes[1].MACAddress)
+	assert.Equal(t, c.Leases()["192.168.106.11"], c.Nodes[1].MACAddress)
+
+	nw, err := c.NetworkConfig()
+	assert.NilError(t, err)
+	assert.Equal(t, nw.Mode, networks.ModeUserV2)
+	assert.Equal(t, nw.Subnet().String(), "192.168.106.0/24")
+
+	assert.DeepEqual(t, c.Params(c.Nodes[1]), map[string]string{
+		"CLUSTER_NAME":       "foo",
+		"CLUSTER_NODE_INDEX": "1",
+		"CLUSTER_NODE_COUNT": "3",
+		"CLUSTER_NODE_IP":    "192.168.106.11",
+		"CLUSTER_PEERS":      "192.168.106.10,192.168.106.11,192.168.106.12",
+		"CLUSTER_PEER_NAMES": "lima-foo-0.internal,lima-foo-1.internal,lima-foo-2.internal",
+	})
+
+	_, err = New("foo", "template://default", 0, testGateway(t, "192.168.106.1/24"))
+	assert.ErrorContains(t, err, "at least 1")
+	_, err = New("foo", "template://default", 6, testGateway(t, "192.168.106.1/28"))
+	assert.ErrorContains(t, err, "too small")
+	_, err = New("foo/bar", "template://default", 1, testGateway(t, "192.168.106.1/24"))
+	assert.ErrorContains(t, err, "invalid cluster name")
+}
+
+func TestYQExpression(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	c, err := New("foo", "template://default", 2, testGateway(t, "192.168.106.1/24"))
+	assert.NilError(t, err)
+	expr, err := c.YQExpression(c.Nodes[1])
+	assert.NilError(t, err)
+	template := `
+images:
+- location: /image.qcow2
+networks:
+- lima: shared
+provision:
+- mode: system
+  script: echo "{{.Param.CLUSTER_NODE_INDEX}}"
+`
+	b, err := yqutil.EvaluateExpression(expr, []byte(template))
+	assert.NilError(t, err)
+	// Load fails when a param is not used by any script
+	y, err := limayaml.Load(b, filepath.Join(t.TempDir(), "lima.yaml"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(y.Networks), 2)
+	assert.Equal(t, y.Networks[0].Lima, "cluster-foo")
+	assert.Equal(t, y.Networks[0].MACAddress, c.Nodes[1].MACAddress)
+	assert.Equal(t, y.Networks[1].Lima, "shared")
+	assert.Equal(t, y.Param["CLUSTER_NODE_IP"], "192.168.106.11")
+	assert.Equal(t, len(y.Provision), 2)
+	assert.Assert(t, y.Provision[0].Script != "")
+	assert.Equal(t, y.Provision[1].Script, `echo "1"`)
+}
+
+func TestFreeGateway(t *testing.T) {
+	cfg := networks.Config{Networks: map[string]networks.Network{
+		"user-v2": {Mode: networks.ModeUserV2, Gateway: net.ParseIP("192.168.104.1"), NetMask: net.ParseIP("255.255.255.0")},
+		"other":   {Mode: networks.ModeUserV2, Gateway: net.ParseIP("192.168.106.1"), NetMask: net.ParseIP("255.255.254.0")},
+		"bridged": {Mode: networks.ModeBridged, Interface: "en0"},
+	}}
+	gateway, err := FreeGateway(&cfg)
+	assert.NilError(t, err)
+	assert.Equal(t, gateway.String(), "192.168.108.1/24")
+}
+
+func TestSaveLoad(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	names, err := Clusters()
+	assert.NilError(t, err)
+	assert.Equal(t, len(names), 0)
+
+	c, err := New("foo", "template://default", 2, testGateway(t, "192.168.106.1/24"))
+	assert.NilError(t, err)
+	assert.NilError(t, c.Save())
+	loaded, err := Load("foo")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, loaded, c)
+	names, err = Clusters()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, names, []string{"foo"})
+
+	assert.NilError(t, Remove("foo"))
+	_, err = Load("foo")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
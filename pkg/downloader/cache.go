@@ -0,0 +1,282 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/lima-vm/lima/pkg/lockutil"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheEntry is the metadata of a cache entry.
+type CacheEntry struct {
+	Key      string    `json:"key"`  // SHA256 of the URL
+	Path     string    `json:"path"` // path to the cache entry directory
+	URL      string    `json:"url,omitempty"`
+	Size     int64     `json:"size"` // total size of the files in the cache entry
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+// WithMaxCacheSize limits the total size of the cache dir.
+// When the cache exceeds the size after a download, the least recently used entries are evicted.
+// The entry of the download itself and the entries referred by instances or templates are never evicted.
+// Zero value disables the limit.
+func WithMaxCacheSize(size int64) Opt {
+	return func(o *options) error {
+		if size < 0 {
+			return fmt.Errorf("invalid max cache size %d", size)
+		}
+		o.maxCacheSize = size
+		return nil
+	}
+}
+
+// MaxCacheSize returns the value of $LIMA_CACHE_MAX_SIZE (e.g., "20GiB"), or 0 when it is not set.
+func MaxCacheSize() (int64, error) {
+	envVar := os.Getenv("LIMA_CACHE_MAX_SIZE")
+	if envVar == "" {
+		return 0, nil
+	}
+	size, err := units.RAMInBytes(envVar)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LIMA_CACHE_MAX_SIZE value %q: %w", envVar, err)
+	}
+	return size, nil
+}
+
+// InspectCacheEntry returns the metadata of the cache entry returned by CacheEntries.
+func InspectCacheEntry(key, path string) (*CacheEntry, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", path)
+	}
+	entry := &CacheEntry{
+		Key:      key,
+		Path:     path,
+		URL:      readFile(filepath.Join(path, "url")),
+		LastUsed: st.ModTime(),
+	}
+	// Entries created by old versions of Lima do not have the "last-used" file
+	for _, f := range []string{"last-used", "data"} {
+		if st, err := os.Stat(filepath.Join(path, f)); err == nil {
+			entry.LastUsed = st.ModTime()
+			break
+		}
+	}
+	err = filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			entry.Size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ListCacheEntries returns the metadata of the cache entries, sorted from the least recently used.
+func ListCacheEntries(opts ...Opt) ([]CacheEntry, error) {
+	cacheEntries, err := CacheEntries(opts...)
+	if err != nil {
+		return nil, err
+	}
+	var entries []CacheEntry
+	for key, path := range cacheEntries {
+		entry, err := InspectCacheEntry(key, path)
+		if err != nil {
+			// e.g., the lock file of the entry on Windows, or an entry being removed
+			logrus.WithError(err).Debugf("ignoring the cache entry %q", key)
+			continue
+		}
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].LastUsed.Equal(entries[j].LastUsed) {
+			return entries[i].LastUsed.Before(entries[j].LastUsed)
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// RemoveCacheEntry removes the cache entry, waiting for the download in progress, if any.
+func RemoveCacheEntry(entry CacheEntry) error {
+	err := lockutil.WithDirLock(entry.Path, func() error {
+		return os.RemoveAll(entry.Path)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// EvictCache removes the least recently used entries until the total size of the cache
+// is within maxSize, and returns the removed entries.
+// The entries for which keep returns true are not removed.
+func EvictCache(maxSize int64, keep func(CacheEntry) bool, opts ...Opt) ([]CacheEntry, error) {
+	entries, err := ListCacheEntries(opts...)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+	var evicted []CacheEntry
+	for _, entry := range entries {
+		if total <= maxSize {
+			break
+		}
+		if keep != nil && keep(entry) {
+			continue
+		}
+		logrus.Debugf("Evicting the cache entry %q (%s, last used at %s)", entry.URL, units.BytesSize(float64(entry.Size)), entry.LastUsed)
+		if err := RemoveCacheEntry(entry); err != nil {
+			return evicted, fmt.Errorf("failed to evict the cache entry %q: %w", entry.Key, err)
+		}
+		total -= entry.Size
+		evicted = append(evicted, entry)
+	}
+	if total > maxSize {
+		logrus.Warnf("The cache size (%s) exceeds the limit (%s), even after evicting the unused entries",
+			units.BytesSize(float64(total)), units.BytesSize(float64(maxSize)))
+	}
+	return evicted, nil
+}
+
+// ImportCache stores the data read from r into the cache entry of the remote resource, as if it was downloaded.
+// The data is verified with the expected digest, which must be specified.
+// The lastModified time and the contentType are recorded unless they are empty, like the headers of the HTTP response.
+// The existing cache entry is replaced.
+func ImportCache(remote string, r io.Reader, expectedDigest digest.Digest, lastModified time.Time, contentType string, opts ...Opt) error {
+	var o options
+	if err := o.apply(opts); err != nil {
+		return err
+	}
+	if o.cacheDir == "" {
+		return errors.New("the cache directory must be specified")
+	}
+	if IsLocal(remote) {
+		return fmt.Errorf("local file %q cannot be cached", remote)
+	}
+	if err := expectedDigest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %w", expectedDigest, err)
+	}
+	shad := cacheDirectoryPath(o.cacheDir, remote)
+	if err := os.MkdirAll(shad, 0o700); err != nil {
+		return err
+	}
+	shadDigest, err := cacheDigestPath(shad, expectedDigest)
+	if err != nil {
+		return err
+	}
+	return lockutil.WithDirLock(shad, func() error {
+		shadData := filepath.Join(shad, "data")
+		shadDataTmp := perProcessTempfile(shadData)
+		f, err := os.Create(shadDataTmp)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(shadDataTmp)
+		defer f.Close()
+		digester := expectedDigest.Algorithm().Digester()
+		if _, err := io.Copy(io.MultiWriter(f, digester.Hash()), r); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if actualDigest := digester.Digest(); actualDigest != expectedDigest {
+			return fmt.Errorf("expected digest %q, got %q", expectedDigest, actualDigest)
+		}
+		// Remove the metadata of the previous data, including the digests with other algorithms
+		entries, err := os.ReadDir(shad)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if p := filepath.Join(shad, entry.Name()); p != shadDataTmp {
+				if err := os.RemoveAll(p); err != nil {
+					return err
+				}
+			}
+		}
+		if err := os.Rename(shadDataTmp, shadData); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(shad, "url"), []byte(remote), 0o644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(shadDigest, []byte(expectedDigest.String()), 0o644); err != nil {
+			return err
+		}
+		if !lastModified.IsZero() {
+			lm := lastModified.UTC().Format(http.TimeFormat)
+			if err := os.WriteFile(filepath.Join(shad, "time"), []byte(lm), 0o644); err != nil {
+				return err
+			}
+		}
+		if contentType != "" {
+			if err := os.WriteFile(filepath.Join(shad, "type"), []byte(contentType), 0o644); err != nil {
+				return err
+			}
+		}
+		return touchCacheEntry(shad)
+	})
+}
+
+// touchCacheEntry records the time of the last use of the cache entry, for the LRU eviction.
+func touchCacheEntry(shad string) error {
+	lastUsed := filepath.Join(shad, "last-used")
+	now := time.Now()
+	if err := os.Chtimes(lastUsed, now, now); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.WriteFile(lastUsed, nil, 0o644)
+	}
+	return nil
+}
+
+// evictCacheAfterDownload applies the max cache size after downloading the remote resource.
+func evictCacheAfterDownload(remote string, o options) {
+	if o.cacheDir == "" || o.maxCacheSize == 0 {
+		return
+	}
+	referrers, err := CacheReferrers()
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to list the referrers of the cache entries, not evicting the cache")
+		return
+	}
+	key := CacheKey(remote)
+	evicted, err := EvictCache(o.maxCacheSize, func(entry CacheEntry) bool {
+		_, referred := referrers[entry.Key]
+		return entry.Key == key || referred
+	}, WithCacheDir(o.cacheDir))
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to evict the cache")
+	}
+	for _, entry := range evicted {
+		logrus.Infof("Evicted %q from the cache (%s)", entry.URL, units.BytesSize(float64(entry.Size)))
+	}
+}
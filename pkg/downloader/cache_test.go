@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/templatestore"
+	"gotest.tools/v3/assert"
+)
+
+// testLimaHome sets $LIMA_HOME to an empty directory without instances, and hides the templates,
+// so that the cache entries are not referred by the instances or the templates of the host.
+func testLimaHome(t *testing.T) string {
+	limaHome := t.TempDir()
+	t.Setenv("LIMA_HOME", limaHome)
+	origTemplateStoreTemplates := templateStoreTemplates
+	templateStoreTemplates = func() ([]templatestore.Template, error) { return nil, nil }
+	t.Cleanup(func() { templateStoreTemplates = origTemplateStoreTemplates })
+	return limaHome
+}
+
+func TestEvictCache(t *testing.T) {
+	testLimaHome(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte(name), 1000), 0o644))
+	}
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(ts.Close)
+	cacheDir := t.TempDir()
+
+	// The resolution of the modification time may be coarse
+	lastUsed := time.Now().Add(-time.Hour)
+	download := func(name string, opts ...Opt) *Result {
+		r, err := Download(context.Background(), "", ts.URL+"/"+name, append([]Opt{WithCacheDir(cacheDir)}, opts...)...)
+		assert.NilError(t, err)
+		lastUsed = lastUsed.Add(time.Minute)
+		assert.NilError(t, os.Chtimes(filepath.Join(filepath.Dir(r.CachePath), "last-used"), lastUsed, lastUsed))
+		return r
+	}
+	download("a")
+	download("b")
+	download("c")
+	// "a" is used again
+	r := download("a")
+	assert.Equal(t, r.Status, StatusUsedCache)
+
+	entries, err := ListCacheEntries(WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 3)
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+		assert.Assert(t, entry.Size >= 1000, entry.URL)
+		assert.Equal(t, entry.Key, CacheKey(entry.URL))
+	}
+	// Sorted from the least recently used
+	assert.DeepEqual(t, urls, []string{ts.URL + "/b", ts.URL + "/c", ts.URL + "/a"})
+	entrySize := entries[0].Size
+
+	// Evicts "b" and "c" to store "d"
+	download("d", WithMaxCacheSize(2*entrySize))
+	entries, err = ListCacheEntries(WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	urls = nil
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+	}
+	assert.DeepEqual(t, urls, []string{ts.URL + "/a", ts.URL + "/d"})
+
+	// The kept entries are not evicted
+	evicted, err := EvictCache(0, func(entry CacheEntry) bool {
+		return entry.URL == ts.URL+"/a"
+	}, WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, len(evicted), 1)
+	assert.Equal(t, evicted[0].URL, ts.URL+"/d")
+	entries, err = ListCacheEntries(WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+	assert.Equal(t, entries[0].URL, ts.URL+"/a")
+}
+
+func TestEvictCacheAfterDownload(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte(name), 1000), 0o644))
+	}
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(ts.Close)
+	cacheDir := t.TempDir()
+
+	// "a" is referred by an instance
+	limaHome := testLimaHome(t)
+	assert.NilError(t, os.Mkdir(filepath.Join(limaHome, "foo"), 0o755))
+	limaYAML := "images:\n- location: " + ts.URL + "/a\n"
+	assert.NilError(t, os.WriteFile(filepath.Join(limaHome, "foo", "lima.yaml"), []byte(limaYAML), 0o644))
+
+	lastUsed := time.Now().Add(-time.Hour)
+	var entrySize int64
+	for _, name := range []string{"a", "b", "c"} {
+		r, err := Download(context.Background(), "", ts.URL+"/"+name, WithCacheDir(cacheDir), WithMaxCacheSize(2*entrySize))
+		assert.NilError(t, err)
+		lastUsed = lastUsed.Add(time.Minute)
+		assert.NilError(t, os.Chtimes(filepath.Join(filepath.Dir(r.CachePath), "last-used"), lastUsed, lastUsed))
+		if entrySize == 0 {
+			entry, err := InspectCacheEntry(CacheKey(ts.URL+"/"+name), filepath.Dir(r.CachePath))
+			assert.NilError(t, err)
+			entrySize = entry.Size
+		}
+	}
+
+	// "b" is evicted to store "c", as "a" is referred
+	entries, err := ListCacheEntries(WithCacheDir(cacheDir))
+	assert.NilError(t, err)
+	var urls []string
+	for _, entry := range entries {
+		urls = append(urls, entry.URL)
+	}
+	assert.DeepEqual(t, urls, []string{ts.URL + "/a", ts.URL + "/c"})
+}
+
+func TestMaxCacheSize(t *testing.T) {
+	t.Setenv("LIMA_CACHE_MAX_SIZE", "")
+	size, err := MaxCacheSize()
+	assert.NilError(t, err)
+	assert.Equal(t, size, int64(0))
+
+	t.Setenv("LIMA_CACHE_MAX_SIZE", "20GiB")
+	size, err = MaxCacheSize()
+	assert.NilError(t, err)
+	assert.Equal(t, size, int64(20<<30))
+
+	t.Setenv("LIMA_CACHE_MAX_SIZE", "large")
+	_, err = MaxCacheSize()
+	assert.ErrorContains(t, err, "invalid LIMA_CACHE_MAX_SIZE value")
+}
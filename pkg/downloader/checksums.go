@@ -0,0 +1,379 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/lima-vm/lima/pkg/httpclientutil"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/lockutil"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// checksumsSuffix is the suffix of the pseudo digest algorithms referring to checksum files, e.g., "sha256sums:URL".
+const checksumsSuffix = "sums"
+
+// maxChecksumsSize is the maximum size of a checksum file and its signature.
+const maxChecksumsSize = 16 << 20
+
+// ChecksumSignature is used to verify the detached signature of the checksum file of `WithExpectedDigest("sha256sums:URL")`.
+type ChecksumSignature struct {
+	Type            limayaml.SignatureType // limayaml.SignatureTypeGPG or limayaml.SignatureTypeCosign
+	PublicKeys      []string               // armored OpenPGP public keys, or PEM public keys for cosign
+	SignatureSuffix string                 // appended to the URL of the checksum file, e.g., ".gpg"
+}
+
+// WithChecksumSignature verifies the detached signature of the checksum file specified with WithExpectedDigest.
+// The signature is not verified when sig is nil or has no public keys.
+func WithChecksumSignature(sig *ChecksumSignature) Opt {
+	return func(o *options) error {
+		if sig != nil && len(sig.PublicKeys) > 0 {
+			switch sig.Type {
+			case limayaml.SignatureTypeGPG, limayaml.SignatureTypeCosign:
+			default:
+				return fmt.Errorf("unknown signature type %q", sig.Type)
+			}
+			if sig.SignatureSuffix == "" {
+				return errors.New("the signature suffix must be specified")
+			}
+		}
+		o.checksumSignature = sig
+		return nil
+	}
+}
+
+// parseChecksumsDigest parses "<ALGO>sums:URL", e.g., "sha256sums:https://cloud-images.ubuntu.com/releases/24.10/release/SHA256SUMS",
+// and returns the algorithm and the location of the checksum file.
+// The location can be a local path too.
+func parseChecksumsDigest(d digest.Digest) (algo digest.Algorithm, location string, ok bool) {
+	s := string(d)
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	name, ok := strings.CutSuffix(s[:i], checksumsSuffix)
+	if !ok || name == "" || s[i+1:] == "" {
+		return "", "", false
+	}
+	return digest.Algorithm(name), s[i+1:], true
+}
+
+// checksumsFile is a checksum file and its detached signature.
+type checksumsFile struct {
+	location  string
+	data      []byte
+	signature []byte // nil when the signature is not verified
+}
+
+// resolveChecksums returns the digest of the remote file in the checksum file.
+//
+// When the cache entry of the remote file has the data and the checksum file of the same location, the digest is resolved
+// with the stored checksum file, without accessing the network. Otherwise the checksum file is fetched, and returned
+// for storing in the cache entry.
+func resolveChecksums(ctx context.Context, remote string, o options) (digest.Digest, *checksumsFile, error) {
+	if o.cacheDir != "" && !IsLocal(remote) {
+		shad := cacheDirectoryPath(o.cacheDir, remote)
+		if f := readCachedChecksums(shad, o); f != nil {
+			d, err := f.digest(ctx, remote, o)
+			if err == nil {
+				logrus.Debugf("resolved the digest of %q to %q with the checksum file %q stored in the cache", remote, d, o.checksums)
+				return d, nil, nil
+			}
+			logrus.WithError(err).Debugf("ignoring the checksum file %q stored in the cache", o.checksums)
+		}
+	}
+	f, err := fetchChecksums(ctx, o)
+	if err != nil {
+		return "", nil, err
+	}
+	d, err := f.digest(ctx, remote, o)
+	if err != nil {
+		return "", nil, err
+	}
+	logrus.Debugf("resolved the digest of %q to %q with the checksum file %q", remote, d, o.checksums)
+	return d, f, nil
+}
+
+// fetchChecksums fetches the checksum file, and its signature when WithChecksumSignature is specified.
+func fetchChecksums(ctx context.Context, o options) (*checksumsFile, error) {
+	sums, err := readChecksumsFile(ctx, o.checksums)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the checksum file %q: %w", o.checksums, err)
+	}
+	f := &checksumsFile{location: o.checksums, data: sums}
+	if sig := o.checksumSignature; sig != nil && len(sig.PublicKeys) > 0 {
+		sigLocation := o.checksums + sig.SignatureSuffix
+		f.signature, err = readChecksumsFile(ctx, sigLocation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the signature %q: %w", sigLocation, err)
+		}
+	}
+	return f, nil
+}
+
+// digest verifies the signature of the checksum file, and returns the digest of the remote file in it.
+func (f *checksumsFile) digest(ctx context.Context, remote string, o options) (digest.Digest, error) {
+	if sig := o.checksumSignature; sig != nil && len(sig.PublicKeys) > 0 {
+		sigLocation := f.location + sig.SignatureSuffix
+		if f.signature == nil {
+			return "", fmt.Errorf("no signature %q of the checksum file %q", sigLocation, f.location)
+		}
+		var err error
+		switch sig.Type {
+		case limayaml.SignatureTypeGPG:
+			err = verifyGPG(ctx, f.data, f.signature, sig.PublicKeys)
+		case limayaml.SignatureTypeCosign:
+			err = verifyCosign(f.data, f.signature, sig.PublicKeys)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to verify the signature %q of the checksum file %q: %w", sigLocation, f.location, err)
+		}
+		logrus.Debugf("verified the %s signature %q of the checksum file %q", sig.Type, sigLocation, f.location)
+	}
+	name := path.Base(remote)
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	d, err := findChecksum(f.data, o.checksumsAlgo, name)
+	if err != nil {
+		return "", fmt.Errorf("checksum file %q: %w", f.location, err)
+	}
+	return d, nil
+}
+
+// readCachedChecksums returns the checksum file stored in the cache entry, or nil when the cache entry has no data,
+// or no checksum file of the location of o.checksums (with the signature, when WithChecksumSignature is specified).
+func readCachedChecksums(shad string, o options) *checksumsFile {
+	var f *checksumsFile
+	_ = lockutil.WithDirLock(shad, func() error {
+		if _, err := os.Stat(filepath.Join(shad, "data")); err != nil {
+			return err
+		}
+		if readFile(filepath.Join(shad, "checksums.url")) != o.checksums {
+			return nil
+		}
+		data, err := os.ReadFile(filepath.Join(shad, "checksums"))
+		if err != nil {
+			return err
+		}
+		f = &checksumsFile{location: o.checksums, data: data}
+		if signature, err := os.ReadFile(filepath.Join(shad, "checksums.sig")); err == nil {
+			f.signature = signature
+		}
+		return nil
+	})
+	return f
+}
+
+// store stores the checksum file in the cache entry.
+// The caller must hold the lock of shad.
+func (f *checksumsFile) store(shad string) error {
+	if err := os.WriteFile(filepath.Join(shad, "checksums"), f.data, 0o644); err != nil {
+		return err
+	}
+	sigPath := filepath.Join(shad, "checksums.sig")
+	if f.signature == nil {
+		if err := os.RemoveAll(sigPath); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(sigPath, f.signature, 0o644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(shad, "checksums.url"), []byte(f.location), 0o644)
+}
+
+func readChecksumsFile(ctx context.Context, location string) ([]byte, error) {
+	var r io.Reader
+	if IsLocal(location) {
+		localPath, err := canonicalLocalPath(location)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(localPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	} else {
+		client, err := httpclientutil.DefaultClient()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := httpclientutil.Get(ctx, client, location)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		r = resp.Body
+	}
+	b, err := io.ReadAll(io.LimitReader(r, maxChecksumsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxChecksumsSize {
+		return nil, fmt.Errorf("exceeds %d bytes", maxChecksumsSize)
+	}
+	return b, nil
+}
+
+// findChecksum finds the checksum of the file name in the output of `sha256sum` ("HEX  NAME" or "HEX *NAME"),
+// or of the BSD-style `sha256 -r` ("SHA256 (NAME) = HEX").
+func findChecksum(sums []byte, algo digest.Algorithm, name string) (digest.Digest, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var hex, file string
+		if rest, ok := strings.CutPrefix(line, strings.ToUpper(algo.String())+" ("); ok {
+			var found bool
+			file, hex, found = strings.Cut(rest, ") = ")
+			if !found {
+				continue
+			}
+		} else {
+			var found bool
+			hex, file, found = strings.Cut(line, " ")
+			if !found {
+				continue
+			}
+			file = strings.TrimPrefix(strings.TrimPrefix(file, " "), "*")
+		}
+		if strings.TrimPrefix(file, "./") != name {
+			continue
+		}
+		d := digest.NewDigestFromEncoded(algo, strings.ToLower(hex))
+		if err := d.Validate(); err != nil {
+			return "", fmt.Errorf("invalid checksum of %q: %w", name, err)
+		}
+		return d, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no %s checksum found for %q", algo, name)
+}
+
+// verifyGPG verifies the detached signature with `gpgv`, using a temporary keyring of the public keys.
+func verifyGPG(ctx context.Context, data, signature []byte, publicKeys []string) error {
+	dir, err := os.MkdirTemp("", "lima-gpgv-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	var keyring []byte
+	for i, key := range publicKeys {
+		b, err := dearmor(key)
+		if err != nil {
+			return fmt.Errorf("public key %d: %w", i, err)
+		}
+		keyring = append(keyring, b...)
+	}
+	keyringPath := filepath.Join(dir, "keyring.gpg")
+	dataPath := filepath.Join(dir, "data")
+	signaturePath := filepath.Join(dir, "data.sig")
+	for p, b := range map[string][]byte{keyringPath: keyring, dataPath: data, signaturePath: signature} {
+		if err := os.WriteFile(p, b, 0o600); err != nil {
+			return err
+		}
+	}
+	cmd := exec.CommandContext(ctx, "gpgv", "--homedir", dir, "--keyring", keyringPath, signaturePath, dataPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run %v: %w (output=%q)", cmd.Args, err, string(out))
+	}
+	return nil
+}
+
+// dearmor decodes an ASCII-armored OpenPGP block (RFC 4880 section 6.2) into the binary packets.
+func dearmor(armored string) ([]byte, error) {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(armored), "\r\n", "\n"), "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "-----BEGIN PGP ") {
+		return nil, errors.New("not an armored OpenPGP block")
+	}
+	// Skip the armor headers, which end with an empty line
+	body := lines[1:]
+	for i, line := range body {
+		if strings.TrimSpace(line) == "" {
+			body = body[i+1:]
+			break
+		}
+	}
+	var b64 strings.Builder
+	for _, line := range body {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-----END PGP ") {
+			return base64.StdEncoding.DecodeString(b64.String())
+		}
+		// The checksum line
+		if strings.HasPrefix(line, "=") {
+			continue
+		}
+		b64.WriteString(line)
+	}
+	return nil, errors.New("unterminated armored OpenPGP block")
+}
+
+// verifyCosign verifies the base64-encoded signature made by `cosign sign-blob --key`.
+// The signature is not looked up in the Rekor transparency log.
+func verifyCosign(data, signature []byte, publicKeys []string) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("failed to decode the signature: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	for i, key := range publicKeys {
+		pub, err := parsePEMPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("public key %d: %w", i, err)
+		}
+		var ok bool
+		switch pub := pub.(type) {
+		case *ecdsa.PublicKey:
+			ok = ecdsa.VerifyASN1(pub, hash[:], sig)
+		case *rsa.PublicKey:
+			ok = rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) == nil
+		case ed25519.PublicKey:
+			ok = ed25519.Verify(pub, data, sig)
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("the signature was not made by any of the public keys")
+}
+
+// parsePEMPublicKey parses a PEM-encoded ECDSA, RSA, or Ed25519 public key.
+func parsePEMPublicKey(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("not a PEM \"PUBLIC KEY\" block")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
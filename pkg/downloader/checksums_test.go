@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/v3/assert"
+)
+
+const dummyRemoteFileSHA256 = "380481d26f897403368be7cb86ca03a4bc14b125bfaf2b93bff809a5a2ad717e"
+
+func TestFindChecksum(t *testing.T) {
+	sums := []byte(`
+0000000000000000000000000000000000000000000000000000000000000000 *other.img
+380481D26F897403368BE7CB86CA03A4BC14B125BFAF2B93BFF809A5A2AD717E *downloader.txt
+SHA256 (bsd.img) = 380481d26f897403368be7cb86ca03a4bc14b125bfaf2b93bff809a5a2ad717e
+380481d26f897403368be7cb86ca03a4bc14b125bfaf2b93bff809a5a2ad717e  ./dot.img
+`)
+	for _, name := range []string{"downloader.txt", "bsd.img", "dot.img"} {
+		d, err := findChecksum(sums, digest.SHA256, name)
+		assert.NilError(t, err)
+		assert.Equal(t, d, digest.NewDigestFromEncoded(digest.SHA256, dummyRemoteFileSHA256))
+	}
+	_, err := findChecksum(sums, digest.SHA256, "missing.img")
+	assert.Error(t, err, `no sha256 checksum found for "missing.img"`)
+	_, err = findChecksum([]byte("deadbeef  short.img\n"), digest.SHA256, "short.img")
+	assert.ErrorContains(t, err, `invalid checksum of "short.img"`)
+}
+
+func TestParseChecksumsDigest(t *testing.T) {
+	algo, location, ok := parseChecksumsDigest("sha512sums:https://example.com/SHA512SUMS")
+	assert.Assert(t, ok)
+	assert.Equal(t, algo, digest.SHA512)
+	assert.Equal(t, location, "https://example.com/SHA512SUMS")
+	for _, d := range []digest.Digest{"sha256:" + dummyRemoteFileSHA256, "sums:https://example.com/SUMS", "sha256sums:", ""} {
+		_, _, ok := parseChecksumsDigest(d)
+		assert.Assert(t, !ok, d)
+	}
+}
+
+func TestDownloadChecksums(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NilError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.NilError(t, err)
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+
+	dir := t.TempDir()
+	b, err := os.ReadFile(filepath.Join("testdata", "downloader.txt"))
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "downloader.txt"), b, 0o644))
+	sums := []byte(dummyRemoteFileSHA256 + "  downloader.txt\n")
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "SHA256SUMS"), sums, 0o644))
+	hash := sha256.Sum256(sums)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "SHA256SUMS.sig"), []byte(base64.StdEncoding.EncodeToString(sig)), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "TAMPERED.sig"), []byte(base64.StdEncoding.EncodeToString(sig)), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "TAMPERED"), []byte("0000000000000000000000000000000000000000000000000000000000000000  downloader.txt\n"), 0o644))
+
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(ts.Close)
+	cosign := &ChecksumSignature{Type: limayaml.SignatureTypeCosign, PublicKeys: []string{publicKey}, SignatureSuffix: ".sig"}
+
+	t.Run("verified", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		r, err := Download(context.Background(), filepath.Join(t.TempDir(), "data"), ts.URL+"/downloader.txt",
+			WithCacheDir(cacheDir),
+			WithExpectedDigest(digest.Digest("sha256sums:"+ts.URL+"/SHA256SUMS")),
+			WithChecksumSignature(cosign))
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusDownloaded)
+		assert.Assert(t, r.ValidatedDigest)
+		cachedDigest, err := os.ReadFile(filepath.Join(filepath.Dir(r.CachePath), "sha256.digest"))
+		assert.NilError(t, err)
+		assert.Equal(t, string(cachedDigest), "sha256:"+dummyRemoteFileSHA256)
+
+		_, err = Cached(ts.URL+"/downloader.txt", WithCacheDir(cacheDir), WithExpectedDigest(digest.Digest("sha256sums:"+ts.URL+"/SHA256SUMS")))
+		assert.NilError(t, err)
+		_, err = Cached(ts.URL+"/downloader.txt", WithCacheDir(cacheDir), WithExpectedDigest(digest.Digest("sha256sums:"+ts.URL+"/TAMPERED")))
+		assert.ErrorContains(t, err, "must be fetched")
+	})
+
+	t.Run("offline", func(t *testing.T) {
+		offline := httptest.NewServer(http.FileServer(http.Dir(dir)))
+		remote := offline.URL + "/downloader.txt"
+		opts := []Opt{
+			WithCacheDir(t.TempDir()),
+			WithExpectedDigest(digest.Digest("sha256sums:" + offline.URL + "/SHA256SUMS")),
+			WithChecksumSignature(cosign),
+		}
+		r, err := Download(context.Background(), "", remote, opts...)
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusDownloaded)
+		offline.Close()
+
+		// The checksum file and the signature stored in the cache entry are used
+		r, err = Download(context.Background(), filepath.Join(t.TempDir(), "data"), remote, opts...)
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusUsedCache)
+		assert.Assert(t, r.ValidatedDigest)
+		_, err = Cached(remote, opts...)
+		assert.NilError(t, err)
+
+		// The stored signature is verified again with the public keys
+		anotherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NilError(t, err)
+		anotherDER, err := x509.MarshalPKIXPublicKey(&anotherKey.PublicKey)
+		assert.NilError(t, err)
+		anotherPublicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: anotherDER}))
+		_, err = Download(context.Background(), filepath.Join(t.TempDir(), "data"), remote,
+			append(opts, WithChecksumSignature(&ChecksumSignature{Type: limayaml.SignatureTypeCosign, PublicKeys: []string{anotherPublicKey}, SignatureSuffix: ".sig"}))...)
+		assert.ErrorContains(t, err, "failed to read the checksum file")
+	})
+
+	t.Run("local checksum file", func(t *testing.T) {
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "data"), ts.URL+"/downloader.txt",
+			WithExpectedDigest(digest.Digest("sha256sums:"+filepath.Join(dir, "SHA256SUMS"))))
+		assert.NilError(t, err)
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "data"), ts.URL+"/downloader.txt",
+			WithExpectedDigest(digest.Digest("sha256sums:"+ts.URL+"/TAMPERED")))
+		assert.ErrorContains(t, err, "expected digest")
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "data"), ts.URL+"/downloader.txt",
+			WithExpectedDigest(digest.Digest("sha256sums:"+ts.URL+"/TAMPERED")),
+			WithChecksumSignature(cosign))
+		assert.ErrorContains(t, err, "the signature was not made by any of the public keys")
+	})
+
+	t.Run("missing signature", func(t *testing.T) {
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "data"), ts.URL+"/downloader.txt",
+			WithExpectedDigest(digest.Digest("sha256sums:"+ts.URL+"/SHA256SUMS")),
+			WithChecksumSignature(&ChecksumSignature{Type: limayaml.SignatureTypeCosign, PublicKeys: []string{publicKey}, SignatureSuffix: ".missing"}))
+		assert.ErrorContains(t, err, "failed to read the signature")
+	})
+}
+
+func TestVerifyGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	if _, err := exec.LookPath("gpgv"); err != nil {
+		t.Skip("gpgv is not installed")
+	}
+	// A short path for the socket of gpg-agent
+	homedir, err := os.MkdirTemp("", "gpg")
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", homedir, "--kill", "all").Run()
+		_ = os.RemoveAll(homedir)
+	})
+	gpg := func(stdin []byte, args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--homedir", homedir, "--batch", "--pinentry-mode", "loopback", "--passphrase", ""}, args...)...)
+		if stdin != nil {
+			cmd.Stdin = bytes.NewReader(stdin)
+		}
+		out, err := cmd.Output()
+		assert.NilError(t, err, "%v", cmd.Args)
+		return out
+	}
+	gpg(nil, "--quick-generate-key", "lima-test@example.com", "ed25519", "sign", "never")
+	publicKey := string(gpg(nil, "--armor", "--export", "lima-test@example.com"))
+	data := []byte(dummyRemoteFileSHA256 + "  downloader.txt\n")
+	signature := gpg(data, "--armor", "--detach-sign")
+
+	assert.NilError(t, verifyGPG(context.Background(), data, signature, []string{publicKey}))
+	err = verifyGPG(context.Background(), []byte("tampered"), signature, []string{publicKey})
+	assert.ErrorContains(t, err, "failed to run")
+}
@@ -0,0 +1,174 @@
+package downloader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
+)
+
+// useExternalDecompressor returns true when $LIMA_EXTERNAL_DECOMPRESSOR is set to true.
+// The external commands (`gzip`, `bzip2`, `xz`, `zstd`) may be faster than the decompressors written in Go,
+// but the data is decompressed after the download completes.
+func useExternalDecompressor() bool {
+	envVar := os.Getenv("LIMA_EXTERNAL_DECOMPRESSOR")
+	if envVar == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(envVar)
+	if err != nil {
+		logrus.WithError(err).Warnf("invalid LIMA_EXTERNAL_DECOMPRESSOR value %q", envVar)
+		return false
+	}
+	return b
+}
+
+// decompressorByHeader returns the decompressor for the magic number in the header, or an empty string.
+func decompressorByHeader(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return "gzip"
+	case bytes.HasPrefix(header, []byte{0x42, 0x5a}):
+		return "bzip2"
+	case bytes.HasPrefix(header, []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}):
+		return "xz"
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// newDecompressReader returns a reader that decompresses r with the decompressor ("gzip", "bzip2", "xz", or "zstd").
+func newDecompressReader(decompressor string, r io.Reader) (io.ReadCloser, error) {
+	switch decompressor {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "bzip2":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case "xz":
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown decompressor %q", decompressor)
+	}
+}
+
+// errNotCompressed is returned by streamDecompressor.Wait when the data is not compressed.
+var errNotCompressed = errors.New("not compressed")
+
+// streamDecompressor decompresses the data written to it into a file, concurrently with the download.
+//
+// Write never fails, so that the download is not interrupted by the decompression: after an error, the
+// data is discarded, and the error is returned by Wait. The caller is expected to decompress the
+// downloaded file again on errors, including errNotCompressed.
+type streamDecompressor struct {
+	dst  string
+	tmp  string
+	pw   *io.PipeWriter
+	done chan error
+	once sync.Once
+	err  error
+}
+
+// newStreamDecompressor starts decompressing the data written to it into dst.
+// The decompressor is detected from the magic number of the data.
+func newStreamDecompressor(dst string) *streamDecompressor {
+	pr, pw := io.Pipe()
+	d := &streamDecompressor{
+		dst:  dst,
+		tmp:  perProcessTempfile(dst),
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := d.decompress(pr)
+		// Unblock Write
+		pr.CloseWithError(err)
+		d.done <- err
+	}()
+	return d
+}
+
+func (d *streamDecompressor) decompress(r io.Reader) error {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(6)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	decompressor := decompressorByHeader(header)
+	if decompressor == "" {
+		return errNotCompressed
+	}
+	logrus.Debugf("decompressing %q with %s, concurrently with the download", d.dst, decompressor)
+	dr, err := newDecompressReader(decompressor, br)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	out, err := os.Create(d.tmp)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, dr); err != nil {
+		return fmt.Errorf("failed to decompress with %s: %w", decompressor, err)
+	}
+	// Drain the trailing data, if any, so that the download is not blocked
+	if _, err := io.Copy(io.Discard, br); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func (d *streamDecompressor) Write(p []byte) (int, error) {
+	if d.err == nil {
+		if _, err := d.pw.Write(p); err != nil {
+			d.err = err
+		}
+	}
+	return len(p), nil
+}
+
+// Wait waits for the decompression, and renames the decompressed file to dst on success.
+// When the download failed, Wait must be called with the error to abort the decompression.
+// Only the first call has effect.
+func (d *streamDecompressor) Wait(downloadErr error) error {
+	d.once.Do(func() {
+		if downloadErr != nil {
+			_ = d.pw.CloseWithError(downloadErr)
+		} else {
+			_ = d.pw.Close()
+		}
+		err := <-d.done
+		if err == nil && downloadErr == nil {
+			err = os.Rename(d.tmp, d.dst)
+		} else if err == nil {
+			err = downloadErr
+		}
+		if err != nil {
+			_ = os.RemoveAll(d.tmp)
+		}
+		d.err = err
+	})
+	return d.err
+}
@@ -0,0 +1,133 @@
+package downloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"gotest.tools/v3/assert"
+)
+
+var testDecompressedContents = bytes.Repeat([]byte("TestDownloadDecompressed\n"), 4096)
+
+func compressForTest(t *testing.T, decompressor string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch decompressor {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "xz":
+		w, err = xz.NewWriter(&buf)
+	case "zstd":
+		w, err = zstd.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown decompressor %q", decompressor)
+	}
+	assert.NilError(t, err)
+	_, err = w.Write(data)
+	assert.NilError(t, err)
+	assert.NilError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestDownloadDecompressed(t *testing.T) {
+	dir := t.TempDir()
+	for name, decompressor := range map[string]string{"image.gz": "gzip", "image.xz": "xz", "image.zst": "zstd"} {
+		assert.NilError(t, os.WriteFile(filepath.Join(dir, name), compressForTest(t, decompressor, testDecompressedContents), 0o644))
+	}
+	// The decompressor is detected by the magic number
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "image-zstd.img"), compressForTest(t, "zstd", testDecompressedContents), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "image.raw"), testDecompressedContents, 0o644))
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(ts.Close)
+
+	for _, name := range []string{"image.gz", "image.xz", "image.zst", "image-zstd.img", "image.raw"} {
+		t.Run(name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			localPath := filepath.Join(t.TempDir(), "disk")
+			r, err := Download(context.Background(), localPath, ts.URL+"/"+name, WithCacheDir(cacheDir), WithDecompress(true))
+			assert.NilError(t, err)
+			assert.Equal(t, r.Status, StatusDownloaded)
+			got, err := os.ReadFile(localPath)
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, testDecompressedContents))
+			// The cache keeps the original data
+			cached, err := os.ReadFile(r.CachePath)
+			assert.NilError(t, err)
+			expected, err := os.ReadFile(filepath.Join(dir, name))
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(cached, expected))
+
+			// Decompressed from the cache
+			localPath = filepath.Join(t.TempDir(), "disk")
+			r, err = Download(context.Background(), localPath, ts.URL+"/"+name, WithCacheDir(cacheDir), WithDecompress(true))
+			assert.NilError(t, err)
+			assert.Equal(t, r.Status, StatusUsedCache)
+			got, err = os.ReadFile(localPath)
+			assert.NilError(t, err)
+			assert.Assert(t, bytes.Equal(got, testDecompressedContents))
+		})
+	}
+
+	t.Run("external decompressor", func(t *testing.T) {
+		if _, err := exec.LookPath("gzip"); err != nil {
+			t.Skip("gzip is not installed")
+		}
+		t.Setenv("LIMA_EXTERNAL_DECOMPRESSOR", "true")
+		localPath := filepath.Join(t.TempDir(), "disk")
+		_, err := Download(context.Background(), localPath, ts.URL+"/image.gz", WithCacheDir(t.TempDir()), WithDecompress(true))
+		assert.NilError(t, err)
+		got, err := os.ReadFile(localPath)
+		assert.NilError(t, err)
+		assert.Assert(t, bytes.Equal(got, testDecompressedContents))
+	})
+}
+
+func TestStreamDecompressor(t *testing.T) {
+	compressed := compressForTest(t, "gzip", testDecompressedContents)
+
+	t.Run("aborted", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "disk")
+		d := newStreamDecompressor(dst)
+		_, err := d.Write(compressed[:len(compressed)/2])
+		assert.NilError(t, err)
+		err = d.Wait(errors.New("download failed"))
+		assert.ErrorContains(t, err, "download failed")
+		_, err = os.Stat(dst)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist))
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "disk")
+		d := newStreamDecompressor(dst)
+		// Write never fails, even when the decompression fails
+		_, err := d.Write(compressed[:10])
+		assert.NilError(t, err)
+		_, err = d.Write(bytes.Repeat([]byte{0xff}, 1024))
+		assert.NilError(t, err)
+		assert.ErrorContains(t, d.Wait(nil), "failed to decompress with gzip")
+		_, err = os.Stat(dst)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist))
+	})
+
+	t.Run("not compressed", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "disk")
+		d := newStreamDecompressor(dst)
+		_, err := d.Write(testDecompressedContents)
+		assert.NilError(t, err)
+		assert.Assert(t, errors.Is(d.Wait(nil), errNotCompressed))
+		_, err = os.Stat(dst)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist))
+	})
+}
@@ -7,13 +7,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
@@ -49,6 +52,7 @@ type Result struct {
 	LastModified    time.Time
 	ContentType     string
 	ValidatedDigest bool
+	URL             string // the location that served the file (the remote resource or a mirror), set only for StatusDownloaded
 }
 
 type options struct {
@@ -56,6 +60,13 @@ type options struct {
 	decompress     bool   // default: false (keep compression)
 	description    string // default: url
 	expectedDigest digest.Digest
+	mirrors        []string
+
+	checksums         string           // location of the checksum file, resolved into expectedDigest by Download
+	checksumsAlgo     digest.Algorithm // algorithm of the checksums
+	checksumSignature *ChecksumSignature
+
+	maxCacheSize int64 // default: 0 (unlimited)
 }
 
 func (o *options) apply(opts []Opt) error {
@@ -70,6 +81,7 @@ func (o *options) apply(opts []Opt) error {
 type Opt func(*options) error
 
 // WithCache enables caching using filepath.Join(os.UserCacheDir(), "lima") as the cache dir.
+// The size of the cache is limited by $LIMA_CACHE_MAX_SIZE, when it is set.
 func WithCache() Opt {
 	return func(o *options) error {
 		ucd, err := os.UserCacheDir()
@@ -77,7 +89,15 @@ func WithCache() Opt {
 			return err
 		}
 		cacheDir := filepath.Join(ucd, "lima")
-		return WithCacheDir(cacheDir)(o)
+		if err := WithCacheDir(cacheDir)(o); err != nil {
+			return err
+		}
+		maxCacheSize, err := MaxCacheSize()
+		if err != nil {
+			logrus.WithError(err).Warn("ignoring the max cache size")
+			return nil
+		}
+		return WithMaxCacheSize(maxCacheSize)(o)
 	}
 }
 
@@ -115,8 +135,22 @@ func WithDecompress(decompress bool) Opt {
 // When the `data` file exists in the cache dir with `<ALGO>.digest` file,
 // the digest is verified by comparing the content of `<ALGO>.digest` with the expected
 // digest string. So, the actual digest of the `data` file is not computed.
+//
+// The digest can also refer to a checksum file as "<ALGO>sums:URL" (e.g., "sha256sums:https://example.com/SHA256SUMS").
+// The checksum file is fetched by Download, and its signature is verified when WithChecksumSignature is specified.
+// The checksum file is stored in the cache entry, and is used instead of fetching it again while the entry has the data.
+// The expected digest is the checksum of the base name of the remote URL in the checksum file.
 func WithExpectedDigest(expectedDigest digest.Digest) Opt {
 	return func(o *options) error {
+		if algo, location, ok := parseChecksumsDigest(expectedDigest); ok {
+			if !algo.Available() {
+				return fmt.Errorf("expected digest algorithm %q is not available", algo)
+			}
+			o.expectedDigest = ""
+			o.checksums = location
+			o.checksumsAlgo = algo
+			return nil
+		}
 		if expectedDigest != "" {
 			if !expectedDigest.Algorithm().Available() {
 				return fmt.Errorf("expected digest algorithm %q is not available", expectedDigest.Algorithm())
@@ -131,6 +165,21 @@ func WithExpectedDigest(expectedDigest digest.Digest) Opt {
 	}
 }
 
+// WithMirrors specifies the alternative URLs of the remote resource.
+// The mirrors are tried in order when downloading from the remote resource fails.
+// The cache entry is still keyed by the URL of the remote resource.
+func WithMirrors(mirrors ...string) Opt {
+	return func(o *options) error {
+		for _, m := range mirrors {
+			if IsLocal(m) {
+				return fmt.Errorf("mirror %q must be a remote URL", m)
+			}
+		}
+		o.mirrors = mirrors
+		return nil
+	}
+}
+
 func readFile(path string) string {
 	if path == "" {
 		return ""
@@ -206,6 +255,16 @@ func Download(ctx context.Context, local, remote string, opts ...Opt) (*Result,
 		}
 	}
 
+	var checksums *checksumsFile
+	if o.checksums != "" {
+		expectedDigest, fetchedChecksums, err := resolveChecksums(ctx, remote, o)
+		if err != nil {
+			return nil, err
+		}
+		o.expectedDigest = expectedDigest
+		checksums = fetchedChecksums
+	}
+
 	ext := path.Ext(remote)
 	if IsLocal(remote) {
 		if err := copyLocal(ctx, localPath, remote, ext, o.decompress, o.description, o.expectedDigest); err != nil {
@@ -214,17 +273,20 @@ func Download(ctx context.Context, local, remote string, opts ...Opt) (*Result,
 		res := &Result{
 			Status:          StatusDownloaded,
 			ValidatedDigest: o.expectedDigest != "",
+			URL:             remote,
 		}
 		return res, nil
 	}
 
 	if o.cacheDir == "" {
-		if err := downloadHTTP(ctx, localPath, "", "", remote, o.description, o.expectedDigest); err != nil {
+		url, _, err := downloadMirrors(ctx, localPath, "", "", "", "", remote, o)
+		if err != nil {
 			return nil, err
 		}
 		res := &Result{
 			Status:          StatusDownloaded,
 			ValidatedDigest: o.expectedDigest != "",
+			URL:             url,
 		}
 		return res, nil
 	}
@@ -241,13 +303,26 @@ func Download(ctx context.Context, local, remote string, opts ...Opt) (*Result,
 		if err != nil {
 			return err
 		}
-		if res != nil {
-			return nil
+		if res == nil {
+			res, err = fetch(ctx, localPath, remote, o)
+			if err != nil {
+				return err
+			}
 		}
-		res, err = fetch(ctx, localPath, remote, o)
-		return err
+		if checksums != nil {
+			if err := checksums.store(shad); err != nil {
+				return err
+			}
+		}
+		return touchCacheEntry(shad)
 	})
-	return res, err
+	if err != nil {
+		return nil, err
+	}
+	if res.Status == StatusDownloaded {
+		evictCacheAfterDownload(remote, o)
+	}
+	return res, nil
 }
 
 // getCached tries to copy the file from the cache to local path. Return result,
@@ -276,6 +351,17 @@ func getCached(ctx context.Context, localPath, remote string, o options) (*Resul
 		if err := copyLocal(ctx, localPath, shadData, ext, o.decompress, "", ""); err != nil {
 			return nil, err
 		}
+	} else if IsOCI(remote) {
+		if match, dCached, dRemote, err := matchOCIDigest(ctx, shad, remote); err != nil {
+			logrus.WithError(err).Info("Failed to resolve the digest of the OCI reference for cached digest-less image; using cached image.")
+		} else if match {
+			if err := copyLocal(ctx, localPath, shadData, ext, o.decompress, o.description, o.expectedDigest); err != nil {
+				return nil, err
+			}
+		} else {
+			logrus.Infof("Re-downloading digest-less image: digest mismatch (cached: %q, remote: %q)", dCached, dRemote)
+			return nil, nil
+		}
 	} else {
 		if match, lmCached, lmRemote, err := matchLastModified(ctx, shadTime, remote); err != nil {
 			logrus.WithError(err).Info("Failed to retrieve last-modified for cached digest-less image; using cached image.")
@@ -308,12 +394,19 @@ func fetch(ctx context.Context, localPath, remote string, o options) (*Result, e
 	if err != nil {
 		return nil, err
 	}
+	shadPartial := filepath.Join(shad, "partial")
 	ext := path.Ext(remote)
 	shadURL := filepath.Join(shad, "url")
 	if err := os.WriteFile(shadURL, []byte(remote), 0o644); err != nil {
 		return nil, err
 	}
-	if err := downloadHTTP(ctx, shadData, shadTime, shadType, remote, o.description, o.expectedDigest); err != nil {
+	// The data is decompressed into localPath concurrently with the download, unless the external decompressor is preferred
+	var decompressPath string
+	if o.decompress && localPath != "" && !useExternalDecompressor() {
+		decompressPath = localPath
+	}
+	url, verifiedDigest, err := downloadMirrors(ctx, shadData, shadTime, shadType, shadPartial, decompressPath, remote, o)
+	if err != nil {
 		return nil, err
 	}
 	if shadDigest != "" && o.expectedDigest != "" {
@@ -321,6 +414,22 @@ func fetch(ctx context.Context, localPath, remote string, o options) (*Result, e
 			return nil, err
 		}
 	}
+	// The digest of an OCI layer is recorded even when the expected digest is not specified, to detect the update of the tag
+	if verifiedDigest != "" && verifiedDigest != o.expectedDigest {
+		verifiedDigestPath, err := cacheDigestPath(shad, verifiedDigest)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(verifiedDigestPath, []byte(verifiedDigest.String()), 0o644); err != nil {
+			return nil, err
+		}
+	}
+	if decompressPath != "" {
+		if _, err := os.Stat(decompressPath); err == nil {
+			// already decompressed during the download
+			localPath = ""
+		}
+	}
 	// no need to pass the digest to copyLocal(), as we already verified the digest
 	if err := copyLocal(ctx, localPath, shadData, ext, o.decompress, "", ""); err != nil {
 		return nil, err
@@ -331,6 +440,7 @@ func fetch(ctx context.Context, localPath, remote string, o options) (*Result, e
 		LastModified:    readTime(shadTime),
 		ContentType:     readFile(shadType),
 		ValidatedDigest: o.expectedDigest != "",
+		URL:             url,
 	}
 	return res, nil
 }
@@ -354,6 +464,17 @@ func Cached(remote string, opts ...Opt) (*Result, error) {
 	}
 
 	shad := cacheDirectoryPath(o.cacheDir, remote)
+	if o.checksums != "" {
+		f := readCachedChecksums(shad, o)
+		if f == nil {
+			return nil, fmt.Errorf("the checksum file %q must be fetched to validate the cache", o.checksums)
+		}
+		expectedDigest, err := f.digest(context.Background(), remote, o)
+		if err != nil {
+			return nil, err
+		}
+		o.expectedDigest = expectedDigest
+	}
 	shadData := filepath.Join(shad, "data")
 	shadTime := filepath.Join(shad, "time")
 	shadType := filepath.Join(shad, "type")
@@ -403,6 +524,13 @@ func Cached(remote string, opts ...Opt) (*Result, error) {
 //   - "data" file contains the data
 //   - "time" file contains the time (Last-Modified header)
 //   - "type" file contains the type (Content-Type header)
+//   - "partial" file contains the data of an interrupted download, which is resumed by the next download
+//   - "partial.validator" file contains the ETag or the Last-Modified header of the partial data
+//   - "partial.url" file contains the URL of the partial data, which may be a mirror
+//   - "checksums" file contains the checksum file the digest was resolved with, for resolving the digest without the network
+//   - "checksums.sig" file contains the signature of the checksum file
+//   - "checksums.url" file contains the location of the checksum file
+//   - "last-used" file is touched on every download, for the LRU eviction
 func cacheDirectoryPath(cacheDir, remote string) string {
 	return filepath.Join(cacheDir, "download", "by-url-sha256", CacheKey(remote))
 }
@@ -503,29 +631,29 @@ func decompressorByMagic(file string) string {
 	}
 	defer f.Close()
 	header := make([]byte, 6)
-	if _, err := f.Read(header); err != nil {
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return ""
 	}
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		return ""
-	}
-	if bytes.HasPrefix(header, []byte{0x1f, 0x8b}) {
-		return "gzip"
-	}
-	if bytes.HasPrefix(header, []byte{0x42, 0x5a}) {
-		return "bzip2"
-	}
-	if bytes.HasPrefix(header, []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}) {
-		return "xz"
-	}
-	if bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
-		return "zstd"
-	}
-	return ""
+	return decompressorByHeader(header[:n])
 }
 
-func decompressLocal(ctx context.Context, decompressCmd, dst, src, ext, description string) error {
-	logrus.Infof("decompressing %s with %v", ext, decompressCmd)
+// decompressLocal decompresses src into dst with the decompressor ("gzip", "bzip2", "xz", or "zstd").
+// The decompressor written in Go is used, unless $LIMA_EXTERNAL_DECOMPRESSOR is true and the command is installed.
+func decompressLocal(ctx context.Context, decompressor, dst, src, ext, description string) error {
+	external := false
+	if useExternalDecompressor() {
+		if _, err := exec.LookPath(decompressor); err == nil {
+			external = true
+		} else {
+			logrus.WithError(err).Warnf("%s is not installed, falling back to the built-in decompressor", decompressor)
+		}
+	}
+	if external {
+		logrus.Infof("decompressing %s with %v", ext, decompressor)
+	} else {
+		logrus.Infof("decompressing %s with the built-in %s decompressor", ext, decompressor)
+	}
 
 	st, err := os.Stat(src)
 	if err != nil {
@@ -544,16 +672,13 @@ func decompressLocal(ctx context.Context, decompressCmd, dst, src, ext, descript
 		return err
 	}
 	defer in.Close()
-	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0o644)
+	dstTmp := perProcessTempfile(dst)
+	out, err := os.Create(dstTmp)
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(dstTmp)
 	defer out.Close()
-	buf := new(bytes.Buffer)
-	cmd := exec.CommandContext(ctx, decompressCmd, "-d") // -d --decompress
-	cmd.Stdin = bar.NewProxyReader(in)
-	cmd.Stdout = out
-	cmd.Stderr = buf
 	if !HideProgress {
 		if description == "" {
 			description = filepath.Base(src)
@@ -561,16 +686,61 @@ func decompressLocal(ctx context.Context, decompressCmd, dst, src, ext, descript
 		logrus.Infof("Decompressing %s\n", description)
 	}
 	bar.Start()
-	err = cmd.Run()
+	if external {
+		err = decompressExternal(ctx, decompressor, out, bar.NewProxyReader(in))
+	} else {
+		err = decompressBuiltin(ctx, decompressor, out, bar.NewProxyReader(in))
+	}
+	bar.Finish()
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dstTmp, dst)
+}
+
+func decompressExternal(ctx context.Context, decompressor string, w io.Writer, r io.Reader) error {
+	buf := new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, decompressor, "-d") // -d --decompress
+	cmd.Stdin = r
+	cmd.Stdout = w
+	cmd.Stderr = buf
+	err := cmd.Run()
 	if err != nil {
 		if ee, ok := err.(*exec.ExitError); ok {
 			ee.Stderr = buf.Bytes()
 		}
 	}
-	bar.Finish()
 	return err
 }
 
+func decompressBuiltin(ctx context.Context, decompressor string, w io.Writer, r io.Reader) error {
+	dr, err := newDecompressReader(decompressor, r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	if _, err := io.Copy(w, &contextReader{ctx: ctx, r: dr}); err != nil {
+		return fmt.Errorf("failed to decompress with %s: %w", decompressor, err)
+	}
+	return nil
+}
+
+// contextReader stops reading when the context is canceled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
 func validateCachedDigest(shadDigest string, expectedDigest digest.Digest) error {
 	if expectedDigest == "" {
 		return nil
@@ -627,7 +797,11 @@ func matchLastModified(ctx context.Context, lastModifiedPath, url string) (match
 	if lmCached == "" {
 		return false, "<not cached>", "<not checked>", nil
 	}
-	resp, err := httpclientutil.Head(ctx, http.DefaultClient, url)
+	client, err := httpclientutil.DefaultClient()
+	if err != nil {
+		return false, lmCached, "<not checked>", err
+	}
+	resp, err := httpclientutil.Head(ctx, client, url)
 	if err != nil {
 		return false, lmCached, "<failed to fetch remote>", err
 	}
@@ -649,16 +823,144 @@ func matchLastModified(ctx context.Context, lastModifiedPath, url string) (match
 	return false, lmCached, lmRemote, nil
 }
 
-func downloadHTTP(ctx context.Context, localPath, lastModified, contentType, url, description string, expectedDigest digest.Digest) error {
+// maxAttempts is the number of the attempts to download from a URL when the errors are transient.
+const maxAttempts = 3
+
+// retryInterval is the interval before the first retry. It is doubled for each retry.
+// It is a variable for testing.
+var retryInterval = time.Second
+
+// downloadMirrors downloads the remote resource, or one of the mirrors in order, and returns the URL that served the data
+// and the verified digest of the data.
+// Each URL is retried with exponential backoff on transient errors.
+func downloadMirrors(ctx context.Context, localPath, lastModified, contentType, partialPath, decompressPath, remote string, o options) (string, digest.Digest, error) {
+	var errs []error
+	for _, url := range append([]string{remote}, o.mirrors...) {
+		verifiedDigest, err := downloadWithRetries(ctx, localPath, lastModified, contentType, partialPath, decompressPath, url, o.description, o.expectedDigest)
+		if err == nil {
+			if url != remote {
+				logrus.Infof("Downloaded %q from the mirror %q", remote, url)
+			}
+			return url, verifiedDigest, nil
+		}
+		if ctx.Err() != nil {
+			return "", "", err
+		}
+		errs = append(errs, fmt.Errorf("failed to download %q: %w", url, err))
+		if len(o.mirrors) > 0 {
+			logrus.WithError(err).Warnf("Failed to download %q, trying the next mirror", url)
+		}
+	}
+	return "", "", errors.Join(errs...)
+}
+
+func downloadWithRetries(ctx context.Context, localPath, lastModified, contentType, partialPath, decompressPath, url, description string, expectedDigest digest.Digest) (digest.Digest, error) {
+	interval := retryInterval
+	for attempt := 1; ; attempt++ {
+		verifiedDigest, err := downloadURL(ctx, localPath, lastModified, contentType, partialPath, decompressPath, url, description, expectedDigest)
+		if err == nil || attempt == maxAttempts || !isTransient(ctx, err) {
+			return verifiedDigest, err
+		}
+		logrus.WithError(err).Warnf("Failed to download %q (attempt %d/%d), retrying in %v", url, attempt, maxAttempts, interval)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// downloadURL downloads an HTTP(S) URL or an OCI reference, and returns the verified digest of the data.
+func downloadURL(ctx context.Context, localPath, lastModified, contentType, partialPath, decompressPath, url, description string, expectedDigest digest.Digest) (digest.Digest, error) {
+	if IsOCI(url) {
+		return downloadOCI(ctx, localPath, lastModified, contentType, partialPath, decompressPath, url, description, expectedDigest)
+	}
+	if err := downloadHTTP(ctx, localPath, lastModified, contentType, partialPath, decompressPath, url, description, expectedDigest, nil); err != nil {
+		return "", err
+	}
+	return expectedDigest, nil
+}
+
+// isTransient returns true for the network errors and the HTTP status codes 408, 429, and 5XX.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var statusErr *httpclientutil.HTTPStatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code/100 == 5
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// downloadHTTP downloads the url into localPath.
+//
+// When partialPath is set, the data is downloaded into partialPath, which is kept on failures along with
+// the validator of the response (strong ETag or Last-Modified) in partialPath + ".validator", and the url in partialPath + ".url".
+// The next call for the same url resumes the download with `Range` and `If-Range` requests, and starts over
+// when the server does not support them or the remote resource was modified.
+// The caller must hold the lock of the directory of partialPath.
+//
+// When partialPath is empty, the data is downloaded into a per-process temp file, which is removed on failures.
+//
+// When decompressPath is set, compressed data is also decompressed into decompressPath concurrently with the download.
+// decompressPath is not created when the data is not compressed, when the decompression fails, or when the download is resumed.
+//
+// The header is added to the request, e.g., for the authorization of an OCI registry.
+func downloadHTTP(ctx context.Context, localPath, lastModified, contentType, partialPath, decompressPath, url, description string, expectedDigest digest.Digest, header http.Header) error {
 	if localPath == "" {
 		return errors.New("downloadHTTP: got empty localPath")
 	}
 	logrus.Debugf("downloading %q into %q", url, localPath)
 
-	resp, err := httpclientutil.Get(ctx, http.DefaultClient, url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	var offset int64
+	validatorPath := partialPath + ".validator"
+	urlPath := partialPath + ".url"
+	if partialPath != "" {
+		if st, err := os.Stat(partialPath); err == nil {
+			// The partial data from another mirror is not resumed, as the validators of the mirrors are not comparable
+			if validator := readFile(validatorPath); validator != "" && st.Size() > 0 && readFile(urlPath) == url {
+				offset = st.Size()
+				req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+				req.Header.Set("If-Range", validator)
+			}
+		}
+	}
+	client, err := httpclientutil.DefaultClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+		logrus.Debugf("the partial download %q is not satisfiable, starting over", partialPath)
+		if err := removePartial(partialPath); err != nil {
+			return err
+		}
+		return downloadHTTP(ctx, localPath, lastModified, contentType, partialPath, decompressPath, url, description, expectedDigest, header)
+	}
+	if err := httpclientutil.Successful(resp); err != nil {
+		return err
+	}
+	resumed := offset > 0 && resp.StatusCode == http.StatusPartialContent && contentRangeStart(resp) == offset
+	if offset > 0 && !resumed {
+		logrus.Infof("Could not resume the partial download of %q (status %q), starting over", url, resp.Status)
+		offset = 0
+	}
+
 	if lastModified != "" {
 		lm := resp.Header.Get("Last-Modified")
 		if err := os.WriteFile(lastModified, []byte(lm), 0o644); err != nil {
@@ -671,8 +973,11 @@ func downloadHTTP(ctx context.Context, localPath, lastModified, contentType, url
 			return err
 		}
 	}
-	defer resp.Body.Close()
-	bar, err := progressbar.New(resp.ContentLength)
+	size := resp.ContentLength
+	if size >= 0 {
+		size += offset
+	}
+	bar, err := progressbar.New(size)
 	if err != nil {
 		return err
 	}
@@ -680,17 +985,42 @@ func downloadHTTP(ctx context.Context, localPath, lastModified, contentType, url
 		hideBar(bar)
 	}
 
-	localPathTmp := perProcessTempfile(localPath)
-	fileWriter, err := os.Create(localPathTmp)
-	if err != nil {
-		return err
+	var fileWriter *os.File
+	localPathTmp := partialPath
+	if partialPath == "" {
+		localPathTmp = perProcessTempfile(localPath)
+		fileWriter, err = os.Create(localPathTmp)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(localPathTmp)
+	} else {
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if resumed {
+			flags = os.O_WRONLY | os.O_APPEND
+		}
+		fileWriter, err = os.OpenFile(partialPath, flags, 0o644)
+		if err != nil {
+			return err
+		}
+		// A partial download without a validator cannot be resumed, so it is removed on failures
+		validator := responseValidator(resp)
+		if validator == "" {
+			_ = os.Remove(validatorPath)
+			defer os.RemoveAll(partialPath)
+		} else if err := os.WriteFile(validatorPath, []byte(validator), 0o644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(urlPath, []byte(url), 0o644); err != nil {
+			return err
+		}
 	}
 	defer fileWriter.Close()
-	defer os.RemoveAll(localPathTmp)
 
 	writers := []io.Writer{fileWriter}
 	var digester digest.Digester
-	if expectedDigest != "" {
+	// The digest of a resumed download is validated with validateLocalFileDigest after the download
+	if expectedDigest != "" && !resumed {
 		algo := expectedDigest.Algorithm()
 		if !algo.Available() {
 			return fmt.Errorf("unsupported digest algorithm %q", algo)
@@ -699,6 +1029,13 @@ func downloadHTTP(ctx context.Context, localPath, lastModified, contentType, url
 		hasher := digester.Hash()
 		writers = append(writers, hasher)
 	}
+	var decompressing *streamDecompressor
+	if decompressPath != "" && !resumed {
+		decompressing = newStreamDecompressor(decompressPath)
+		// Aborts the decompression on failures; no-op after the Wait below
+		defer decompressing.Wait(errors.New("download failed")) //nolint:errcheck
+		writers = append(writers, decompressing)
+	}
 	multiWriter := io.MultiWriter(writers...)
 
 	if !HideProgress {
@@ -706,29 +1043,84 @@ func downloadHTTP(ctx context.Context, localPath, lastModified, contentType, url
 			description = url
 		}
 		// stderr corresponds to the progress bar output
-		fmt.Fprintf(os.Stderr, "Downloading %s\n", description)
+		if resumed {
+			fmt.Fprintf(os.Stderr, "Resuming download of %s\n", description)
+		} else {
+			fmt.Fprintf(os.Stderr, "Downloading %s\n", description)
+		}
 	}
+	bar.SetCurrent(offset)
 	bar.Start()
 	if _, err := io.Copy(multiWriter, bar.NewProxyReader(resp.Body)); err != nil {
 		return err
 	}
 	bar.Finish()
 
+	if err := fileWriter.Sync(); err != nil {
+		return err
+	}
+	if err := fileWriter.Close(); err != nil {
+		return err
+	}
+
 	if digester != nil {
-		actualDigest := digester.Digest()
-		if actualDigest != expectedDigest {
+		if actualDigest := digester.Digest(); actualDigest != expectedDigest {
+			if partialPath != "" {
+				_ = removePartial(partialPath)
+			}
 			return fmt.Errorf("expected digest %q, got %q", expectedDigest, actualDigest)
 		}
+	} else if resumed {
+		if err := validateLocalFileDigest(partialPath, expectedDigest); err != nil {
+			_ = removePartial(partialPath)
+			return err
+		}
 	}
 
-	if err := fileWriter.Sync(); err != nil {
-		return err
+	if decompressing != nil {
+		// The caller decompresses the downloaded file again when decompressPath is not created
+		if err := decompressing.Wait(nil); err != nil && !errors.Is(err, errNotCompressed) {
+			logrus.WithError(err).Debugf("failed to decompress %q concurrently with the download", url)
+		}
 	}
-	if err := fileWriter.Close(); err != nil {
+	if err := os.Rename(localPathTmp, localPath); err != nil {
 		return err
 	}
+	if partialPath != "" {
+		return errors.Join(os.RemoveAll(validatorPath), os.RemoveAll(urlPath))
+	}
+	return nil
+}
+
+// responseValidator returns the validator for `If-Range`: the ETag if it is strong, or the Last-Modified time.
+// Weak ETags cannot be used for `If-Range` (RFC 9110 section 13.1.5).
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// contentRangeStart returns the first byte position of the `Content-Range: bytes FIRST-LAST/SIZE` header, or -1.
+func contentRangeStart(resp *http.Response) int64 {
+	s, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	if !ok {
+		return -1
+	}
+	first, _, ok := strings.Cut(s, "-")
+	if !ok {
+		return -1
+	}
+	n, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
 
-	return os.Rename(localPathTmp, localPath)
+// removePartial removes the partial download, its validator, and its URL.
+func removePartial(partialPath string) error {
+	return errors.Join(os.RemoveAll(partialPath), os.RemoveAll(partialPath+".validator"), os.RemoveAll(partialPath+".url"))
 }
 
 var tempfileCount atomic.Uint64
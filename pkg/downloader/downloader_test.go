@@ -1,14 +1,20 @@
 package downloader
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -255,6 +261,159 @@ func TestRedownloadRemote(t *testing.T) {
 	})
 }
 
+func TestDownloadResume(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		content   = bytes.Repeat([]byte("0123456789abcdef"), 4096)
+		etag      = `"v1"`
+		interrupt bool
+		ranges    []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		content, etag, interrupt := content, etag, interrupt
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		w.Header().Set("ETag", etag)
+		if interrupt {
+			// Send the first half, and abort the connection
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			_, _ = w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "image", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(ts.Close)
+	setServer := func(newContent []byte, newETag string, newInterrupt bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		content, etag, interrupt, ranges = newContent, newETag, newInterrupt, nil
+	}
+	lastRange := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return ranges[len(ranges)-1]
+	}
+	cacheDir := t.TempDir()
+	shad := cacheDirectoryPath(cacheDir, ts.URL+"/image")
+	shadPartial := filepath.Join(shad, "partial")
+
+	t.Run("resume", func(t *testing.T) {
+		setServer(content, `"v1"`, true)
+		_, err := Download(context.Background(), "", ts.URL+"/image", WithCacheDir(cacheDir))
+		assert.Assert(t, err != nil)
+		st, err := os.Stat(shadPartial)
+		assert.NilError(t, err)
+		assert.Equal(t, st.Size(), int64(len(content)/2))
+		assert.Equal(t, readFile(shadPartial+".validator"), `"v1"`)
+
+		setServer(content, `"v1"`, false)
+		r, err := Download(context.Background(), "", ts.URL+"/image", WithCacheDir(cacheDir), WithExpectedDigest(digest.FromBytes(content)))
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusDownloaded)
+		assert.Equal(t, lastRange(), fmt.Sprintf("bytes=%d-", len(content)/2))
+		b, err := os.ReadFile(r.CachePath)
+		assert.NilError(t, err)
+		assert.Assert(t, bytes.Equal(b, content))
+		_, err = os.Stat(shadPartial)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist))
+		_, err = os.Stat(shadPartial + ".validator")
+		assert.Assert(t, errors.Is(err, os.ErrNotExist))
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		assert.NilError(t, os.RemoveAll(shad))
+		setServer(content, `"v1"`, true)
+		_, err := Download(context.Background(), "", ts.URL+"/image", WithCacheDir(cacheDir))
+		assert.Assert(t, err != nil)
+
+		// The If-Range validator does not match, so the whole content is downloaded
+		modified := bytes.Repeat([]byte("fedcba9876543210"), 4096)
+		setServer(modified, `"v2"`, false)
+		r, err := Download(context.Background(), "", ts.URL+"/image", WithCacheDir(cacheDir), WithExpectedDigest(digest.FromBytes(modified)))
+		assert.NilError(t, err)
+		assert.Equal(t, lastRange(), fmt.Sprintf("bytes=%d-", len(content)/2))
+		b, err := os.ReadFile(r.CachePath)
+		assert.NilError(t, err)
+		assert.Assert(t, bytes.Equal(b, modified))
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		assert.NilError(t, os.RemoveAll(shad))
+		setServer(content, `"v1"`, true)
+		_, err := Download(context.Background(), "", ts.URL+"/image", WithCacheDir(cacheDir))
+		assert.Assert(t, err != nil)
+
+		// The partial download is removed when the resumed download does not match the digest
+		setServer(content, `"v1"`, false)
+		_, err = Download(context.Background(), "", ts.URL+"/image", WithCacheDir(cacheDir), WithExpectedDigest(digest.FromString("wrong")))
+		assert.ErrorContains(t, err, "expected digest")
+		_, err = os.Stat(shadPartial)
+		assert.Assert(t, errors.Is(err, os.ErrNotExist))
+	})
+}
+
+func TestDownloadMirrors(t *testing.T) {
+	interval := retryInterval
+	retryInterval = time.Millisecond
+	t.Cleanup(func() { retryInterval = interval })
+
+	newServer := func(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+		var count atomic.Int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := int(count.Add(1))
+			if n <= len(statuses) && statuses[n-1] != http.StatusOK {
+				http.Error(w, http.StatusText(statuses[n-1]), statuses[n-1])
+				return
+			}
+			http.ServeFile(w, r, filepath.Join("testdata", "downloader.txt"))
+		}))
+		t.Cleanup(ts.Close)
+		return ts, &count
+	}
+	const dummyRemoteFileDigest = "sha256:380481d26f897403368be7cb86ca03a4bc14b125bfaf2b93bff809a5a2ad717e"
+
+	t.Run("fallback", func(t *testing.T) {
+		unavailable, unavailableCount := newServer(t, 503, 503, 503, 503)
+		notFound, notFoundCount := newServer(t, 404)
+		mirror, _ := newServer(t)
+		cacheDir := t.TempDir()
+		r, err := Download(context.Background(), "", unavailable.URL+"/downloader.txt",
+			WithCacheDir(cacheDir),
+			WithExpectedDigest(dummyRemoteFileDigest),
+			WithMirrors(notFound.URL+"/downloader.txt", mirror.URL+"/downloader.txt"))
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusDownloaded)
+		assert.Equal(t, r.URL, mirror.URL+"/downloader.txt")
+		// The cache is keyed by the remote resource, not by the mirror
+		assert.Equal(t, r.CachePath, filepath.Join(cacheDirectoryPath(cacheDir, unavailable.URL+"/downloader.txt"), "data"))
+		// The transient errors are retried, but not the other errors
+		assert.Equal(t, unavailableCount.Load(), int32(maxAttempts))
+		assert.Equal(t, notFoundCount.Load(), int32(1))
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		flaky, count := newServer(t, 503, 429)
+		r, err := Download(context.Background(), filepath.Join(t.TempDir(), "data"), flaky.URL+"/downloader.txt")
+		assert.NilError(t, err)
+		assert.Equal(t, r.URL, flaky.URL+"/downloader.txt")
+		assert.Equal(t, count.Load(), int32(3))
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		notFound, _ := newServer(t, 404)
+		gone, _ := newServer(t, 410)
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "data"), notFound.URL+"/downloader.txt",
+			WithMirrors(gone.URL+"/downloader.txt"))
+		assert.ErrorContains(t, err, notFound.URL)
+		assert.ErrorContains(t, err, gone.URL)
+	})
+
+	_, err := Download(context.Background(), "", "https://example.com/image", WithCacheDir(t.TempDir()), WithMirrors("/tmp/image"))
+	assert.ErrorContains(t, err, "must be a remote URL")
+}
+
 func TestDownloadLocal(t *testing.T) {
 	const emptyFileDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
 	const testDownloadLocalDigest = "sha256:0c1e0fba69e8919b306d030bf491e3e0c46cf0a8140ff5d7516ba3a83cbea5b3"
@@ -0,0 +1,299 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/distribution/reference"
+	"github.com/lima-vm/lima/pkg/httpclientutil"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ociScheme = "oci://"
+
+	// maxManifestSize is the maximum size of a manifest, same as containerd.
+	maxManifestSize = 4 << 20
+)
+
+// IsOCI returns true for the "oci://REGISTRY/REPOSITORY[:TAG][@DIGEST]" references.
+func IsOCI(s string) bool {
+	return strings.HasPrefix(s, ociScheme)
+}
+
+// parseOCIReference parses the "oci://REGISTRY/REPOSITORY[:TAG][@DIGEST]" reference.
+// The tag defaults to "latest", and the repositories of Docker Hub are normalized (e.g., "docker.io/library/ubuntu").
+func parseOCIReference(s string) (reference.Named, error) {
+	rest, ok := strings.CutPrefix(s, ociScheme)
+	if !ok {
+		return nil, fmt.Errorf("OCI reference %q must start with %q", s, ociScheme)
+	}
+	if registry, repo, ok := strings.Cut(rest, "/"); !ok || registry == "" || repo == "" {
+		return nil, fmt.Errorf("OCI reference %q must be %q", s, ociScheme+"REGISTRY/REPOSITORY[:TAG][@DIGEST]")
+	}
+	ref, err := reference.ParseDockerRef(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCI reference %q: %w", s, err)
+	}
+	return ref, nil
+}
+
+// ociRegistryHosts returns the hosts of the registries, authorized with the credentials of the docker config.
+// The registries on the loopback addresses are accessed over HTTP, like Docker and containerd do.
+func ociRegistryHosts() (docker.RegistryHosts, error) {
+	client, err := httpclientutil.DefaultClient()
+	if err != nil {
+		return nil, err
+	}
+	authorizer := docker.NewDockerAuthorizer(
+		docker.WithAuthClient(client),
+		docker.WithAuthCreds(func(host string) (string, string, error) {
+			// Docker Hub is served by "registry-1.docker.io"
+			if host == "registry-1.docker.io" {
+				host = "docker.io"
+			}
+			username, password, err := dockerCredentials(host)
+			if err != nil {
+				logrus.WithError(err).Warnf("Failed to get the credentials of %q from the docker config, trying anonymous access", host)
+				return "", "", nil
+			}
+			return username, password, nil
+		}),
+	)
+	return docker.ConfigureDefaultRegistries(
+		docker.WithAuthorizer(authorizer),
+		docker.WithClient(client),
+		docker.WithPlainHTTP(docker.MatchLocalhost),
+	), nil
+}
+
+// dockerCredentials returns the credentials of the registry in $DOCKER_CONFIG/config.json (default: ~/.docker/config.json),
+// from the credential helpers or the "auths" property. Empty credentials are returned when none are found.
+func dockerCredentials(registry string) (username, password string, err error) {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", err
+		}
+		configDir = filepath.Join(home, ".docker")
+	}
+	b, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return "", "", fmt.Errorf("failed to parse the docker config: %w", err)
+	}
+	// Docker Hub is stored with the legacy key
+	key := registry
+	if registry == "docker.io" {
+		key = "https://index.docker.io/v1/"
+	}
+	if helper := config.CredHelpers[key]; helper != "" {
+		return credentialHelper(helper, key)
+	}
+	if config.CredsStore != "" {
+		username, password, err := credentialHelper(config.CredsStore, key)
+		if err != nil || username != "" {
+			return username, password, err
+		}
+	}
+	for _, k := range []string{key, "https://" + key, "http://" + key} {
+		auth, ok := config.Auths[k]
+		if !ok {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to decode the credentials of %q: %w", k, err)
+			}
+			username, password, _ = strings.Cut(string(decoded), ":")
+			return username, password, nil
+		}
+		return auth.Username, auth.Password, nil
+	}
+	return "", "", nil
+}
+
+// credentialHelper runs `docker-credential-<HELPER> get`.
+// An empty username is returned when the helper has no credentials for the server.
+func credentialHelper(helper, serverURL string) (username, password string, err error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// "credentials not found in native keychain"
+		if strings.Contains(string(out)+stderr.String(), "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("failed to run %v: %w (stderr=%q)", cmd.Args, err, stderr.String())
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", fmt.Errorf("failed to parse the output of %v: %w", cmd.Args, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+// ociLayer is the layer of the disk image stored as an OCI artifact.
+type ociLayer struct {
+	ocispec.Descriptor
+	url    string      // the URL of the blob
+	header http.Header // the authorization for the blob
+}
+
+// resolveOCI resolves the reference to the layer of the disk image.
+// The manifest must have exactly one layer.
+func resolveOCI(ctx context.Context, s string) (*ociLayer, error) {
+	ref, err := parseOCIReference(s)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := ociRegistryHosts()
+	if err != nil {
+		return nil, err
+	}
+	resolver := docker.NewResolver(docker.ResolverOptions{Hosts: hosts})
+	name, desc, err := resolver.Resolve(ctx, ref.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %q: %w", s, err)
+	}
+	if images.IsIndexType(desc.MediaType) {
+		return nil, fmt.Errorf("%q is an image index, specify the digest of a manifest instead (e.g., %q)", s, ociScheme+reference.TrimNamed(ref).String()+"@sha256:...")
+	}
+	if desc.Size > maxManifestSize {
+		return nil, fmt.Errorf("the manifest of %q exceeds %d bytes", s, maxManifestSize)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the manifest of %q: %w", s, err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(io.LimitReader(rc, desc.Size))
+	if err != nil {
+		return nil, err
+	}
+	if actual := desc.Digest.Algorithm().FromBytes(b); actual != desc.Digest {
+		return nil, fmt.Errorf("expected manifest digest %q, got %q", desc.Digest, actual)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse the manifest of %q: %w", s, err)
+	}
+	if len(manifest.Layers) != 1 {
+		return nil, fmt.Errorf("the manifest of %q must have exactly one layer, got %d", s, len(manifest.Layers))
+	}
+	layer := &ociLayer{Descriptor: manifest.Layers[0]}
+	if err := layer.Digest.Validate(); err != nil {
+		return nil, fmt.Errorf("the layer of %q has an invalid digest: %w", s, err)
+	}
+
+	// The blob is downloaded with downloadHTTP to support resuming, so the authorization is taken from the resolver
+	registryHosts, err := hosts(reference.Domain(ref))
+	if err != nil {
+		return nil, err
+	}
+	for _, host := range registryHosts {
+		if !host.Capabilities.Has(docker.HostCapabilityPull) {
+			continue
+		}
+		layer.url = fmt.Sprintf("%s://%s%s/%s/blobs/%s", host.Scheme, host.Host, host.Path, reference.Path(ref), layer.Digest)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, layer.url, http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+		scopedCtx := docker.WithScope(ctx, "repository:"+reference.Path(ref)+":pull")
+		if err := host.Authorizer.Authorize(scopedCtx, req); err != nil {
+			return nil, fmt.Errorf("failed to authorize the download of %q: %w", s, err)
+		}
+		if auth := req.Header.Get("Authorization"); auth != "" {
+			// net/http drops the header when the registry redirects to another host, e.g., a storage service
+			layer.header = http.Header{"Authorization": {auth}}
+		}
+		return layer, nil
+	}
+	return nil, fmt.Errorf("no host to pull %q from", s)
+}
+
+// downloadOCI downloads the layer of the disk image stored as an OCI artifact, and returns its digest.
+// The layer blob is verified by its digest, and by the expected digest too when the algorithm differs.
+func downloadOCI(ctx context.Context, localPath, lastModified, contentType, partialPath, decompressPath, reference, description string, expectedDigest digest.Digest) (digest.Digest, error) {
+	layer, err := resolveOCI(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+	logrus.Debugf("resolved %q to the layer %q (%s, %d bytes)", reference, layer.Digest, layer.MediaType, layer.Size)
+	if expectedDigest != "" && expectedDigest.Algorithm() == layer.Digest.Algorithm() && expectedDigest != layer.Digest {
+		return "", fmt.Errorf("expected digest %q, got %q", expectedDigest, layer.Digest)
+	}
+	if description == "" {
+		description = reference
+	}
+	if err := downloadHTTP(ctx, localPath, lastModified, contentType, partialPath, decompressPath, layer.url, description, layer.Digest, layer.header); err != nil {
+		return "", err
+	}
+	if expectedDigest != "" && expectedDigest.Algorithm() != layer.Digest.Algorithm() {
+		if err := validateLocalFileDigest(localPath, expectedDigest); err != nil {
+			_ = os.RemoveAll(localPath)
+			if decompressPath != "" {
+				_ = os.RemoveAll(decompressPath)
+			}
+			return "", err
+		}
+	}
+	return layer.Digest, nil
+}
+
+// matchOCIDigest resolves the reference and compares the digest of the layer with the digest of the cached data.
+func matchOCIDigest(ctx context.Context, shad, reference string) (matched bool, dCached, dRemote string, err error) {
+	layer, err := resolveOCI(ctx, reference)
+	if err != nil {
+		return false, "<not checked>", "<failed to resolve remote>", err
+	}
+	shadDigest, err := cacheDigestPath(shad, layer.Digest)
+	if err != nil {
+		return false, "<not checked>", layer.Digest.String(), err
+	}
+	dCached = readFile(shadDigest)
+	if dCached == "" {
+		return false, "<not cached>", layer.Digest.String(), nil
+	}
+	return dCached == layer.Digest.String(), dCached, layer.Digest.String(), nil
+}
@@ -0,0 +1,212 @@
+package downloader
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"gotest.tools/v3/assert"
+)
+
+// testRegistry is a stand-in of an OCI registry that requires the bearer token authentication.
+type testRegistry struct {
+	mu        sync.Mutex
+	manifests map[string][]byte        // by tag and by digest
+	blobs     map[digest.Digest][]byte // by digest
+	username  string
+	password  string
+}
+
+func newTestRegistry(t *testing.T, username, password string) (*testRegistry, string) {
+	r := &testRegistry{
+		manifests: make(map[string][]byte),
+		blobs:     make(map[digest.Digest][]byte),
+		username:  username,
+		password:  password,
+	}
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if req.URL.Path == "/token" {
+			if req.Method != http.MethodGet {
+				// The OAuth POST endpoint is not supported, like GCR
+				http.NotFound(w, req)
+				return
+			}
+			if u, p, _ := req.BasicAuth(); u != r.username || p != r.password {
+				http.Error(w, "invalid credentials", http.StatusUnauthorized)
+				return
+			}
+			assert.Equal(t, req.URL.Query().Get("scope"), "repository:images/ubuntu:pull")
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q,service="test",scope="repository:images/ubuntu:pull"`, ts.URL+"/token"))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if ref, ok := strings.CutPrefix(req.URL.Path, "/v2/images/ubuntu/manifests/"); ok {
+			b, ok := r.manifests[ref]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+			_, _ = w.Write(b)
+			return
+		}
+		if d, ok := strings.CutPrefix(req.URL.Path, "/v2/images/ubuntu/blobs/"); ok {
+			b, ok := r.blobs[digest.Digest(d)]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			_, _ = w.Write(b)
+			return
+		}
+		http.NotFound(w, req)
+	}))
+	t.Cleanup(ts.Close)
+	return r, strings.TrimPrefix(ts.URL, "http://")
+}
+
+// push stores the data as the single layer of the artifact, and returns the digests of the layer and the manifest.
+func (r *testRegistry) push(t *testing.T, tag string, layers ...[]byte) (digest.Digest, digest.Digest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	manifest := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+	}
+	for _, layer := range layers {
+		d := digest.FromBytes(layer)
+		r.blobs[d] = layer
+		manifest.Layers = append(manifest.Layers, ocispec.Descriptor{
+			MediaType: "application/vnd.lima.disk.image",
+			Digest:    d,
+			Size:      int64(len(layer)),
+		})
+	}
+	b, err := json.Marshal(manifest)
+	assert.NilError(t, err)
+	md := digest.FromBytes(b)
+	r.manifests[tag] = b
+	r.manifests[md.String()] = b
+	return manifest.Layers[0].Digest, md
+}
+
+func writeDockerConfig(t *testing.T, registry, username, password string) {
+	dir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	config := fmt.Sprintf(`{"auths": {%q: {"auth": %q}}}`, registry, auth)
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600))
+	t.Setenv("DOCKER_CONFIG", dir)
+}
+
+func TestDownloadOCI(t *testing.T) {
+	registry, host := newTestRegistry(t, "user", "pass")
+	writeDockerConfig(t, host, "user", "pass")
+	layerDigest, manifestDigest := registry.push(t, "24.04", []byte("disk image v1"))
+	reference := "oci://" + host + "/images/ubuntu:24.04"
+
+	t.Run("without cache", func(t *testing.T) {
+		localPath := filepath.Join(t.TempDir(), "disk")
+		r, err := Download(context.Background(), localPath, "oci://"+host+"/images/ubuntu@"+manifestDigest.String())
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusDownloaded)
+		b, err := os.ReadFile(localPath)
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), "disk image v1")
+	})
+
+	t.Run("with cache", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		localPath := filepath.Join(t.TempDir(), "disk")
+		r, err := Download(context.Background(), localPath, reference, WithCacheDir(cacheDir))
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusDownloaded)
+		assert.Equal(t, r.URL, reference)
+		// The digest of the layer is recorded even without the expected digest
+		cachedDigest, err := os.ReadFile(filepath.Join(filepath.Dir(r.CachePath), "sha256.digest"))
+		assert.NilError(t, err)
+		assert.Equal(t, string(cachedDigest), layerDigest.String())
+
+		r, err = Download(context.Background(), "", reference, WithCacheDir(cacheDir))
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusUsedCache)
+
+		r, err = Download(context.Background(), "", reference, WithCacheDir(cacheDir), WithExpectedDigest(layerDigest))
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusUsedCache)
+
+		// The tag is updated
+		registry.push(t, "24.04", []byte("disk image v2"))
+		r, err = Download(context.Background(), "", reference, WithCacheDir(cacheDir))
+		assert.NilError(t, err)
+		assert.Equal(t, r.Status, StatusDownloaded)
+		b, err := os.ReadFile(r.CachePath)
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), "disk image v2")
+	})
+
+	t.Run("digest mismatch", func(t *testing.T) {
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "disk"), reference,
+			WithExpectedDigest(digest.FromString("another disk image")))
+		assert.ErrorContains(t, err, "expected digest")
+	})
+
+	t.Run("multiple layers", func(t *testing.T) {
+		registry.push(t, "multi", []byte("layer 1"), []byte("layer 2"))
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "disk"), "oci://"+host+"/images/ubuntu:multi")
+		assert.ErrorContains(t, err, "must have exactly one layer, got 2")
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		writeDockerConfig(t, host, "user", "wrong")
+		_, err := Download(context.Background(), filepath.Join(t.TempDir(), "disk"), reference)
+		assert.ErrorContains(t, err, "failed to authorize")
+	})
+}
+
+func TestParseOCIReference(t *testing.T) {
+	d := digest.FromString("manifest")
+	tests := []struct {
+		reference string
+		expected  string
+		err       string
+	}{
+		{"oci://ghcr.io/lima-vm/images/ubuntu:24.04", "ghcr.io/lima-vm/images/ubuntu:24.04", ""},
+		{"oci://localhost:5000/ubuntu", "localhost:5000/ubuntu:latest", ""},
+		{"oci://docker.io/ubuntu:24.04", "docker.io/library/ubuntu:24.04", ""},
+		{"oci://ghcr.io/ubuntu:24.04@" + d.String(), "ghcr.io/ubuntu@" + d.String(), ""},
+		{"oci://ghcr.io/ubuntu@" + d.String(), "ghcr.io/ubuntu@" + d.String(), ""},
+		{"oci://ghcr.io", "", "must be"},
+		{"oci://ghcr.io/Ubuntu", "", "invalid OCI reference"},
+		{"oci://ghcr.io/ubuntu:", "", "invalid OCI reference"},
+		{"oci://ghcr.io/ubuntu@sha256:foo", "", "invalid OCI reference"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.reference, func(t *testing.T) {
+			ref, err := parseOCIReference(tc.reference)
+			if tc.err != "" {
+				assert.ErrorContains(t, err, tc.err)
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, ref.String(), tc.expected)
+		})
+	}
+}
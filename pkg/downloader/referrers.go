@@ -0,0 +1,73 @@
+package downloader
+
+import (
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/store"
+	"github.com/lima-vm/lima/pkg/templatestore"
+)
+
+// templateStoreTemplates is a variable for testing.
+var templateStoreTemplates = templatestore.Templates
+
+// CacheReferrers returns the names of the instances and the templates (as "template://NAME")
+// that refer to each cache key.
+func CacheReferrers() (map[string][]string, error) {
+	referrers := make(map[string][]string)
+
+	// Collect locations from instances
+	instances, err := store.Instances()
+	if err != nil {
+		return nil, err
+	}
+	for _, instanceName := range instances {
+		instance, err := store.Inspect(instanceName)
+		if err != nil {
+			return nil, err
+		}
+		for k := range FilesByCacheKey(instance.Config) {
+			referrers[k] = append(referrers[k], instanceName)
+		}
+	}
+
+	// Collect locations from templates
+	templates, err := templateStoreTemplates()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range templates {
+		b, err := templatestore.Read(t.Name)
+		if err != nil {
+			return nil, err
+		}
+		y, err := limayaml.Load(b, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		for k := range FilesByCacheKey(y) {
+			referrers[k] = append(referrers[k], "template://"+t.Name)
+		}
+	}
+	return referrers, nil
+}
+
+// FilesByCacheKey returns the images, kernels, initrds, containerd archives, and firmware images of the LimaYAML,
+// by their cache keys.
+func FilesByCacheKey(y *limayaml.LimaYAML) map[string]limayaml.File {
+	locations := make(map[string]limayaml.File)
+	for _, f := range y.Images {
+		locations[CacheKey(f.Location)] = f.File
+		if f.Kernel != nil {
+			locations[CacheKey(f.Kernel.Location)] = f.Kernel.File
+		}
+		if f.Initrd != nil {
+			locations[CacheKey(f.Initrd.Location)] = *f.Initrd
+		}
+	}
+	for _, f := range y.Containerd.Archives {
+		locations[CacheKey(f.Location)] = f
+	}
+	for _, f := range y.Firmware.Images {
+		locations[CacheKey(f.Location)] = f.File
+	}
+	return locations
+}
@@ -15,17 +15,23 @@ import (
 var ErrSkipped = errors.New("skipped to download")
 
 // DownloadFile downloads a file to the cache, optionally copying it to the destination. Returns path in cache.
-func DownloadFile(ctx context.Context, dest string, f limayaml.File, decompress bool, description string, expectedArch limayaml.Arch) (string, error) {
+// The signature of the checksum file of `digest: "sha256sums:URL"` is verified with sig.
+func DownloadFile(ctx context.Context, dest string, f limayaml.File, decompress bool, description string, expectedArch limayaml.Arch, sig limayaml.ChecksumSignature) (string, error) {
 	if f.Arch != expectedArch {
 		return "", fmt.Errorf("%w: %q: unsupported arch: %q", ErrSkipped, f.Location, f.Arch)
 	}
 	fields := logrus.Fields{"location": f.Location, "arch": f.Arch, "digest": f.Digest}
+	if len(f.Mirrors) > 0 {
+		fields["mirrors"] = f.Mirrors
+	}
 	logrus.WithFields(fields).Infof("Attempting to download %s", description)
 	res, err := downloader.Download(ctx, dest, f.Location,
 		downloader.WithCache(),
 		downloader.WithDecompress(decompress),
 		downloader.WithDescription(fmt.Sprintf("%s (%s)", description, path.Base(f.Location))),
 		downloader.WithExpectedDigest(f.Digest),
+		downloader.WithMirrors(f.Mirrors...),
+		downloader.WithChecksumSignature(checksumSignature(sig)),
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to download %q: %w", f.Location, err)
@@ -33,7 +39,7 @@ func DownloadFile(ctx context.Context, dest string, f limayaml.File, decompress
 	logrus.Debugf("res.ValidatedDigest=%v", res.ValidatedDigest)
 	switch res.Status {
 	case downloader.StatusDownloaded:
-		logrus.Infof("Downloaded %s from %q", description, f.Location)
+		logrus.Infof("Downloaded %s from %q", description, res.URL)
 	case downloader.StatusUsedCache:
 		logrus.Infof("Using cache %q", res.CachePath)
 	default:
@@ -42,6 +48,24 @@ func DownloadFile(ctx context.Context, dest string, f limayaml.File, decompress
 	return res.CachePath, nil
 }
 
+func checksumSignature(sig limayaml.ChecksumSignature) *downloader.ChecksumSignature {
+	if len(sig.PublicKeys) == 0 {
+		return nil
+	}
+	res := &downloader.ChecksumSignature{
+		Type:            limayaml.SignatureTypeGPG,
+		PublicKeys:      sig.PublicKeys,
+		SignatureSuffix: ".gpg",
+	}
+	if sig.Type != nil {
+		res.Type = *sig.Type
+	}
+	if sig.SignatureSuffix != nil {
+		res.SignatureSuffix = *sig.SignatureSuffix
+	}
+	return res
+}
+
 // CachedFile checks if a file is in the cache, validating the digest if it is available. Returns path in cache.
 func CachedFile(f limayaml.File) (string, error) {
 	res, err := downloader.Cached(f.Location,
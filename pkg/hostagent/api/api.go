@@ -1,5 +1,18 @@
 package api
 
+import "time"
+
 type Info struct {
 	SSHLocalPort int `json:"sshLocalPort,omitempty"`
 }
+
+// DNSQuery is an entry of the query log of the DNS server of the hostagent.
+type DNSQuery struct {
+	Time    time.Time     `json:"time"`
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Rcode   string        `json:"rcode"`
+	Answers []string      `json:"answers,omitempty"`
+	Latency time.Duration `json:"latency"`
+	Cached  bool          `json:"cached,omitempty"`
+}
@@ -16,6 +16,7 @@ import (
 type HostAgentClient interface {
 	HTTPClient() *http.Client
 	Info(context.Context) (*api.Info, error)
+	DNSQueries(context.Context) ([]api.DNSQuery, error)
 }
 
 // NewHostAgentClient creates a client.
@@ -62,3 +63,18 @@ func (c *client) Info(ctx context.Context) (*api.Info, error) {
 	}
 	return &info, nil
 }
+
+func (c *client) DNSQueries(ctx context.Context) ([]api.DNSQuery, error) {
+	u := fmt.Sprintf("http://%s/%s/dns/queries", c.dummyHost, c.version)
+	resp, err := httpclientutil.Get(ctx, c.HTTPClient(), u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var queries []api.DNSQuery
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&queries); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
@@ -50,6 +50,29 @@ func (b *Backend) GetInfo(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(m)
 }
 
+// GetDNSQueries is the handler for GET /v1/dns/queries.
+func (b *Backend) GetDNSQueries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	queries, err := b.Agent.DNSQueries(r.Context())
+	if err != nil {
+		b.onError(w, err, http.StatusNotFound)
+		return
+	}
+	m, err := json.Marshal(queries)
+	if err != nil {
+		b.onError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(m)
+}
+
 func AddRoutes(r *http.ServeMux, b *Backend) {
 	r.Handle("/v1/info", http.HandlerFunc(b.GetInfo))
+	r.Handle("/v1/dns/queries", http.HandlerFunc(b.GetDNSQueries))
 }
@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// maxCacheTTL caps the TTL of the cached replies.
+	maxCacheTTL = time.Hour
+	// defaultNegativeTTL is the TTL of a negative reply without a SOA record, e.g., NODATA from the system resolver.
+	defaultNegativeTTL = 5 * time.Second
+	// maxNegativeTTL caps the TTL of the cached negative replies (RFC 2308 section 5).
+	maxNegativeTTL = 5 * time.Minute
+)
+
+type cacheKey struct {
+	name   string // lower-case
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	reply   *dns.Msg
+	stored  time.Time
+	expires time.Time
+}
+
+// cache is an LRU cache of the replies, keyed by the question.
+// The TTLs of the replies are respected, and the negative replies (NXDOMAIN and NODATA) are cached too.
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*list.Element
+	lru     *list.List // the most recently used entry is at the front
+	now     func() time.Time
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		size:    size,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+		now:     time.Now,
+	}
+}
+
+func newCacheKey(req *dns.Msg) (cacheKey, bool) {
+	if len(req.Question) != 1 {
+		return cacheKey{}, false
+	}
+	q := req.Question[0]
+	return cacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}, true
+}
+
+// get returns a copy of the cached reply for the request, with the TTLs decremented by the time spent in the cache.
+func (c *cache) get(req *dns.Msg) *dns.Msg {
+	key, ok := newCacheKey(req)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*cacheEntry)
+	now := c.now()
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	reply := entry.reply.Copy()
+	reply.Id = req.Id
+	reply.Question = req.Question
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dns.RR{reply.Answer, reply.Ns, reply.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				// The TTL field of OPT is not a TTL
+				continue
+			}
+			hdr.Ttl -= min(hdr.Ttl, elapsed)
+		}
+	}
+	return reply
+}
+
+// put caches the reply when it is cacheable, evicting the least recently used entry when the cache is full.
+func (c *cache) put(req, reply *dns.Msg) {
+	key, ok := newCacheKey(req)
+	if !ok {
+		return
+	}
+	ttl, ok := cacheTTL(reply)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	entry := &cacheEntry{key: key, reply: reply.Copy(), stored: now, expires: now.Add(ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	for c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+}
+
+// cacheTTL returns how long the reply can be cached, or false if it cannot be cached.
+func cacheTTL(reply *dns.Msg) (time.Duration, bool) {
+	if reply.Truncated {
+		return 0, false
+	}
+	switch {
+	case reply.Rcode == dns.RcodeSuccess && len(reply.Answer) > 0:
+		ttl := reply.Answer[0].Header().Ttl
+		for _, rr := range reply.Answer[1:] {
+			ttl = min(ttl, rr.Header().Ttl)
+		}
+		if ttl == 0 {
+			return 0, false
+		}
+		return min(time.Duration(ttl)*time.Second, maxCacheTTL), true
+	case reply.Rcode == dns.RcodeSuccess || reply.Rcode == dns.RcodeNameError:
+		// RFC 2308 section 5: the TTL of a negative reply is the minimum of the TTL of the SOA and its MINIMUM field
+		for _, rr := range reply.Ns {
+			if soa, ok := rr.(*dns.SOA); ok {
+				ttl := min(soa.Hdr.Ttl, soa.Minttl)
+				if ttl == 0 {
+					return 0, false
+				}
+				return min(time.Duration(ttl)*time.Second, maxNegativeTTL), true
+			}
+		}
+		return defaultNegativeTTL, true
+	default:
+		// SERVFAIL, REFUSED, etc. are not cached
+		return 0, false
+	}
+}
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"gotest.tools/v3/assert"
+)
+
+func newTestReply(name string, rcode int, answerTTL uint32) (*dns.Msg, *dns.Msg) {
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	reply := new(dns.Msg)
+	reply.SetRcode(req, rcode)
+	if answerTTL > 0 {
+		reply.Answer = append(reply.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: answerTTL},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+	}
+	return req, reply
+}
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c := newCache(2)
+	c.now = func() time.Time { return now }
+
+	req, reply := newTestReply("example.com.", dns.RcodeSuccess, 60)
+	c.put(req, reply)
+
+	// The TTL is decremented, and the ID is the one of the request
+	now = now.Add(10 * time.Second)
+	req.Id = 42
+	cached := c.get(req)
+	assert.Assert(t, cached != nil)
+	assert.Equal(t, cached.Id, uint16(42))
+	assert.Equal(t, cached.Answer[0].Header().Ttl, uint32(50))
+	// The cached reply is not modified
+	assert.Equal(t, c.get(req).Answer[0].Header().Ttl, uint32(50))
+
+	// The names are case-insensitive
+	upper, _ := newTestReply("EXAMPLE.COM.", dns.RcodeSuccess, 0)
+	assert.Assert(t, c.get(upper) != nil)
+
+	// Expired
+	now = now.Add(50 * time.Second)
+	assert.Assert(t, c.get(req) == nil)
+
+	// The least recently used entry is evicted
+	for _, name := range []string{"a.example.com.", "b.example.com.", "c.example.com."} {
+		req, reply := newTestReply(name, dns.RcodeSuccess, 60)
+		c.put(req, reply)
+	}
+	a, _ := newTestReply("a.example.com.", dns.RcodeSuccess, 0)
+	assert.Assert(t, c.get(a) == nil)
+	assert.Equal(t, c.lru.Len(), 2)
+}
+
+func TestCacheTTL(t *testing.T) {
+	_, reply := newTestReply("example.com.", dns.RcodeSuccess, 60)
+	reply.Answer = append(reply.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+		A:   net.ParseIP("192.0.2.2"),
+	})
+	ttl, ok := cacheTTL(reply)
+	assert.Assert(t, ok)
+	assert.Equal(t, ttl, 30*time.Second)
+
+	_, reply = newTestReply("example.com.", dns.RcodeSuccess, 86400)
+	ttl, ok = cacheTTL(reply)
+	assert.Assert(t, ok)
+	assert.Equal(t, ttl, maxCacheTTL)
+
+	// Negative replies use the SOA (RFC 2308)
+	_, reply = newTestReply("nonexistent.example.com.", dns.RcodeNameError, 0)
+	reply.Ns = append(reply.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Minttl: 120,
+	})
+	ttl, ok = cacheTTL(reply)
+	assert.Assert(t, ok)
+	assert.Equal(t, ttl, 120*time.Second)
+
+	// NODATA without a SOA
+	_, reply = newTestReply("example.com.", dns.RcodeSuccess, 0)
+	ttl, ok = cacheTTL(reply)
+	assert.Assert(t, ok)
+	assert.Equal(t, ttl, defaultNegativeTTL)
+
+	_, reply = newTestReply("example.com.", dns.RcodeServerFailure, 0)
+	_, ok = cacheTTL(reply)
+	assert.Assert(t, !ok)
+
+	_, reply = newTestReply("example.com.", dns.RcodeSuccess, 60)
+	reply.Truncated = true
+	_, ok = cacheTTL(reply)
+	assert.Assert(t, !ok)
+}
+
+func TestServeDNSCache(t *testing.T) {
+	var count atomic.Int32
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		count.Add(1)
+		testUpstreamHandler("txt")(w, req)
+	})}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+	})
+
+	queryLog := NewQueryLog(10)
+	h, err := NewHandler(HandlerOptions{
+		Domains:   map[string][]string{"example.com": {pc.LocalAddr().String()}},
+		CacheSize: 10,
+		QueryLog:  queryLog,
+	})
+	assert.NilError(t, err)
+	for range 3 {
+		reply := query(t, h, "example.com", dns.TypeTXT)
+		assert.DeepEqual(t, reply.Answer[0].(*dns.TXT).Txt, []string{"txt"})
+	}
+	assert.Equal(t, count.Load(), int32(1))
+
+	entries := queryLog.Entries()
+	assert.Equal(t, len(entries), 3)
+	assert.Equal(t, entries[0].Name, "example.com.")
+	assert.Equal(t, entries[0].Type, "TXT")
+	assert.Equal(t, entries[0].Rcode, "NOERROR")
+	assert.DeepEqual(t, entries[0].Answers, []string{`TXT "txt"`})
+	assert.Assert(t, !entries[0].Cached)
+	assert.Assert(t, entries[1].Cached)
+}
+
+func TestQueryLog(t *testing.T) {
+	l := NewQueryLog(3)
+	assert.Equal(t, len(l.Entries()), 0)
+	for _, name := range []string{"a.", "b.", "c.", "d."} {
+		req, reply := newTestReply(name, dns.RcodeSuccess, 60)
+		l.add(newDNSQuery(req, reply, time.Now(), false))
+	}
+	entries := l.Entries()
+	assert.Equal(t, len(entries), 3)
+	assert.Equal(t, entries[0].Name, "b.")
+	assert.Equal(t, entries[2].Name, "d.")
+	assert.DeepEqual(t, entries[2].Answers, []string{"A 192.0.2.1"})
+}
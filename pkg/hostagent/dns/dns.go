@@ -3,9 +3,12 @@
 package dns
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"net"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -31,10 +34,25 @@ const (
 )
 
 type HandlerOptions struct {
-	IPv6            bool
-	StaticHosts     map[string]string
+	IPv6 bool
+	// StaticHosts maps the names to IP addresses or to other names.
+	// A name may be a pattern: "*.example.com" matches the subdomains of example.com,
+	// and ".example.com" matches example.com and its subdomains. The most specific entry wins.
+	StaticHosts map[string]string
+	// StaticRecords are served authoritatively: the SRV, TXT, and MX queries for their names are never forwarded.
+	StaticRecords   []StaticRecord
 	UpstreamServers []string
-	TruncateReply   bool
+	// Upstreams are the encrypted upstreams (see parseUpstream). When set, the queries that are not answered
+	// from StaticHosts are only forwarded to the Upstreams, never to the system resolver or UpstreamServers.
+	Upstreams []string
+	// Domains maps the domains to the servers (see parseServer) that the queries for the domain and its subdomains
+	// are forwarded to, instead of the system resolver, UpstreamServers, or Upstreams. The most specific domain wins.
+	Domains map[string][]string
+	// CacheSize is the maximum number of the cached replies. 0 disables the cache.
+	CacheSize int
+	// QueryLog records the queries, when non-nil.
+	QueryLog      *QueryLog
+	TruncateReply bool
 }
 
 type ServerOptions struct {
@@ -48,9 +66,110 @@ type Handler struct {
 	truncate     bool
 	clientConfig *dns.ClientConfig
 	clients      []*dns.Client
+	upstreams    []upstream
+	domains      []domainRoute // sorted from the most specific one
 	ipv6         bool
+	cache        *cache // nil when disabled
+	queryLog     *QueryLog
 	cnameToHost  map[string]string
 	hostToIP     map[string]net.IP
+	records      map[string][]dns.RR // key: canonical name
+	patterns     []hostPattern       // sorted from the most specific one
+}
+
+// domainRoute is an entry of HandlerOptions.Domains.
+type domainRoute struct {
+	domain    string // canonical name, e.g., "corp.example.com."
+	upstreams []upstream
+}
+
+// upstreamsFor returns the upstreams of the most specific domain of the name, or the default upstreams.
+// The system resolver is used when the result is empty.
+func (h *Handler) upstreamsFor(name string) []upstream {
+	for _, r := range h.domains {
+		if name == r.domain || strings.HasSuffix(name, "."+r.domain) {
+			return r.upstreams
+		}
+	}
+	return h.upstreams
+}
+
+// hostPattern is a wildcard ("*.example.com") or a suffix (".example.com") entry of StaticHosts.
+type hostPattern struct {
+	domain string // canonical name, e.g., "example.com."
+	apex   bool   // true for the suffix entries, that also match the domain itself
+	ip     net.IP
+	cname  string
+}
+
+// parseHostPattern returns the pattern for "*.DOMAIN" and ".DOMAIN", or false for the other names.
+func parseHostPattern(host string) (hostPattern, bool) {
+	if domain, ok := strings.CutPrefix(host, "*."); ok {
+		return hostPattern{domain: dns.CanonicalName(domain)}, true
+	}
+	if domain, ok := strings.CutPrefix(host, "."); ok && domain != "" {
+		return hostPattern{domain: dns.CanonicalName(domain), apex: true}, true
+	}
+	return hostPattern{}, false
+}
+
+func (p *hostPattern) match(name string) bool {
+	return strings.HasSuffix(name, "."+p.domain) || (p.apex && name == p.domain)
+}
+
+// compareHostPatterns sorts the patterns with the most labels first.
+// A wildcard is more specific than a suffix of the same domain, as it does not match the domain itself.
+func compareHostPatterns(a, b hostPattern) int {
+	if c := cmp.Compare(dns.CountLabel(b.domain), dns.CountLabel(a.domain)); c != 0 {
+		return c
+	}
+	if a.apex != b.apex {
+		if a.apex {
+			return 1
+		}
+		return -1
+	}
+	return strings.Compare(a.domain, b.domain)
+}
+
+// matchPattern returns the most specific pattern that matches the name, or nil.
+func (h *Handler) matchPattern(name string) *hostPattern {
+	for i := range h.patterns {
+		if h.patterns[i].match(name) {
+			return &h.patterns[i]
+		}
+	}
+	return nil
+}
+
+// staticIP returns the IP address of the name defined by StaticHosts.
+// The exact entries take precedence over the patterns.
+func (h *Handler) staticIP(name string) (net.IP, bool) {
+	if ip, ok := h.hostToIP[name]; ok {
+		return ip, true
+	}
+	if _, ok := h.cnameToHost[name]; ok {
+		return nil, false
+	}
+	if p := h.matchPattern(name); p != nil && p.ip != nil {
+		return p.ip, true
+	}
+	return nil, false
+}
+
+// staticCNAME returns the alias target of the name defined by StaticHosts.
+// The exact entries take precedence over the patterns.
+func (h *Handler) staticCNAME(name string) (string, bool) {
+	if cname, ok := h.cnameToHost[name]; ok {
+		return cname, true
+	}
+	if _, ok := h.hostToIP[name]; ok {
+		return "", false
+	}
+	if p := h.matchPattern(name); p != nil && p.cname != "" {
+		return p.cname, true
+	}
+	return "", false
 }
 
 type Server struct {
@@ -84,9 +203,9 @@ func (h *Handler) lookupCnameToHost(cname string) string {
 		if seen[cname] {
 			break
 		}
-		if _, ok := h.cnameToHost[cname]; ok {
+		if target, ok := h.staticCNAME(cname); ok {
 			seen[cname] = true
-			cname = h.cnameToHost[cname]
+			cname = target
 			continue
 		}
 		break
@@ -94,10 +213,95 @@ func (h *Handler) lookupCnameToHost(cname string) string {
 	return cname
 }
 
+// isStatic returns true when the name is defined by StaticHosts, either with an IP address, or as an alias.
+func (h *Handler) isStatic(name string) bool {
+	cname := h.lookupCnameToHost(name)
+	_, ok := h.staticIP(cname)
+	return ok || cname != name
+}
+
+// lookupIP looks up the addresses of the host with the upstreams of the host, or with the system resolver if there are no upstreams.
+func (h *Handler) lookupIP(host string) ([]net.IP, error) {
+	upstreams := h.upstreamsFor(dns.CanonicalName(host))
+	if len(upstreams) == 0 {
+		return net.LookupIP(host)
+	}
+	qtypes := []uint16{dns.TypeA}
+	if h.ipv6 {
+		qtypes = append(qtypes, dns.TypeAAAA)
+	}
+	var addrs []net.IP
+	for _, qtype := range qtypes {
+		req := new(dns.Msg)
+		req.SetQuestion(dns.Fqdn(host), qtype)
+		reply, err := exchangeUpstreams(upstreams, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range reply.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, rr.A)
+			case *dns.AAAA:
+				addrs = append(addrs, rr.AAAA)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %q", host)
+	}
+	return addrs, nil
+}
+
+// exchangeUpstreams sends the query to the upstreams in order, and returns the first reply.
+func exchangeUpstreams(upstreams []upstream, req *dns.Msg) (*dns.Msg, error) {
+	var err error
+	for _, u := range upstreams {
+		ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+		var reply *dns.Msg
+		reply, err = u.exchange(ctx, req)
+		cancel()
+		if err == nil {
+			return reply, nil
+		}
+		logrus.WithError(err).Debugf("failed to perform a query with upstream [%v]", u)
+	}
+	return nil, fmt.Errorf("all the upstreams failed, the last error: %w", err)
+}
+
 func NewHandler(opts HandlerOptions) (dns.Handler, error) {
 	var cc *dns.ClientConfig
 	var err error
-	if len(opts.UpstreamServers) == 0 {
+	var upstreams []upstream
+	for _, s := range opts.Upstreams {
+		u, err := parseUpstream(s)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, u)
+	}
+	var domains []domainRoute
+	for domain, servers := range opts.Domains {
+		r := domainRoute{domain: dns.CanonicalName(domain)}
+		for _, s := range servers {
+			u, err := parseServer(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid server for domain %q: %w", domain, err)
+			}
+			r.upstreams = append(r.upstreams, u)
+		}
+		domains = append(domains, r)
+	}
+	slices.SortFunc(domains, func(a, b domainRoute) int {
+		if c := cmp.Compare(dns.CountLabel(b.domain), dns.CountLabel(a.domain)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.domain, b.domain)
+	})
+	if len(upstreams) > 0 {
+		// No fallback, so that the queries never leak to an unencrypted DNS server
+		cc = &dns.ClientConfig{}
+	} else if len(opts.UpstreamServers) == 0 {
 		if runtime.GOOS != "windows" {
 			cc, err = dns.ClientConfigFromFile("/etc/resolv.conf")
 			if err != nil {
@@ -131,11 +335,24 @@ func NewHandler(opts HandlerOptions) (dns.Handler, error) {
 		truncate:     opts.TruncateReply,
 		clientConfig: cc,
 		clients:      clients,
+		upstreams:    upstreams,
+		domains:      domains,
+		queryLog:     opts.QueryLog,
 		ipv6:         opts.IPv6,
 		cnameToHost:  make(map[string]string),
 		hostToIP:     make(map[string]net.IP),
+		records:      make(map[string][]dns.RR),
 	}
 	for host, address := range opts.StaticHosts {
+		if p, ok := parseHostPattern(host); ok {
+			if ip := net.ParseIP(address); ip != nil {
+				p.ip = ip
+			} else {
+				p.cname = dns.CanonicalName(address)
+			}
+			h.patterns = append(h.patterns, p)
+			continue
+		}
 		cname := dns.CanonicalName(host)
 		if ip := net.ParseIP(address); ip != nil {
 			h.hostToIP[cname] = ip
@@ -143,6 +360,18 @@ func NewHandler(opts HandlerOptions) (dns.Handler, error) {
 			h.cnameToHost[cname] = dns.CanonicalName(address)
 		}
 	}
+	slices.SortFunc(h.patterns, compareHostPatterns)
+	for _, r := range opts.StaticRecords {
+		rr, err := r.rr()
+		if err != nil {
+			return nil, err
+		}
+		name := rr.Header().Name
+		h.records[name] = append(h.records[name], rr)
+	}
+	if opts.CacheSize > 0 {
+		h.cache = newCache(opts.CacheSize)
+	}
 	return h, nil
 }
 
@@ -162,6 +391,23 @@ func (h *Handler) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
 			Ttl:    5,
 		}
 		qtype := q.Qtype
+		if rrs, ok := h.staticRecords(q); ok {
+			for _, rr := range rrs {
+				rr = dns.Copy(rr)
+				rr.Header().Name = q.Name
+				reply.Answer = append(reply.Answer, rr)
+			}
+			reply.Authoritative = true
+			handled = true
+			continue
+		}
+		if len(h.upstreamsFor(dns.CanonicalName(q.Name))) > 0 && !(qtype == dns.TypeAAAA && !h.ipv6) &&
+			!(h.isStatic(dns.CanonicalName(q.Name)) && (qtype == dns.TypeA || qtype == dns.TypeAAAA || qtype == dns.TypeCNAME)) {
+			// Forward the query as is, as the system resolver used below does not know the upstreams.
+			// handleDefault picks the upstreams of the first question.
+			h.handleDefault(w, req)
+			return
+		}
 		switch q.Qtype {
 		case dns.TypeAAAA:
 			if !h.ipv6 {
@@ -182,11 +428,11 @@ func (h *Handler) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
 		case dns.TypeA:
 			var err error
 			var addrs []net.IP
-			cname := h.lookupCnameToHost(q.Name)
-			if _, ok := h.hostToIP[cname]; ok {
-				addrs = []net.IP{h.hostToIP[cname]}
+			cname := h.lookupCnameToHost(dns.CanonicalName(q.Name))
+			if ip, ok := h.staticIP(cname); ok {
+				addrs = []net.IP{ip}
 			} else {
-				addrs, err = net.LookupIP(cname)
+				addrs, err = h.lookupIP(cname)
 				if err != nil {
 					logrus.WithError(err).Debug("handleQuery lookup IP failed")
 					continue
@@ -214,16 +460,16 @@ func (h *Handler) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
 				handled = true
 			}
 		case dns.TypeCNAME:
-			cname := h.lookupCnameToHost(q.Name)
+			cname := h.lookupCnameToHost(dns.CanonicalName(q.Name))
 			var err error
-			if _, ok := h.hostToIP[cname]; !ok {
+			if _, ok := h.staticIP(cname); !ok && len(h.upstreamsFor(cname)) == 0 {
 				cname, err = net.LookupCNAME(cname)
 				if err != nil {
 					logrus.WithError(err).Debug("handleQuery lookup CNAME failed")
 					continue
 				}
 			}
-			if cname != "" && cname != q.Name {
+			if cname != "" && cname != dns.CanonicalName(q.Name) {
 				hdr.Rrtype = dns.TypeCNAME
 				a := &dns.CNAME{
 					Hdr:    hdr,
@@ -317,6 +563,25 @@ func (h *Handler) handleQuery(w dns.ResponseWriter, req *dns.Msg) {
 
 func (h *Handler) handleDefault(w dns.ResponseWriter, req *dns.Msg) {
 	logrus.Tracef("handleDefault for %v", req)
+	upstreams := h.upstreams
+	if len(req.Question) > 0 {
+		upstreams = h.upstreamsFor(dns.CanonicalName(req.Question[0].Name))
+	}
+	if len(upstreams) > 0 {
+		reply, err := exchangeUpstreams(upstreams, req)
+		if err != nil {
+			logrus.WithError(err).Debugf("handleDefault failed to perform a query with the upstreams")
+			reply = new(dns.Msg)
+			reply.SetRcode(req, dns.RcodeServerFailure)
+		}
+		if h.truncate {
+			reply.Truncate(truncateSize)
+		}
+		if err := w.WriteMsg(reply); err != nil {
+			logrus.WithError(err).Debugf("handleDefault failed writing DNS reply")
+		}
+		return
+	}
 	for _, client := range h.clients {
 		for _, srv := range h.clientConfig.Servers {
 			addr := net.JoinHostPort(srv, h.clientConfig.Port)
@@ -346,12 +611,51 @@ func (h *Handler) handleDefault(w dns.ResponseWriter, req *dns.Msg) {
 	}
 }
 
+// recordingResponseWriter records the reply for the cache and the query log.
+type recordingResponseWriter struct {
+	dns.ResponseWriter
+	reply *dns.Msg
+}
+
+func (w *recordingResponseWriter) WriteMsg(msg *dns.Msg) error {
+	w.reply = msg
+	return w.ResponseWriter.WriteMsg(msg)
+}
+
+// cacheable returns false for the AAAA queries when IPv6 is disabled, as the delay of their replies must be kept.
+func (h *Handler) cacheable(req *dns.Msg) bool {
+	return h.cache != nil && !(len(req.Question) == 1 && req.Question[0].Qtype == dns.TypeAAAA && !h.ipv6)
+}
+
 func (h *Handler) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
-	switch req.Opcode {
-	case dns.OpcodeQuery:
-		h.handleQuery(w, req)
-	default:
+	if req.Opcode != dns.OpcodeQuery {
 		h.handleDefault(w, req)
+		return
+	}
+	start := time.Now()
+	cacheable := h.cacheable(req)
+	if cacheable {
+		if reply := h.cache.get(req); reply != nil {
+			defer w.Close()
+			if err := w.WriteMsg(reply); err != nil {
+				logrus.WithError(err).Debugf("ServeDNS failed writing a cached DNS reply")
+			}
+			if h.queryLog != nil {
+				h.queryLog.add(newDNSQuery(req, reply, start, true))
+			}
+			return
+		}
+	}
+	rw := &recordingResponseWriter{ResponseWriter: w}
+	h.handleQuery(rw, req)
+	if rw.reply == nil {
+		return
+	}
+	if cacheable {
+		h.cache.put(req, rw.reply)
+	}
+	if h.queryLog != nil {
+		h.queryLog.add(newDNSQuery(req, rw.reply, start, false))
 	}
 }
 
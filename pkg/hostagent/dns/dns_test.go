@@ -119,6 +119,96 @@ func TestDNSRecords(t *testing.T) {
 	})
 }
 
+func TestHostPatterns(t *testing.T) {
+	h, err := NewHandler(HandlerOptions{
+		StaticHosts: map[string]string{
+			"host.lima.internal": "192.168.5.2",
+			"*.dev.test":         "host.lima.internal",
+			".api.dev.test":      "192.168.5.15",
+			"*.api.dev.test":     "192.168.5.16",
+			"exact.api.dev.test": "192.168.5.17",
+			".test":              "192.168.5.18",
+		},
+	})
+	assert.NilError(t, err)
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "foo.dev.test", expected: "192.168.5.2"},
+		{name: "foo.bar.dev.test", expected: "192.168.5.2"},
+		{name: "FOO.DEV.TEST", expected: "192.168.5.2"},
+		{name: "api.dev.test", expected: "192.168.5.15"},
+		{name: "foo.api.dev.test", expected: "192.168.5.16"},
+		{name: "exact.api.dev.test", expected: "192.168.5.17"},
+		// "*.dev.test" does not match "dev.test"
+		{name: "dev.test", expected: "192.168.5.18"},
+		{name: "test", expected: "192.168.5.18"},
+	}
+	for _, tc := range tests {
+		req := new(dns.Msg)
+		req.SetQuestion(dns.Fqdn(tc.name), dns.TypeA)
+		h.ServeDNS(TestResponseWriter{}, req)
+		assert.Equal(t, len(dnsResult.Answer), 1, tc.name)
+		assert.Equal(t, dnsResult.Answer[0].(*dns.A).A.String(), tc.expected, tc.name)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("foo.dev.test.", dns.TypeCNAME)
+	h.ServeDNS(TestResponseWriter{}, req)
+	assert.Equal(t, len(dnsResult.Answer), 1)
+	assert.Equal(t, dnsResult.Answer[0].(*dns.CNAME).Target, "host.lima.internal.")
+}
+
+func TestStaticRecords(t *testing.T) {
+	h, err := NewHandler(HandlerOptions{
+		StaticHosts: map[string]string{"svc.test": "192.168.5.15"},
+		StaticRecords: []StaticRecord{
+			{Name: "_http._tcp.svc.test", Type: "SRV", Target: "web.svc.test", Port: 8080, Priority: 10, Weight: 5},
+			{Name: "_http._tcp.svc.test", Type: "SRV", Target: "web2.svc.test", Port: 8081, Priority: 20},
+			{Name: "svc.test", Type: "TXT", Text: "v=1"},
+			{Name: "SVC.test.", Type: "MX", Target: "mail.svc.test", Preference: 10},
+		},
+		// The static records must not be forwarded
+		Upstreams: []string{"tls://127.0.0.1:1"},
+	})
+	assert.NilError(t, err)
+
+	req := new(dns.Msg)
+	req.SetQuestion("_http._tcp.svc.test.", dns.TypeSRV)
+	h.ServeDNS(TestResponseWriter{}, req)
+	assert.Assert(t, dnsResult.Authoritative)
+	assert.Equal(t, len(dnsResult.Answer), 2)
+	srv := dnsResult.Answer[0].(*dns.SRV)
+	assert.Equal(t, srv.Target, "web.svc.test.")
+	assert.Equal(t, srv.Port, uint16(8080))
+	assert.Equal(t, srv.Priority, uint16(10))
+	assert.Equal(t, srv.Weight, uint16(5))
+
+	req.SetQuestion("svc.test.", dns.TypeTXT)
+	h.ServeDNS(TestResponseWriter{}, req)
+	assert.Equal(t, len(dnsResult.Answer), 1)
+	assert.DeepEqual(t, dnsResult.Answer[0].(*dns.TXT).Txt, []string{"v=1"})
+
+	req.SetQuestion("Svc.Test.", dns.TypeMX)
+	h.ServeDNS(TestResponseWriter{}, req)
+	assert.Equal(t, len(dnsResult.Answer), 1)
+	assert.Equal(t, dnsResult.Answer[0].Header().Name, "Svc.Test.")
+	assert.Equal(t, dnsResult.Answer[0].(*dns.MX).Mx, "mail.svc.test.")
+
+	// NODATA
+	req.SetQuestion("_http._tcp.svc.test.", dns.TypeTXT)
+	h.ServeDNS(TestResponseWriter{}, req)
+	assert.Equal(t, dnsResult.Rcode, dns.RcodeSuccess)
+	assert.Assert(t, dnsResult.Authoritative)
+	assert.Equal(t, len(dnsResult.Answer), 0)
+
+	// The static hosts are still served
+	req.SetQuestion("svc.test.", dns.TypeA)
+	h.ServeDNS(TestResponseWriter{}, req)
+	assert.Equal(t, dnsResult.Answer[0].(*dns.A).A.String(), "192.168.5.15")
+}
+
 type TestResponseWriter struct{}
 
 // LocalAddr returns the net.Addr of the server
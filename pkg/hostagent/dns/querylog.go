@@ -0,0 +1,63 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/hostagent/api"
+	"github.com/miekg/dns"
+)
+
+// QueryLog records the recent queries in a ring buffer. It is safe for concurrent use.
+type QueryLog struct {
+	mu      sync.Mutex
+	entries []api.DNSQuery
+	next    int
+	full    bool
+}
+
+// NewQueryLog creates a QueryLog that keeps the last size queries.
+func NewQueryLog(size int) *QueryLog {
+	return &QueryLog{entries: make([]api.DNSQuery, size)}
+}
+
+func (l *QueryLog) add(q api.DNSQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == 0 {
+		return
+	}
+	l.entries[l.next] = q
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Entries returns the recorded queries, the oldest one first.
+func (l *QueryLog) Entries() []api.DNSQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]api.DNSQuery{}, l.entries[:l.next]...)
+	}
+	return append(append([]api.DNSQuery{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+func newDNSQuery(req, reply *dns.Msg, start time.Time, cached bool) api.DNSQuery {
+	q := api.DNSQuery{
+		Time:    start,
+		Rcode:   dns.RcodeToString[reply.Rcode],
+		Latency: time.Since(start),
+		Cached:  cached,
+	}
+	if len(req.Question) > 0 {
+		q.Name = req.Question[0].Name
+		q.Type = dns.TypeToString[req.Question[0].Qtype]
+	}
+	for _, rr := range reply.Answer {
+		q.Answers = append(q.Answers, dns.TypeToString[rr.Header().Rrtype]+" "+strings.TrimPrefix(rr.String(), rr.Header().String()))
+	}
+	return q
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// StaticRecord is a static SRV, TXT, or MX record.
+// The fields are the same as limayaml.HostResolverRecord.
+type StaticRecord struct {
+	Name       string
+	Type       string // "SRV", "TXT", or "MX"
+	Target     string // SRV and MX
+	Port       uint16 // SRV
+	Priority   uint16 // SRV
+	Weight     uint16 // SRV
+	Preference uint16 // MX
+	Text       string // TXT
+}
+
+func (r StaticRecord) rr() (dns.RR, error) {
+	hdr := dns.RR_Header{
+		Name:  dns.CanonicalName(r.Name),
+		Class: dns.ClassINET,
+		Ttl:   5,
+	}
+	switch r.Type {
+	case "SRV":
+		hdr.Rrtype = dns.TypeSRV
+		return &dns.SRV{
+			Hdr:      hdr,
+			Target:   dns.Fqdn(r.Target),
+			Port:     r.Port,
+			Priority: r.Priority,
+			Weight:   r.Weight,
+		}, nil
+	case "TXT":
+		hdr.Rrtype = dns.TypeTXT
+		return &dns.TXT{
+			Hdr: hdr,
+			Txt: chunkify(r.Text, 255),
+		}, nil
+	case "MX":
+		hdr.Rrtype = dns.TypeMX
+		return &dns.MX{
+			Hdr:        hdr,
+			Mx:         dns.Fqdn(r.Target),
+			Preference: r.Preference,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q of the static record %q", r.Type, r.Name)
+	}
+}
+
+// staticRecords returns the static records for the SRV, TXT, and MX questions.
+// As the static records are authoritative, the result is empty but true when
+// the name has static records of the other types only (NODATA).
+func (h *Handler) staticRecords(q dns.Question) ([]dns.RR, bool) {
+	switch q.Qtype {
+	case dns.TypeSRV, dns.TypeTXT, dns.TypeMX:
+	default:
+		return nil, false
+	}
+	rrs, ok := h.records[dns.CanonicalName(q.Name)]
+	if !ok {
+		return nil, false
+	}
+	var res []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == q.Qtype {
+			res = append(res, rr)
+		}
+	}
+	return res, true
+}
@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// upstreamTimeout is the timeout of a query to an encrypted upstream, including the connection setup.
+	upstreamTimeout = 5 * time.Second
+	// dohContentType is the media type of DNS-over-HTTPS messages (RFC 8484).
+	dohContentType = "application/dns-message"
+	// maxDoHResponseSize is the maximum size of a DNS message.
+	maxDoHResponseSize = 65535
+)
+
+// upstream is an encrypted DNS server.
+type upstream interface {
+	exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// parseUpstream parses "tls://HOST[:PORT]" (DNS-over-TLS, RFC 7858) and
+// "https://HOST[:PORT]/PATH" (DNS-over-HTTPS, RFC 8484) upstreams.
+// The certificate of the upstream is verified against HOST with the root CAs of the host.
+func parseUpstream(s string) (upstream, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("upstream %q must have a host", s)
+	}
+	switch u.Scheme {
+	case "tls":
+		port := u.Port()
+		if port == "" {
+			port = "853"
+		}
+		return &dotUpstream{
+			addr: net.JoinHostPort(u.Hostname(), port),
+			client: &dns.Client{
+				Net:       "tcp-tls",
+				Timeout:   upstreamTimeout,
+				TLSConfig: &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12},
+			},
+		}, nil
+	case "https":
+		return &dohUpstream{
+			url:    u.String(),
+			client: &http.Client{Timeout: upstreamTimeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("upstream %q must be a \"tls://HOST[:PORT]\" or \"https://HOST[:PORT]/PATH\" URL", s)
+	}
+}
+
+// parseServer parses a plain "IP[:PORT]" DNS server, or an encrypted upstream (see parseUpstream).
+func parseServer(s string) (upstream, error) {
+	if strings.Contains(s, "://") {
+		return parseUpstream(s)
+	}
+	addr := s
+	if ip := net.ParseIP(s); ip != nil {
+		addr = net.JoinHostPort(ip.String(), "53")
+	} else if host, _, err := net.SplitHostPort(s); err != nil || net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("server %q must be an \"IP[:PORT]\" or an encrypted upstream", s)
+	}
+	return &plainUpstream{
+		addr: addr,
+		udp:  &dns.Client{Timeout: upstreamTimeout},
+		tcp:  &dns.Client{Net: "tcp", Timeout: upstreamTimeout},
+	}, nil
+}
+
+// plainUpstream is an unencrypted DNS server, that is queried over UDP, and over TCP when the reply is truncated.
+type plainUpstream struct {
+	addr string
+	udp  *dns.Client
+	tcp  *dns.Client
+}
+
+func (u *plainUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	reply, _, err := u.udp.ExchangeContext(ctx, req, u.addr)
+	if err == nil && reply.Truncated {
+		reply, _, err = u.tcp.ExchangeContext(ctx, req, u.addr)
+	}
+	return reply, err
+}
+
+func (u *plainUpstream) String() string {
+	return u.addr
+}
+
+// dotUpstream is a DNS-over-TLS upstream.
+type dotUpstream struct {
+	addr   string
+	client *dns.Client
+}
+
+func (u *dotUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	reply, _, err := u.client.ExchangeContext(ctx, req, u.addr)
+	return reply, err
+}
+
+func (u *dotUpstream) String() string {
+	return "tls://" + u.addr
+}
+
+// dohUpstream is a DNS-over-HTTPS upstream.
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *dohUpstream) exchange(ctx context.Context, req *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 section 4.1: the ID should be 0 to make the responses cacheable
+	msg := req.Copy()
+	msg.Id = 0
+	b, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", dohContentType)
+	httpReq.Header.Set("Accept", dohContentType)
+	res, err := u.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %q", res.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxDoHResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDoHResponseSize {
+		return nil, errors.New("response is too large")
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, err
+	}
+	reply.Id = req.Id
+	return reply, nil
+}
+
+func (u *dohUpstream) String() string {
+	return u.url
+}
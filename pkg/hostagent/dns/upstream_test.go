@@ -0,0 +1,248 @@
+package dns
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"gotest.tools/v3/assert"
+)
+
+// testUpstreamHandler answers A and TXT queries for any name.
+func testUpstreamHandler(txt string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, req *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(req)
+		for _, q := range req.Question {
+			hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+			switch q.Qtype {
+			case dns.TypeA:
+				reply.Answer = append(reply.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("192.0.2.1")})
+			case dns.TypeTXT:
+				reply.Answer = append(reply.Answer, &dns.TXT{Hdr: hdr, Txt: []string{txt}})
+			}
+		}
+		_ = w.WriteMsg(reply)
+	}
+}
+
+// dohResponseWriter adapts a dns.ResponseWriter to an HTTP response.
+type dohResponseWriter struct {
+	TestResponseWriter
+	w http.ResponseWriter
+}
+
+func (r dohResponseWriter) WriteMsg(msg *dns.Msg) error {
+	b, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	r.w.Header().Set("Content-Type", dohContentType)
+	_, err = r.w.Write(b)
+	return err
+}
+
+func newTestDoHServer(t *testing.T, txt string) *httptest.Server {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/dns-query" || r.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, err := io.ReadAll(r.Body)
+		assert.Check(t, err)
+		req := new(dns.Msg)
+		if err := req.Unpack(b); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		assert.Check(t, req.Id == 0)
+		testUpstreamHandler(txt)(dohResponseWriter{w: w}, req)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTestDoTServer(t *testing.T, tlsConfig *tls.Config, txt string) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	assert.NilError(t, err)
+	srv := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: testUpstreamHandler(txt)}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+	})
+	return ln.Addr().String()
+}
+
+func newTestPlainServer(t *testing.T, txt string) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	srv := &dns.Server{PacketConn: pc, Handler: testUpstreamHandler(txt)}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+	})
+	return pc.LocalAddr().String()
+}
+
+func query(t *testing.T, h dns.Handler, name string, qtype uint16) *dns.Msg {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(name), qtype)
+	h.ServeDNS(TestResponseWriter{}, req)
+	assert.Equal(t, dnsResult.Id, req.Id)
+	return dnsResult
+}
+
+func TestEncryptedUpstreams(t *testing.T) {
+	doh := newTestDoHServer(t, "doh")
+	dot := newTestDoTServer(t, doh.TLS, "dot")
+	roots := doh.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	newHandler := func(t *testing.T, upstreams ...string) *Handler {
+		h, err := NewHandler(HandlerOptions{
+			StaticHosts: map[string]string{
+				"host.lima.internal": "192.168.5.2",
+				"alias.example.com":  "target.example.com",
+			},
+			Upstreams: upstreams,
+		})
+		assert.NilError(t, err)
+		handler := h.(*Handler)
+		for _, u := range handler.upstreams {
+			switch u := u.(type) {
+			case *dotUpstream:
+				u.client.TLSConfig.RootCAs = roots
+			case *dohUpstream:
+				u.client = doh.Client()
+			}
+		}
+		return handler
+	}
+
+	t.Run("DoH", func(t *testing.T) {
+		h := newHandler(t, doh.URL+"/dns-query")
+		reply := query(t, h, "example.com", dns.TypeTXT)
+		assert.Equal(t, reply.Rcode, dns.RcodeSuccess)
+		assert.Equal(t, len(reply.Answer), 1)
+		assert.DeepEqual(t, reply.Answer[0].(*dns.TXT).Txt, []string{"doh"})
+	})
+
+	t.Run("DoT", func(t *testing.T) {
+		// The certificate of httptest is valid for 127.0.0.1
+		h := newHandler(t, "tls://"+dot)
+		reply := query(t, h, "example.com", dns.TypeTXT)
+		assert.Equal(t, len(reply.Answer), 1)
+		assert.DeepEqual(t, reply.Answer[0].(*dns.TXT).Txt, []string{"dot"})
+		reply = query(t, h, "example.com", dns.TypeA)
+		assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "192.0.2.1")
+	})
+
+	t.Run("static hosts", func(t *testing.T) {
+		h := newHandler(t, "tls://"+dot)
+		reply := query(t, h, "host.lima.internal", dns.TypeA)
+		assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "192.168.5.2")
+		// The target of an alias is resolved with the upstreams
+		reply = query(t, h, "alias.example.com", dns.TypeA)
+		assert.Equal(t, reply.Answer[0].Header().Name, "alias.example.com.")
+		assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "192.0.2.1")
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		// The first upstream is not listening
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NilError(t, err)
+		assert.NilError(t, ln.Close())
+		h := newHandler(t, "tls://"+ln.Addr().String(), doh.URL+"/dns-query")
+		reply := query(t, h, "example.com", dns.TypeTXT)
+		assert.DeepEqual(t, reply.Answer[0].(*dns.TXT).Txt, []string{"doh"})
+	})
+
+	t.Run("no fallback", func(t *testing.T) {
+		// The certificate of the upstream is not trusted
+		h, err := NewHandler(HandlerOptions{Upstreams: []string{"tls://" + dot}})
+		assert.NilError(t, err)
+		reply := query(t, h, "example.com", dns.TypeTXT)
+		assert.Equal(t, reply.Rcode, dns.RcodeServerFailure)
+		assert.Equal(t, len(reply.Answer), 0)
+	})
+}
+
+func TestDomains(t *testing.T) {
+	doh := newTestDoHServer(t, "doh")
+	corp := newTestPlainServer(t, "corp")
+	sub := newTestPlainServer(t, "sub")
+
+	h, err := NewHandler(HandlerOptions{
+		StaticHosts: map[string]string{
+			"static.corp.example": "192.168.5.15",
+		},
+		Upstreams: []string{doh.URL + "/dns-query"},
+		Domains: map[string][]string{
+			"corp.example":     {corp},
+			"sub.corp.example": {sub},
+		},
+	})
+	assert.NilError(t, err)
+	handler := h.(*Handler)
+	handler.upstreams[0].(*dohUpstream).client = doh.Client()
+
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "corp.example", expected: "corp"},
+		{name: "api.corp.example", expected: "corp"},
+		{name: "sub.corp.example", expected: "sub"},
+		{name: "api.sub.corp.example", expected: "sub"},
+		{name: "notcorp.example", expected: "doh"},
+		{name: "example.com", expected: "doh"},
+	}
+	for _, tc := range tests {
+		reply := query(t, h, tc.name, dns.TypeTXT)
+		assert.Equal(t, len(reply.Answer), 1, tc.name)
+		assert.DeepEqual(t, reply.Answer[0].(*dns.TXT).Txt, []string{tc.expected})
+	}
+
+	reply := query(t, h, "static.corp.example", dns.TypeA)
+	assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "192.168.5.15")
+
+	// The target of an alias is resolved with the servers of its domain, not with the system resolver
+	h, err = NewHandler(HandlerOptions{
+		StaticHosts: map[string]string{"alias.example.com": "api.corp.invalid"},
+		Domains:     map[string][]string{"corp.invalid": {corp}},
+	})
+	assert.NilError(t, err)
+	reply = query(t, h, "alias.example.com", dns.TypeA)
+	assert.Equal(t, len(reply.Answer), 1)
+	assert.Equal(t, reply.Answer[0].(*dns.A).A.String(), "192.0.2.1")
+
+	_, err = NewHandler(HandlerOptions{Domains: map[string][]string{"corp.example": {"dns.corp.example"}}})
+	assert.ErrorContains(t, err, "must be an \"IP[:PORT]\"")
+}
+
+func TestParseUpstream(t *testing.T) {
+	u, err := parseUpstream("tls://dns.example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, u.String(), "tls://dns.example.com:853")
+	assert.Equal(t, u.(*dotUpstream).client.TLSConfig.ServerName, "dns.example.com")
+
+	u, err = parseUpstream("tls://[2606:4700:4700::1111]:8853")
+	assert.NilError(t, err)
+	assert.Equal(t, u.String(), "tls://[2606:4700:4700::1111]:8853")
+
+	u, err = parseUpstream("https://dns.example.com/dns-query")
+	assert.NilError(t, err)
+	assert.Equal(t, u.String(), "https://dns.example.com/dns-query")
+
+	_, err = parseUpstream("udp://1.1.1.1")
+	assert.ErrorContains(t, err, "must be a")
+	_, err = parseUpstream("tls://")
+	assert.ErrorContains(t, err, "must have a host")
+}
@@ -41,6 +41,9 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// dnsQueryLogSize is the number of the queries kept in the DNS query log.
+const dnsQueryLogSize = 1000
+
 type HostAgent struct {
 	instConfig        *limayaml.LimaYAML
 	sshLocalPort      int
@@ -69,6 +72,8 @@ type HostAgent struct {
 
 	guestAgentAliveCh     chan struct{} // closed on establishing the connection
 	guestAgentAliveChOnce sync.Once
+
+	dnsQueryLog *dns.QueryLog // nil unless hostResolver.queryLog is enabled
 }
 
 type options struct {
@@ -217,6 +222,9 @@ func New(instName string, stdout io.Writer, signalCh chan os.Signal, opts ...Opt
 		virtioPort:        virtioPort,
 		guestAgentAliveCh: make(chan struct{}),
 	}
+	if *inst.Config.HostResolver.QueryLog {
+		a.dnsQueryLog = dns.NewQueryLog(dnsQueryLogSize)
+	}
 	return a, nil
 }
 
@@ -292,13 +300,22 @@ func (a *HostAgent) Run(ctx context.Context) error {
 		hosts["host.lima.internal"] = networks.SlirpGateway
 		hostname := identifierutil.HostnameFromInstName(a.instName) // TODO: support customization
 		hosts[hostname] = networks.SlirpIPAddress
+		var records []dns.StaticRecord
+		for _, r := range a.instConfig.HostResolver.Records {
+			records = append(records, dns.StaticRecord(r))
+		}
 		srvOpts := dns.ServerOptions{
 			UDPPort: a.udpDNSLocalPort,
 			TCPPort: a.tcpDNSLocalPort,
 			Address: "127.0.0.1",
 			HandlerOptions: dns.HandlerOptions{
-				IPv6:        *a.instConfig.HostResolver.IPv6,
-				StaticHosts: hosts,
+				IPv6:          *a.instConfig.HostResolver.IPv6,
+				StaticHosts:   hosts,
+				StaticRecords: records,
+				Upstreams:     a.instConfig.HostResolver.Upstreams,
+				Domains:       a.instConfig.HostResolver.Domains,
+				CacheSize:     *a.instConfig.HostResolver.CacheSize,
+				QueryLog:      a.dnsQueryLog,
 			},
 		}
 		dnsServer, err := dns.Start(srvOpts)
@@ -423,6 +440,14 @@ func (a *HostAgent) Info(_ context.Context) (*hostagentapi.Info, error) {
 	return info, nil
 }
 
+// DNSQueries returns the recent queries to the DNS server of the hostagent, the oldest one first.
+func (a *HostAgent) DNSQueries(_ context.Context) ([]hostagentapi.DNSQuery, error) {
+	if a.dnsQueryLog == nil {
+		return nil, errors.New("the DNS query log is not enabled (hint: set `hostResolver.queryLog` to true)")
+	}
+	return a.dnsQueryLog.Entries(), nil
+}
+
 func (a *HostAgent) startHostAgentRoutines(ctx context.Context) error {
 	if *a.instConfig.Plain {
 		logrus.Info("Running in plain mode. Mounts, port forwarding, containerd, etc. will be ignored. Guest agent will not be running.")
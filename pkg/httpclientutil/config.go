@@ -0,0 +1,192 @@
+package httpclientutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-yaml"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Config is the configuration of the HTTP client for downloading images and templates,
+// loaded from the _config/http.yaml file.
+type Config struct {
+	Proxy ProxyConfig `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	// CACerts are the paths to the PEM files of the CA certificates trusted in addition to the system ones.
+	CACerts []string `yaml:"caCerts,omitempty" json:"caCerts,omitempty"`
+	// Hosts are the credentials by the host name (with the port, if it is not the default one).
+	// The credentials are also read from the netrc file ($NETRC, or ~/.netrc).
+	Hosts map[string]HostConfig `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+}
+
+// ProxyConfig overrides $HTTP_PROXY, $HTTPS_PROXY, and $NO_PROXY.
+type ProxyConfig struct {
+	HTTP    string `yaml:"http,omitempty" json:"http,omitempty"`
+	HTTPS   string `yaml:"https,omitempty" json:"https,omitempty"`
+	NoProxy string `yaml:"noProxy,omitempty" json:"noProxy,omitempty"`
+}
+
+// HostConfig is the credentials for a host. BearerToken takes precedence over Username and Password.
+type HostConfig struct {
+	BearerToken string `yaml:"bearerToken,omitempty" json:"bearerToken,omitempty"`
+	Username    string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password    string `yaml:"password,omitempty" json:"password,omitempty"`
+}
+
+// ConfigFile returns the path of the _config/http.yaml file.
+func ConfigFile() (string, error) {
+	cfgDir, err := dirnames.LimaConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cfgDir, filenames.HTTPConfig), nil
+}
+
+// LoadConfig loads the config file. A missing file is not an error.
+func LoadConfig(cfgFile string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(cfgFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	if err := yaml.UnmarshalWithOptions(b, &cfg, yaml.Strict()); err != nil {
+		return cfg, fmt.Errorf("cannot parse %q: %w", cfgFile, err)
+	}
+	return cfg, nil
+}
+
+var defaultClient struct {
+	sync.Once
+	client *http.Client
+	err    error
+}
+
+// DefaultClient returns the HTTP client configured with the _config/http.yaml file and the netrc file.
+// The client is shared by the downloader and the template locator.
+func DefaultClient() (*http.Client, error) {
+	defaultClient.Do(func() {
+		var cfgFile string
+		cfgFile, defaultClient.err = ConfigFile()
+		if defaultClient.err != nil {
+			return
+		}
+		var cfg Config
+		cfg, defaultClient.err = LoadConfig(cfgFile)
+		if defaultClient.err != nil {
+			return
+		}
+		var netrc []NetrcEntry
+		netrc, defaultClient.err = loadNetrc()
+		if defaultClient.err != nil {
+			return
+		}
+		defaultClient.client, defaultClient.err = NewClient(cfg, netrc)
+		if defaultClient.err != nil {
+			defaultClient.err = fmt.Errorf("invalid HTTP client config %q: %w", cfgFile, defaultClient.err)
+		}
+	})
+	return defaultClient.client, defaultClient.err
+}
+
+// NewClient creates an HTTP client with the config and the netrc entries.
+func NewClient(cfg Config, netrc []NetrcEntry) (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxyConfig := httpproxy.FromEnvironment()
+	if cfg.Proxy.HTTP != "" {
+		proxyConfig.HTTPProxy = cfg.Proxy.HTTP
+	}
+	if cfg.Proxy.HTTPS != "" {
+		proxyConfig.HTTPSProxy = cfg.Proxy.HTTPS
+	}
+	if cfg.Proxy.NoProxy != "" {
+		proxyConfig.NoProxy = cfg.Proxy.NoProxy
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+	tr.Proxy = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+
+	if len(cfg.CACerts) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to load the system CA certificates")
+			pool = x509.NewCertPool()
+		}
+		for _, f := range cfg.CACerts {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("no PEM certificate found in %q", f)
+			}
+		}
+		tr.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	hosts := make(map[string]HostConfig)
+	// The first entry of the machine takes precedence, as in curl
+	for i := len(netrc) - 1; i >= 0; i-- {
+		e := netrc[i]
+		if e.Machine == "" {
+			// The "default" entry is ignored, as the credentials would be sent to any host,
+			// including the hosts specified in untrusted remote templates.
+			continue
+		}
+		hosts[e.Machine] = HostConfig{Username: e.Login, Password: e.Password}
+	}
+	for host, hc := range cfg.Hosts {
+		hosts[host] = hc
+	}
+	var rt http.RoundTripper = tr
+	if len(hosts) > 0 {
+		rt = &authTransport{base: tr, hosts: hosts}
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+// authTransport adds the credentials of the host to the HTTPS requests without the Authorization header.
+// The credentials are not sent over plain HTTP, nor to the hosts without credentials.
+type authTransport struct {
+	base  http.RoundTripper
+	hosts map[string]HostConfig
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || req.Header.Get("Authorization") != "" {
+		return t.base.RoundTrip(req)
+	}
+	hc, ok := t.hosts[req.URL.Host]
+	if !ok {
+		hc, ok = t.hosts[req.URL.Hostname()]
+	}
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	switch {
+	case hc.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(hc.BearerToken))
+	case hc.Username != "" || hc.Password != "":
+		req.SetBasicAuth(hc.Username, hc.Password)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,154 @@
+package httpclientutil
+
+import (
+	"context"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNewClient(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = io.WriteString(w, req.Header.Get("Authorization"))
+	})
+	ts := httptest.NewTLSServer(handler)
+	t.Cleanup(ts.Close)
+	plain := httptest.NewServer(handler)
+	t.Cleanup(plain.Close)
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NilError(t, os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o644))
+	host := strings.TrimPrefix(ts.URL, "https://")
+	plainHost := strings.TrimPrefix(plain.URL, "http://")
+
+	get := func(t *testing.T, c *http.Client, url string) string {
+		resp, err := Get(context.Background(), c, url)
+		assert.NilError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		assert.NilError(t, err)
+		return string(b)
+	}
+
+	t.Run("untrusted CA", func(t *testing.T) {
+		c, err := NewClient(Config{}, nil)
+		assert.NilError(t, err)
+		_, err = Get(context.Background(), c, ts.URL)
+		assert.ErrorContains(t, err, "certificate")
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		c, err := NewClient(Config{
+			CACerts: []string{caCert},
+			Hosts: map[string]HostConfig{
+				host:      {BearerToken: "secret-token"},
+				plainHost: {BearerToken: "secret-token"},
+			},
+		}, nil)
+		assert.NilError(t, err)
+		assert.Equal(t, get(t, c, ts.URL), "Bearer secret-token")
+		// The credentials are not sent over plain HTTP
+		assert.Equal(t, get(t, c, plain.URL), "")
+	})
+
+	t.Run("netrc", func(t *testing.T) {
+		netrc := ParseNetrc("machine 127.0.0.1 login user password pass\nmachine 127.0.0.1 login other password other\n")
+		c, err := NewClient(Config{CACerts: []string{caCert}}, netrc)
+		assert.NilError(t, err)
+		assert.Equal(t, get(t, c, ts.URL), "Basic dXNlcjpwYXNz")
+	})
+
+	t.Run("netrc default", func(t *testing.T) {
+		netrc := ParseNetrc("machine images.example.com login user password pass\ndefault login anonymous password guest\n")
+		c, err := NewClient(Config{CACerts: []string{caCert}}, netrc)
+		assert.NilError(t, err)
+		// The credentials of the "default" entry are not sent to an unknown host
+		assert.Equal(t, get(t, c, ts.URL), "")
+	})
+
+	t.Run("invalid CA", func(t *testing.T) {
+		_, err := NewClient(Config{CACerts: []string{filepath.Join("testdata", "missing.pem")}}, nil)
+		assert.ErrorContains(t, err, "missing.pem")
+		notPEM := filepath.Join(t.TempDir(), "not.pem")
+		assert.NilError(t, os.WriteFile(notPEM, []byte("not a certificate"), 0o644))
+		_, err = NewClient(Config{CACerts: []string{notPEM}}, nil)
+		assert.ErrorContains(t, err, "no PEM certificate found")
+	})
+
+	t.Run("proxy", func(t *testing.T) {
+		t.Setenv("HTTPS_PROXY", "http://env-proxy.example.com:3128")
+		c, err := NewClient(Config{Proxy: ProxyConfig{HTTP: "http://proxy.example.com:3128", NoProxy: "internal.example.com"}}, nil)
+		assert.NilError(t, err)
+		tr := c.Transport.(*http.Transport)
+		for url, expected := range map[string]string{
+			"http://images.example.com/image.img":   "http://proxy.example.com:3128",
+			"https://images.example.com/image.img":  "http://env-proxy.example.com:3128",
+			"http://internal.example.com/image.img": "",
+		} {
+			req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+			assert.NilError(t, err)
+			proxyURL, err := tr.Proxy(req)
+			assert.NilError(t, err)
+			if expected == "" {
+				assert.Assert(t, proxyURL == nil, url)
+			} else {
+				assert.Equal(t, proxyURL.String(), expected, url)
+			}
+		}
+	})
+}
+
+func TestParseNetrc(t *testing.T) {
+	entries := ParseNetrc(`# comment
+machine example.com
+  login user
+  password "pass"
+macdef init
+  cd /pub
+  bin
+
+machine other.example.com login other account acct password other-pass
+default login anonymous password guest
+`)
+	assert.DeepEqual(t, entries, []NetrcEntry{
+		{Machine: "example.com", Login: "user", Password: "pass"},
+		{Machine: "other.example.com", Login: "other", Password: "other-pass"},
+		{Login: "anonymous", Password: "guest"},
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := LoadConfig(filepath.Join(dir, "missing.yaml"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cfg, Config{})
+
+	cfgFile := filepath.Join(dir, "http.yaml")
+	assert.NilError(t, os.WriteFile(cfgFile, []byte(`
+proxy:
+  https: http://proxy.example.com:3128
+caCerts:
+- /etc/ssl/internal-ca.pem
+hosts:
+  artifacts.example.com:
+    bearerToken: token
+`), 0o644))
+	cfg, err = LoadConfig(cfgFile)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, cfg, Config{
+		Proxy:   ProxyConfig{HTTPS: "http://proxy.example.com:3128"},
+		CACerts: []string{"/etc/ssl/internal-ca.pem"},
+		Hosts:   map[string]HostConfig{"artifacts.example.com": {BearerToken: "token"}},
+	})
+
+	assert.NilError(t, os.WriteFile(cfgFile, []byte("unknown: true\n"), 0o644))
+	_, err = LoadConfig(cfgFile)
+	assert.ErrorContains(t, err, "cannot parse")
+}
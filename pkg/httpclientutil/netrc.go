@@ -0,0 +1,101 @@
+package httpclientutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// NetrcEntry is an entry of the netrc file. Machine is empty for the "default" entry.
+type NetrcEntry struct {
+	Machine  string
+	Login    string
+	Password string
+}
+
+// netrcPath returns $NETRC, or ~/.netrc (~/_netrc on Windows).
+func netrcPath() (string, error) {
+	if p := os.Getenv("NETRC"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name), nil
+}
+
+func loadNetrc() ([]NetrcEntry, error) {
+	p, err := netrcPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ParseNetrc(string(b)), nil
+}
+
+// ParseNetrc parses the netrc file, in the format of curl and ftp.
+// The "macdef" macros and the "account" tokens are ignored.
+// Quoted tokens must not contain whitespaces.
+func ParseNetrc(s string) []NetrcEntry {
+	var entries []NetrcEntry
+	var cur *NetrcEntry
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		fields := strings.Fields(lines[i])
+		// Passwords may contain "#", so only the lines starting with "#" are comments
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		for k := 0; k < len(fields); k++ {
+			next := func() string {
+				if k+1 < len(fields) {
+					k++
+					v := fields[k]
+					if len(v) >= 2 && strings.HasPrefix(v, `"`) && strings.HasSuffix(v, `"`) {
+						v = v[1 : len(v)-1]
+					}
+					return v
+				}
+				return ""
+			}
+			switch fields[k] {
+			case "machine":
+				entries = append(entries, NetrcEntry{Machine: next()})
+				cur = &entries[len(entries)-1]
+			case "default":
+				entries = append(entries, NetrcEntry{})
+				cur = &entries[len(entries)-1]
+			case "login":
+				if v := next(); cur != nil {
+					cur.Login = v
+				}
+			case "password":
+				if v := next(); cur != nil {
+					cur.Password = v
+				}
+			case "account":
+				next()
+			case "macdef":
+				// The macro continues until an empty line
+				for i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" {
+					i++
+				}
+				k = len(fields)
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,201 @@
+package limatmpl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"github.com/lima-vm/lima/pkg/yqutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Embed merges the base templates listed in the `base` field into the template, and removes the `base` field.
+// The base templates are resolved recursively; a loop of base templates is an error.
+//
+// The template takes precedence over its base templates, and an earlier base template takes precedence over
+// a later one, in the same way as a template takes precedence over default.yaml in limayaml.FillDefault.
+// The lists are combined, e.g., the images of the template are followed by the images of the base templates.
+// The metadata of the base templates is not inherited.
+//
+// A relative base locator is resolved against the locator of the template.
+func (tmpl *Template) Embed(ctx context.Context) error {
+	self, err := absLocator(tmpl.Locator)
+	if err != nil {
+		return err
+	}
+	return tmpl.embed(ctx, []string{self}, false)
+}
+
+// EmbedLocal is similar to Embed, but skips the base templates that would have to be fetched over the network,
+// i.e., the http(s) URLs and the templates of the template repositories.
+func (tmpl *Template) EmbedLocal(ctx context.Context) error {
+	self, err := absLocator(tmpl.Locator)
+	if err != nil {
+		return err
+	}
+	return tmpl.embed(ctx, []string{self}, true)
+}
+
+func (tmpl *Template) embed(ctx context.Context, parents []string, localOnly bool) error {
+	var y struct {
+		Base []string `yaml:"base"`
+	}
+	if err := yaml.Unmarshal(tmpl.Bytes, &y); err != nil {
+		return fmt.Errorf("failed to unmarshal the base templates of %q: %w", tmpl.Locator, err)
+	}
+	if len(y.Base) == 0 {
+		return nil
+	}
+	for _, locator := range y.Base {
+		resolved, err := resolveBaseLocator(tmpl.Locator, locator)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(parents, resolved) {
+			return fmt.Errorf("base template loop detected: %s", strings.Join(append(parents, resolved), " -> "))
+		}
+		if localOnly && isRemoteLocator(resolved) {
+			logrus.Debugf("Skipping the remote base template %q of %q", resolved, tmpl.Locator)
+			continue
+		}
+		logrus.Debugf("Embedding the base template %q into %q", resolved, tmpl.Locator)
+		base, err := Read(ctx, "", resolved)
+		if err != nil {
+			return fmt.Errorf("failed to read the base template %q of %q: %w", locator, tmpl.Locator, err)
+		}
+		if len(base.Bytes) == 0 {
+			return fmt.Errorf("don't know how to interpret %q as a base template locator", locator)
+		}
+		if err := base.embed(ctx, append(slices.Clone(parents), resolved), localOnly); err != nil {
+			return err
+		}
+		tmpl.Bytes, err = merge(tmpl.Bytes, base.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to merge the base template %q into %q: %w", locator, tmpl.Locator, err)
+		}
+	}
+	var err error
+	tmpl.Bytes, err = yqutil.EvaluateExpression("del(.base)", tmpl.Bytes)
+	return err
+}
+
+// isRemoteLocator returns true when reading the locator may need the network.
+func isRemoteLocator(locator string) bool {
+	if SeemsHTTPURL(locator) {
+		return true
+	}
+	isTemplateURL, u := SeemsTemplateURL(locator)
+	if !isTemplateURL || strings.TrimPrefix(u.Path, "/") == "" {
+		return false
+	}
+	repos, err := Repos()
+	if err != nil {
+		return true
+	}
+	return slices.ContainsFunc(repos, func(r Repo) bool { return r.Name == u.Host })
+}
+
+// absLocator returns the absolute path for a local file path, so that the loops can be detected.
+func absLocator(locator string) (string, error) {
+	if locator == "" || locator == "-" || strings.Contains(locator, "://") {
+		return locator, nil
+	}
+	return filepath.Abs(locator)
+}
+
+// resolveBaseLocator resolves the base locator against the locator of the template (parent).
+func resolveBaseLocator(parent, locator string) (string, error) {
+	if locator == "-" {
+		return "", fmt.Errorf("base template locator %q is not supported", locator)
+	}
+	if !strings.Contains(locator, "://") && !SeemsYAMLPath(locator) {
+		return "", fmt.Errorf("base template locator %q must be a URL or a YAML file path", locator)
+	}
+	remoteParent := SeemsHTTPURL(parent)
+	switch {
+	case strings.Contains(locator, "://"):
+		if remoteParent && !SeemsHTTPURL(locator) && !strings.HasPrefix(locator, "template://") {
+			return "", fmt.Errorf("remote template %q cannot refer to the local base template %q", parent, locator)
+		}
+		return locator, nil
+	case filepath.IsAbs(locator):
+		if remoteParent {
+			return "", fmt.Errorf("remote template %q cannot refer to the local base template %q", parent, locator)
+		}
+		return locator, nil
+	}
+	if isTemplateURL, u := SeemsTemplateURL(parent); isTemplateURL {
+		// e.g., "common.yaml" in "template://team/dev" refers to "template://team/common"
+		name := path.Join(path.Dir(path.Join(u.Host, u.Path)), filepath.ToSlash(locator))
+		return "template://" + strings.TrimSuffix(name, ".yaml"), nil
+	}
+	if remoteParent || SeemsFileURL(parent) {
+		u, err := url.Parse(parent)
+		if err != nil {
+			return "", err
+		}
+		ref, err := url.Parse(filepath.ToSlash(locator))
+		if err != nil {
+			return "", err
+		}
+		return u.ResolveReference(ref).String(), nil
+	}
+	if parent == "" || parent == "-" {
+		return filepath.Abs(locator)
+	}
+	return filepath.Abs(filepath.Join(filepath.Dir(parent), locator))
+}
+
+// appendedLists are combined as the lists of the template followed by the lists of the base template.
+var appendedLists = []string{
+	".images",
+	".additionalDisks",
+	".mountTypesUnsupported",
+	".firmware.images",
+	".provision",
+	".containerd.archives",
+	".probes",
+	".portForwards",
+	".copyToHost",
+}
+
+// prependedLists are combined as the lists of the base template followed by the lists of the template,
+// as limayaml.FillDefault lets the later entries of the same mount location (network interface) override the earlier ones.
+var prependedLists = []string{
+	".mounts",
+	".networks",
+	".caCerts.files",
+	".caCerts.certs",
+}
+
+// merge merges the base template into the template.
+func merge(tmpl, base []byte) ([]byte, error) {
+	var m map[string]any
+	if err := yaml.Unmarshal(base, &m); err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return tmpl, nil
+	}
+	exprs := []string{"select(di == 1) as $base", "select(di == 0)"}
+	for _, p := range appendedLists {
+		exprs = append(exprs, fmt.Sprintf("with(select(%[1]s != null or $base%[1]s != null); %[1]s = (%[1]s // []) + ($base%[1]s // []))", p))
+	}
+	for _, p := range prependedLists {
+		exprs = append(exprs, fmt.Sprintf("with(select(%[1]s != null or $base%[1]s != null); %[1]s = ($base%[1]s // []) + (%[1]s // []))", p))
+	}
+	// The other fields of the template take precedence over the fields of the base template.
+	// The metadata describes the base template itself, so it is not inherited.
+	exprs = append(exprs, ". *n ($base | del(.metadata))")
+	var b bytes.Buffer
+	b.Write(tmpl)
+	b.WriteString("\n---\n")
+	b.Write(base)
+	return yqutil.EvaluateExpression(yqutil.Join(exprs), b.Bytes())
+}
@@ -0,0 +1,148 @@
+package limatmpl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"gotest.tools/v3/assert"
+)
+
+func TestEmbed(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		assert.NilError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		assert.NilError(t, os.WriteFile(p, []byte(strings.TrimSpace(content)+"\n"), 0o644))
+		return p
+	}
+	write("common/ubuntu.yaml", `
+metadata:
+  description: Ubuntu
+cpus: 4
+memory: 4GiB
+images:
+- location: https://example.com/ubuntu-amd64.img
+  arch: x86_64
+mounts:
+- location: "~"
+provision:
+- mode: system
+  script: echo ubuntu
+env:
+  A: ubuntu
+  B: ubuntu
+`)
+	write("common/docker.yaml", `
+base:
+- ./ubuntu.yaml
+memory: 8GiB
+mounts:
+- location: "/tmp/lima"
+  writable: true
+provision:
+- mode: system
+  script: echo docker
+`)
+	tmplPath := write("dev.yaml", `
+# comment of dev
+base:
+- ./common/docker.yaml
+cpus: 2 # only 2 cpus
+mounts:
+- location: "~"
+  writable: true
+env:
+  A: dev
+`)
+
+	tmpl := &Template{Locator: tmplPath}
+	var err error
+	tmpl.Bytes, err = os.ReadFile(tmplPath)
+	assert.NilError(t, err)
+	assert.NilError(t, tmpl.Embed(context.Background()))
+	assert.Assert(t, strings.Contains(string(tmpl.Bytes), "# comment of dev"))
+	assert.Assert(t, strings.Contains(string(tmpl.Bytes), "# only 2 cpus"))
+
+	var y limayaml.LimaYAML
+	assert.NilError(t, yaml.Unmarshal(tmpl.Bytes, &y))
+	assert.Equal(t, len(y.Base), 0)
+	assert.Assert(t, y.Metadata.Description == nil)
+	assert.Equal(t, *y.CPUs, 2)
+	assert.Equal(t, *y.Memory, "8GiB")
+	assert.Equal(t, len(y.Images), 1)
+	assert.DeepEqual(t, y.Env, map[string]string{"A": "dev", "B": "ubuntu"})
+	// The provisioning scripts of the template precede those of the base template, as in FillDefault
+	assert.Equal(t, len(y.Provision), 2)
+	assert.Equal(t, y.Provision[0].Script, "echo docker")
+	assert.Equal(t, y.Provision[1].Script, "echo ubuntu")
+	// The mounts of the template follow those of the base template, so that they take precedence
+	assert.Equal(t, len(y.Mounts), 3)
+	assert.Equal(t, y.Mounts[0].Location, "~")
+	assert.Equal(t, y.Mounts[1].Location, "/tmp/lima")
+	assert.Equal(t, y.Mounts[2].Location, "~")
+	assert.Equal(t, *y.Mounts[2].Writable, true)
+
+	// The templates without base are not modified
+	b := []byte("# comment\ncpus: 2\n")
+	tmpl = &Template{Locator: tmplPath, Bytes: b}
+	assert.NilError(t, tmpl.Embed(context.Background()))
+	assert.Equal(t, string(tmpl.Bytes), string(b))
+}
+
+func TestEmbedLocal(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "common.yaml"), []byte("cpus: 4\n"), 0o644))
+	// The remote base template would fail to be fetched
+	b := []byte("base: [./common.yaml, \"http://127.0.0.1:0/remote.yaml\"]\nmemory: 8GiB\n")
+	tmpl := &Template{Locator: filepath.Join(dir, "dev.yaml"), Bytes: b}
+	assert.NilError(t, tmpl.EmbedLocal(context.Background()))
+	var y limayaml.LimaYAML
+	assert.NilError(t, yaml.Unmarshal(tmpl.Bytes, &y))
+	assert.Equal(t, len(y.Base), 0)
+	assert.Equal(t, *y.CPUs, 4)
+	assert.Equal(t, *y.Memory, "8GiB")
+
+	tmpl = &Template{Locator: filepath.Join(dir, "dev.yaml"), Bytes: b}
+	assert.ErrorContains(t, tmpl.Embed(context.Background()), "remote.yaml")
+}
+
+func TestEmbedLoop(t *testing.T) {
+	dir := t.TempDir()
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "a.yaml"), []byte("base: [./b.yaml]\n"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "b.yaml"), []byte("base: [./a.yaml]\n"), 0o644))
+	tmpl := &Template{Locator: filepath.Join(dir, "a.yaml"), Bytes: []byte("base: [./b.yaml]\n")}
+	err := tmpl.Embed(context.Background())
+	assert.ErrorContains(t, err, "base template loop detected")
+}
+
+func TestResolveBaseLocator(t *testing.T) {
+	cases := []struct {
+		parent   string
+		locator  string
+		expected string
+		err      string
+	}{
+		{parent: "template://team/dev", locator: "common.yaml", expected: "template://team/common"},
+		{parent: "template://dev", locator: "template://ubuntu", expected: "template://ubuntu"},
+		{parent: "https://example.com/templates/dev.yaml", locator: "../common/ubuntu.yaml", expected: "https://example.com/common/ubuntu.yaml"},
+		{parent: "file:///templates/dev.yaml", locator: "ubuntu.yaml", expected: "file:///templates/ubuntu.yaml"},
+		{parent: "https://example.com/dev.yaml", locator: "file:///etc/ubuntu.yaml", err: "cannot refer to the local base template"},
+		{parent: "https://example.com/dev.yaml", locator: "/etc/ubuntu.yaml", err: "cannot refer to the local base template"},
+		{parent: "dev.yaml", locator: "ubuntu", err: "must be a URL or a YAML file path"},
+		{parent: "dev.yaml", locator: "-", err: "is not supported"},
+	}
+	for _, tc := range cases {
+		got, err := resolveBaseLocator(tc.parent, tc.locator)
+		if tc.err != "" {
+			assert.ErrorContains(t, err, tc.err, tc.locator)
+			continue
+		}
+		assert.NilError(t, err, tc.locator)
+		assert.Equal(t, got, tc.expected, tc.locator)
+	}
+}
@@ -0,0 +1,120 @@
+package limatmpl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/templatestore"
+)
+
+// Info is the summary of a template, printed by `limactl template list`.
+type Info struct {
+	Name        string          `json:"name"`
+	Location    string          `json:"location"`
+	Description string          `json:"description,omitempty"`
+	Tags        []string        `json:"tags,omitempty"`
+	OS          limayaml.OS     `json:"os"`
+	Arch        []limayaml.Arch `json:"arch"`
+	// VMTypes are the VM types that the template can be used with.
+	VMTypes    []limayaml.VMType `json:"vmTypes"`
+	Deprecated bool              `json:"deprecated,omitempty"`
+}
+
+// Inspect returns the summary of the template in the template store.
+// The local base templates are embedded to determine the architectures; the remote ones are not fetched.
+func Inspect(ctx context.Context, t templatestore.Template) (*Info, error) {
+	b, err := os.ReadFile(t.Location)
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &Template{Name: t.Name, Locator: "template://" + t.Name, Bytes: b}
+	description := leadingComment(tmpl.Bytes)
+	if err := tmpl.EmbedLocal(ctx); err != nil {
+		return nil, err
+	}
+	var y limayaml.LimaYAML
+	if err := limayaml.Unmarshal(tmpl.Bytes, &y, fmt.Sprintf("template %q", t.Name)); err != nil {
+		return nil, err
+	}
+	info := &Info{
+		Name:        t.Name,
+		Location:    t.Location,
+		Description: description,
+		Tags:        y.Metadata.Tags,
+		OS:          limayaml.LINUX,
+		Deprecated:  strings.HasPrefix(t.Name, "deprecated/"),
+	}
+	if y.Metadata.Description != nil {
+		info.Description = *y.Metadata.Description
+	}
+	if y.Metadata.Deprecated != nil && *y.Metadata.Deprecated {
+		info.Deprecated = true
+	}
+	if y.OS != nil {
+		info.OS = limayaml.NewOS(*y.OS)
+	}
+	defaultArch := limayaml.NewArch(runtime.GOARCH)
+	if y.Arch != nil {
+		defaultArch = limayaml.NewArch(*y.Arch)
+	}
+	for _, img := range y.Images {
+		arch := defaultArch
+		if img.Arch != "" {
+			arch = img.Arch
+		}
+		if !slices.Contains(info.Arch, arch) {
+			info.Arch = append(info.Arch, arch)
+		}
+	}
+	slices.Sort(info.Arch)
+	info.VMTypes = vmTypes(&y, info.Arch)
+	return info, nil
+}
+
+// vmTypes returns the VM types compatible with the template.
+// WSL2 is only compatible with the templates that specify it explicitly, as it requires WSL2 images.
+func vmTypes(y *limayaml.LimaYAML, archs []limayaml.Arch) []limayaml.VMType {
+	if y.VMType != nil && *y.VMType != "" && *y.VMType != "default" {
+		return []limayaml.VMType{limayaml.NewVMType(*y.VMType)}
+	}
+	res := []limayaml.VMType{limayaml.QEMU}
+	// VZ only supports x86_64 and aarch64 guests
+	if slices.Contains(archs, limayaml.X8664) || slices.Contains(archs, limayaml.AARCH64) {
+		res = append(res, limayaml.VZ)
+	}
+	return res
+}
+
+// commentedOutYAML matches a commented-out YAML field, e.g., `# minimumLimaVersion: "1.0.3"`.
+var commentedOutYAML = regexp.MustCompile(`^[A-Za-z]+:(\s|$)`)
+
+// leadingComment returns the first line of the template as the default description, if it is a comment.
+// Headings are skipped. Lines about the usage (e.g., "$ limactl start ..."), lines about the required Lima version,
+// and commented-out YAML fields are not descriptions.
+func leadingComment(b []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !strings.HasPrefix(line, "#") {
+			return ""
+		}
+		line = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		if strings.ToUpper(line) == line {
+			// Heading, e.g., "# BASIC CONFIGURATION"
+			continue
+		}
+		if strings.HasPrefix(line, "$") || strings.Contains(line, "requires Lima") || commentedOutYAML.MatchString(line) {
+			return ""
+		}
+		return line
+	}
+	return ""
+}
@@ -0,0 +1,30 @@
+package limatmpl
+
+import (
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/ptr"
+	"gotest.tools/v3/assert"
+)
+
+func TestLeadingComment(t *testing.T) {
+	for content, expected := range map[string]string{
+		"# A template to use Docker\n# $ limactl start ./docker.yaml\nimages: []\n": "A template to use Docker",
+		"# ====\n# BASIC CONFIGURATION\n# ====\n\n# Default values\n":               "",
+		"# This template requires Lima v0.7.0 or later\n# Oracle image license\n":   "",
+		"# minimumLimaVersion: \"1.0.3\"\n\nimages: []\n":                           "",
+		"images: []\n# A comment\n":                                                 "",
+	} {
+		assert.Equal(t, leadingComment([]byte(content)), expected, content)
+	}
+}
+
+func TestVMTypes(t *testing.T) {
+	assert.DeepEqual(t, vmTypes(&limayaml.LimaYAML{}, []limayaml.Arch{limayaml.AARCH64, limayaml.X8664}),
+		[]limayaml.VMType{limayaml.QEMU, limayaml.VZ})
+	assert.DeepEqual(t, vmTypes(&limayaml.LimaYAML{}, []limayaml.Arch{limayaml.RISCV64}),
+		[]limayaml.VMType{limayaml.QEMU})
+	assert.DeepEqual(t, vmTypes(&limayaml.LimaYAML{VMType: ptr.Of(limayaml.WSL2)}, []limayaml.Arch{limayaml.X8664}),
+		[]limayaml.VMType{limayaml.WSL2})
+}
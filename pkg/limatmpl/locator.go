@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"path"
@@ -12,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/containerd/containerd/identifiers"
+	"github.com/lima-vm/lima/pkg/httpclientutil"
 	"github.com/lima-vm/lima/pkg/ioutilx"
 	"github.com/lima-vm/lima/pkg/templatestore"
 	"github.com/sirupsen/logrus"
@@ -43,10 +43,17 @@ func Read(ctx context.Context, name, locator string) (*Template, error) {
 			// e.g., templateName = "deprecated/centos-7" , tmpl.Name = "centos-7"
 			tmpl.Name = filepath.Base(templateName)
 		}
-		tmpl.Bytes, err = templatestore.Read(templateName)
+		var isRepo bool
+		tmpl.Bytes, isRepo, err = readFromRepo(ctx, templateURL)
 		if err != nil {
 			return nil, err
 		}
+		if !isRepo {
+			tmpl.Bytes, err = templatestore.Read(templateName)
+			if err != nil {
+				return nil, err
+			}
+		}
 	case SeemsHTTPURL(locator):
 		if tmpl.Name == "" {
 			tmpl.Name, err = InstNameFromURL(locator)
@@ -55,11 +62,11 @@ func Read(ctx context.Context, name, locator string) (*Template, error) {
 			}
 		}
 		logrus.Debugf("interpreting argument %q as a http url for instance %q", locator, tmpl.Name)
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, locator, http.NoBody)
+		client, err := httpclientutil.DefaultClient()
 		if err != nil {
 			return nil, err
 		}
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := httpclientutil.Get(ctx, client, locator)
 		if err != nil {
 			return nil, err
 		}
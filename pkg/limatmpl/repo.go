@@ -0,0 +1,264 @@
+package limatmpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/containerd/containerd/identifiers"
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/goccy/go-yaml"
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/lima-vm/lima/pkg/ioutilx"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
+	"github.com/lima-vm/lima/pkg/store/filenames"
+	"github.com/lima-vm/lima/pkg/templatestore"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// Repo is a template repository, registered with `limactl template repo add NAME URL`.
+// The templates of the repository are referred as "template://NAME/TEMPLATE".
+type Repo struct {
+	Name string `yaml:"name" json:"name"`
+	// URL is the URL of the catalog index file.
+	URL string `yaml:"url" json:"url"`
+}
+
+// Catalog is the index file of a template repository, in YAML or JSON.
+type Catalog struct {
+	Templates []CatalogEntry `yaml:"templates" json:"templates"`
+}
+
+// CatalogEntry is a template in the catalog.
+type CatalogEntry struct {
+	Name string `yaml:"name" json:"name"`
+	// URL is resolved against the URL of the catalog.
+	URL    string        `yaml:"url" json:"url"`
+	Digest digest.Digest `yaml:"digest" json:"digest"`
+	// Description is optional.
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+}
+
+type repoConfig struct {
+	Repos []Repo `yaml:"repos"`
+}
+
+func reposFile() (string, error) {
+	cfgDir, err := dirnames.LimaConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cfgDir, filenames.TemplateRepos), nil
+}
+
+// Repos returns the registered template repositories.
+func Repos() ([]Repo, error) {
+	p, err := reposFile()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var cfg repoConfig
+	if err := yaml.UnmarshalWithOptions(b, &cfg, yaml.Strict()); err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", p, err)
+	}
+	return cfg.Repos, nil
+}
+
+func saveRepos(repos []Repo) error {
+	p, err := reposFile()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(repoConfig{Repos: repos})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, b, 0o644)
+}
+
+// AddRepo registers the template repository, after fetching its catalog.
+func AddRepo(ctx context.Context, repo Repo) (*Catalog, error) {
+	if err := identifiers.Validate(repo.Name); err != nil {
+		return nil, fmt.Errorf("invalid repository name %q: %w", repo.Name, err)
+	}
+	isDir, err := isTemplateStoreDir(repo.Name)
+	if err != nil {
+		return nil, err
+	}
+	if isDir {
+		return nil, fmt.Errorf("repository name %q conflicts with the directory %q of the template store", repo.Name, repo.Name)
+	}
+	if !SeemsHTTPURL(repo.URL) && !SeemsFileURL(repo.URL) {
+		return nil, fmt.Errorf("repository URL must be an http(s):// or file:// URL, got %q", repo.URL)
+	}
+	repos, err := Repos()
+	if err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(repos, func(r Repo) bool { return r.Name == repo.Name }) {
+		return nil, fmt.Errorf("repository %q already exists", repo.Name)
+	}
+	catalog, err := FetchCatalog(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	return catalog, saveRepos(append(repos, repo))
+}
+
+// RemoveRepo unregisters the template repository.
+func RemoveRepo(name string) error {
+	repos, err := Repos()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(repos, func(r Repo) bool { return r.Name == name })
+	if i < 0 {
+		return fmt.Errorf("repository %q does not exist", name)
+	}
+	return saveRepos(slices.Delete(repos, i, i+1))
+}
+
+// FetchCatalog fetches the catalog of the repository.
+// The catalog is cached, and the cache is used when the catalog cannot be fetched.
+func FetchCatalog(ctx context.Context, repo Repo) (*Catalog, error) {
+	b, err := fetch(ctx, repo.URL, "", fmt.Sprintf("the catalog of the template repository %q", repo.Name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the catalog of the template repository %q: %w", repo.Name, err)
+	}
+	var catalog Catalog
+	if err := yaml.Unmarshal(b, &catalog); err != nil {
+		return nil, fmt.Errorf("cannot parse the catalog of the template repository %q: %w", repo.Name, err)
+	}
+	for _, e := range catalog.Templates {
+		if e.Name == "" || e.URL == "" {
+			return nil, fmt.Errorf("the catalog of the template repository %q has an entry without name or url", repo.Name)
+		}
+		if err := e.Digest.Validate(); err != nil {
+			return nil, fmt.Errorf("template %q in the catalog of the template repository %q has an invalid digest %q: %w",
+				e.Name, repo.Name, e.Digest, err)
+		}
+	}
+	return &catalog, nil
+}
+
+// readFromRepo reads "template://REPO/NAME". The boolean is false when REPO is not a registered repository.
+func readFromRepo(ctx context.Context, u *url.URL) ([]byte, bool, error) {
+	name := strings.TrimPrefix(u.Path, "/")
+	if name == "" {
+		return nil, false, nil
+	}
+	repos, err := Repos()
+	if err != nil {
+		// A broken repository file must not break the templates of the template store
+		if isDir, dirErr := isTemplateStoreDir(u.Host); dirErr == nil && isDir {
+			logrus.WithError(err).Warnf("Ignoring the template repositories for %q", u.String())
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	i := slices.IndexFunc(repos, func(r Repo) bool { return r.Name == u.Host })
+	if i < 0 {
+		return nil, false, nil
+	}
+	repo := repos[i]
+	if isDir, _ := isTemplateStoreDir(repo.Name); isDir {
+		logrus.Warnf("The template repository %q shadows the directory %q of the template store", repo.Name, repo.Name)
+	}
+	catalog, err := FetchCatalog(ctx, repo)
+	if err != nil {
+		return nil, true, err
+	}
+	j := slices.IndexFunc(catalog.Templates, func(e CatalogEntry) bool { return e.Name == name })
+	if j < 0 {
+		return nil, true, fmt.Errorf("template %q is not found in the template repository %q: %w", name, repo.Name, os.ErrNotExist)
+	}
+	entry := catalog.Templates[j]
+	base, err := url.Parse(repo.URL)
+	if err != nil {
+		return nil, true, err
+	}
+	ref, err := url.Parse(entry.URL)
+	if err != nil {
+		return nil, true, err
+	}
+	location := base.ResolveReference(ref).String()
+	if SeemsHTTPURL(repo.URL) && !SeemsHTTPURL(location) {
+		return nil, true, fmt.Errorf("remote template repository %q cannot refer to the local file %q", repo.Name, location)
+	}
+	b, err := fetch(ctx, location, entry.Digest, fmt.Sprintf("template %q", u.String()))
+	return b, true, err
+}
+
+// templateStoreDirs is replaced in the tests, as the bundled templates are not available there.
+var templateStoreDirs = templatestore.Dirs
+
+// isTemplateStoreDir returns true when name is a directory of the template store, e.g., "deprecated".
+func isTemplateStoreDir(name string) (bool, error) {
+	dirs, err := templateStoreDirs()
+	if err != nil {
+		return false, err
+	}
+	for _, dir := range dirs {
+		p, err := securejoin.SecureJoin(dir, name)
+		if err != nil {
+			return false, err
+		}
+		if st, err := os.Stat(p); err == nil && st.IsDir() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fetch fetches the remote file via the download cache, and verifies the digest, if specified.
+// The local files (file://) are not cached.
+func fetch(ctx context.Context, remote string, expectedDigest digest.Digest, description string) ([]byte, error) {
+	logrus.Debugf("Fetching %s from %q", description, remote)
+	if downloader.IsLocal(remote) {
+		f, err := os.Open(strings.TrimPrefix(remote, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		b, err := ioutilx.ReadAtMaximum(f, yBytesLimit)
+		if err != nil {
+			return nil, err
+		}
+		if expectedDigest != "" {
+			if actual := expectedDigest.Algorithm().FromBytes(b); actual != expectedDigest {
+				return nil, fmt.Errorf("expected digest %q, got %q", expectedDigest, actual)
+			}
+		}
+		return b, nil
+	}
+	res, err := downloader.Download(ctx, "", remote,
+		downloader.WithCache(),
+		downloader.WithDescription(description),
+		downloader.WithExpectedDigest(expectedDigest),
+	)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(res.CachePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutilx.ReadAtMaximum(f, yBytesLimit)
+}
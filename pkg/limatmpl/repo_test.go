@@ -0,0 +1,106 @@
+package limatmpl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/downloader"
+	"github.com/opencontainers/go-digest"
+	"gotest.tools/v3/assert"
+)
+
+func TestRepo(t *testing.T) {
+	downloader.HideProgress = true
+	t.Setenv("LIMA_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	storeDir := t.TempDir()
+	assert.NilError(t, os.Mkdir(filepath.Join(storeDir, "deprecated"), 0o755))
+	origTemplateStoreDirs := templateStoreDirs
+	templateStoreDirs = func() ([]string, error) { return []string{storeDir}, nil }
+	t.Cleanup(func() { templateStoreDirs = origTemplateStoreDirs })
+
+	dir := t.TempDir()
+	dev := "images: [{location: /dev.img}]\n"
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "dev.yaml"), []byte(dev), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "tampered.yaml"), []byte("tampered"), 0o644))
+	assert.NilError(t, os.WriteFile(filepath.Join(dir, "index.yaml"), []byte(fmt.Sprintf(`
+templates:
+- name: dev
+  url: dev.yaml
+  digest: %q
+  description: Development
+- name: tampered
+  url: tampered.yaml
+  digest: %q
+- name: local
+  url: file:///etc/passwd
+  digest: %q
+`, digest.FromString(dev), digest.FromString("original"), digest.FromString("local"))), 0o644))
+	ts := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(ts.Close)
+	ctx := context.Background()
+
+	_, err := AddRepo(ctx, Repo{Name: "team", URL: ts.URL + "/missing.yaml"})
+	assert.ErrorContains(t, err, "failed to fetch the catalog")
+	catalog, err := AddRepo(ctx, Repo{Name: "team", URL: ts.URL + "/index.yaml"})
+	assert.NilError(t, err)
+	assert.Equal(t, len(catalog.Templates), 3)
+	assert.Equal(t, catalog.Templates[0].Description, "Development")
+	_, err = AddRepo(ctx, Repo{Name: "team", URL: ts.URL + "/index.yaml"})
+	assert.ErrorContains(t, err, "already exists")
+	// The name of a directory of the template store would shadow the templates in it
+	_, err = AddRepo(ctx, Repo{Name: "deprecated", URL: ts.URL + "/index.yaml"})
+	assert.ErrorContains(t, err, "conflicts with the directory")
+	repos, err := Repos()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, repos, []Repo{{Name: "team", URL: ts.URL + "/index.yaml"}})
+
+	tmpl, err := Read(ctx, "", "template://team/dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(tmpl.Bytes), dev)
+	assert.Equal(t, tmpl.Name, "dev")
+
+	_, err = Read(ctx, "", "template://team/tampered")
+	assert.ErrorContains(t, err, "expected digest")
+	_, err = Read(ctx, "", "template://team/local")
+	assert.ErrorContains(t, err, "cannot refer to the local file")
+	_, err = Read(ctx, "", "template://team/missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Not a registered repository
+	_, ok, err := readFromRepo(ctx, &url.URL{Scheme: "template", Host: "experimental", Path: "/vnc"})
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+
+	// The cached catalog and template are used while the server is down
+	ts.Close()
+	tmpl, err = Read(ctx, "", "template://team/dev")
+	assert.NilError(t, err)
+	assert.Equal(t, string(tmpl.Bytes), dev)
+
+	// A broken repository file does not break the templates of the template store
+	reposPath, err := reposFile()
+	assert.NilError(t, err)
+	reposBytes, err := os.ReadFile(reposPath)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(reposPath, []byte("broken"), 0o644))
+	_, ok, err = readFromRepo(ctx, &url.URL{Scheme: "template", Host: "deprecated", Path: "/centos-7"})
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+	_, _, err = readFromRepo(ctx, &url.URL{Scheme: "template", Host: "team", Path: "/dev"})
+	assert.ErrorContains(t, err, "cannot parse")
+	assert.NilError(t, os.WriteFile(reposPath, reposBytes, 0o644))
+
+	assert.NilError(t, RemoveRepo("team"))
+	assert.ErrorContains(t, RemoveRepo("team"), "does not exist")
+	repos, err = Repos()
+	assert.NilError(t, err)
+	assert.Equal(t, len(repos), 0)
+}
@@ -45,6 +45,8 @@ const (
 	Default9pCacheForRW      string = "mmap"
 
 	DefaultVirtiofsQueueSize int = 1024
+
+	DefaultHostResolverCacheSize int = 0 // disabled
 )
 
 var (
@@ -162,6 +164,47 @@ func defaultDiskSizeAsString() string {
 	return "100GiB"
 }
 
+// fillParamSchemaDefaults merges the ParamSchema, and fills the Param from the defaults of the schema.
+// It has to be called before the Param is used for executing templates.
+func fillParamSchemaDefaults(y, d, o *LimaYAML) {
+	schema := make(map[string]ParamSchema)
+	for k, v := range d.ParamSchema {
+		schema[k] = v
+	}
+	for k, v := range y.ParamSchema {
+		schema[k] = v
+	}
+	for k, v := range o.ParamSchema {
+		schema[k] = v
+	}
+	if len(schema) == 0 {
+		return
+	}
+	for k, v := range schema {
+		if v.Type == nil {
+			v.Type = ptr.Of(ParamTypeString)
+		}
+		if v.Required == nil {
+			v.Required = ptr.Of(false)
+		}
+		schema[k] = v
+		if v.Default == nil {
+			continue
+		}
+		_, inD := d.Param[k]
+		_, inY := y.Param[k]
+		_, inO := o.Param[k]
+		if inD || inY || inO {
+			continue
+		}
+		if y.Param == nil {
+			y.Param = make(map[string]string)
+		}
+		y.Param[k] = *v.Default
+	}
+	y.ParamSchema = schema
+}
+
 func defaultGuestInstallPrefix() string {
 	return "/usr/local"
 }
@@ -180,6 +223,9 @@ func defaultGuestInstallPrefix() string {
 //   - Networks are appended in d, y, o order
 //   - DNS are picked from the highest priority where DNS is not empty.
 //   - CACertificates Files and Certs are uniquely appended in d, y, o order
+//   - ChecksumSignature PublicKeys are picked from the highest priority where PublicKeys is not empty.
+//   - ParamSchema entries are not merged; the entry with the highest priority replaces the others.
+//     The Default of the schema is used for the Param that is not set in any of d, y, and o.
 func FillDefault(y, d, o *LimaYAML, filePath string, warn bool) {
 	instDir := filepath.Dir(filePath)
 
@@ -196,6 +242,8 @@ func FillDefault(y, d, o *LimaYAML, filePath string, warn bool) {
 		}
 	}
 
+	fillParamSchemaDefaults(y, d, o)
+
 	if y.User.Name == nil {
 		y.User.Name = d.User.Name
 	}
@@ -462,6 +510,33 @@ func FillDefault(y, d, o *LimaYAML, filePath string, warn bool) {
 	}
 	y.HostResolver.Hosts = hosts
 
+	// Note: upstream and record lists are not combined; highest priority setting is picked
+	if len(y.HostResolver.Upstreams) == 0 {
+		y.HostResolver.Upstreams = d.HostResolver.Upstreams
+	}
+	if len(o.HostResolver.Upstreams) > 0 {
+		y.HostResolver.Upstreams = o.HostResolver.Upstreams
+	}
+	if len(y.HostResolver.Records) == 0 {
+		y.HostResolver.Records = d.HostResolver.Records
+	}
+	if len(o.HostResolver.Records) > 0 {
+		y.HostResolver.Records = o.HostResolver.Records
+	}
+
+	// The server lists of a domain are not combined; highest priority setting is picked
+	domains := make(map[string][]string)
+	for k, v := range d.HostResolver.Domains {
+		domains[k] = v
+	}
+	for k, v := range y.HostResolver.Domains {
+		domains[k] = v
+	}
+	for k, v := range o.HostResolver.Domains {
+		domains[k] = v
+	}
+	y.HostResolver.Domains = domains
+
 	y.Provision = append(append(o.Provision, y.Provision...), d.Provision...)
 	for i := range y.Provision {
 		provision := &y.Provision[i]
@@ -580,6 +655,26 @@ func FillDefault(y, d, o *LimaYAML, filePath string, warn bool) {
 		y.HostResolver.IPv6 = ptr.Of(false)
 	}
 
+	if y.HostResolver.CacheSize == nil {
+		y.HostResolver.CacheSize = d.HostResolver.CacheSize
+	}
+	if o.HostResolver.CacheSize != nil {
+		y.HostResolver.CacheSize = o.HostResolver.CacheSize
+	}
+	if y.HostResolver.CacheSize == nil {
+		y.HostResolver.CacheSize = ptr.Of(DefaultHostResolverCacheSize)
+	}
+
+	if y.HostResolver.QueryLog == nil {
+		y.HostResolver.QueryLog = d.HostResolver.QueryLog
+	}
+	if o.HostResolver.QueryLog != nil {
+		y.HostResolver.QueryLog = o.HostResolver.QueryLog
+	}
+	if y.HostResolver.QueryLog == nil {
+		y.HostResolver.QueryLog = ptr.Of(false)
+	}
+
 	if y.PropagateProxyEnv == nil {
 		y.PropagateProxyEnv = d.PropagateProxyEnv
 	}
@@ -613,6 +708,9 @@ func FillDefault(y, d, o *LimaYAML, filePath string, warn bool) {
 			if nw.Metric != nil {
 				networks[i].Metric = nw.Metric
 			}
+			if nw.Conditions != nil {
+				networks[i].Conditions = nw.Conditions
+			}
 		} else {
 			// unnamed network definitions are not combined/overwritten
 			if nw.Interface != "" {
@@ -822,6 +920,37 @@ func FillDefault(y, d, o *LimaYAML, filePath string, warn bool) {
 	caCerts := unique(append(append(d.CACertificates.Certs, y.CACertificates.Certs...), o.CACertificates.Certs...))
 	y.CACertificates.Certs = caCerts
 
+	if y.ChecksumSignature.Type == nil {
+		y.ChecksumSignature.Type = d.ChecksumSignature.Type
+	}
+	if o.ChecksumSignature.Type != nil {
+		y.ChecksumSignature.Type = o.ChecksumSignature.Type
+	}
+	if y.ChecksumSignature.Type == nil {
+		y.ChecksumSignature.Type = ptr.Of(SignatureTypeGPG)
+	}
+	// Note: public keys are not combined; highest priority setting is picked
+	if len(y.ChecksumSignature.PublicKeys) == 0 {
+		y.ChecksumSignature.PublicKeys = d.ChecksumSignature.PublicKeys
+	}
+	if len(o.ChecksumSignature.PublicKeys) > 0 {
+		y.ChecksumSignature.PublicKeys = o.ChecksumSignature.PublicKeys
+	}
+	if y.ChecksumSignature.SignatureSuffix == nil {
+		y.ChecksumSignature.SignatureSuffix = d.ChecksumSignature.SignatureSuffix
+	}
+	if o.ChecksumSignature.SignatureSuffix != nil {
+		y.ChecksumSignature.SignatureSuffix = o.ChecksumSignature.SignatureSuffix
+	}
+	if y.ChecksumSignature.SignatureSuffix == nil {
+		switch *y.ChecksumSignature.Type {
+		case SignatureTypeCosign:
+			y.ChecksumSignature.SignatureSuffix = ptr.Of(".sig")
+		default:
+			y.ChecksumSignature.SignatureSuffix = ptr.Of(".gpg")
+		}
+	}
+
 	if runtime.GOOS == "darwin" && IsNativeArch(AARCH64) {
 		if y.Rosetta.Enabled == nil {
 			y.Rosetta.Enabled = d.Rosetta.Enabled
@@ -12,6 +12,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lima-vm/lima/pkg/networks"
 	"github.com/lima-vm/lima/pkg/osutil"
 	"github.com/lima-vm/lima/pkg/ptr"
 	"github.com/lima-vm/lima/pkg/store/dirnames"
@@ -104,13 +105,19 @@ func TestFillDefault(t *testing.T) {
 			},
 		},
 		HostResolver: HostResolver{
-			Enabled: ptr.Of(true),
-			IPv6:    ptr.Of(false),
+			Enabled:   ptr.Of(true),
+			IPv6:      ptr.Of(false),
+			CacheSize: ptr.Of(DefaultHostResolverCacheSize),
+			QueryLog:  ptr.Of(false),
 		},
 		PropagateProxyEnv: ptr.Of(true),
 		CACertificates: CACertificates{
 			RemoveDefaults: ptr.Of(false),
 		},
+		ChecksumSignature: ChecksumSignature{
+			Type:            ptr.Of(SignatureTypeGPG),
+			SignatureSuffix: ptr.Of(".gpg"),
+		},
 		NestedVirtualization: ptr.Of(false),
 		Plain:                ptr.Of(false),
 		User: User{
@@ -376,6 +383,16 @@ func TestFillDefault(t *testing.T) {
 			Hosts: map[string]string{
 				"default": "localhost",
 			},
+			Upstreams: []string{"tls://1.1.1.1"},
+			Records: []HostResolverRecord{
+				{Name: "svc.test", Type: HostResolverRecordTXT, Text: "default"},
+			},
+			CacheSize: ptr.Of(100),
+			QueryLog:  ptr.Of(true),
+			Domains: map[string][]string{
+				"corp.example": {"10.0.0.53"},
+				"dev.example":  {"10.0.0.54"},
+			},
 		},
 		PropagateProxyEnv: ptr.Of(false),
 
@@ -432,6 +449,10 @@ func TestFillDefault(t *testing.T) {
 				"-----BEGIN CERTIFICATE-----\nYOUR-ORGS-TRUSTED-CA-CERT\n-----END CERTIFICATE-----\n",
 			},
 		},
+		ChecksumSignature: ChecksumSignature{
+			Type:       ptr.Of(SignatureTypeCosign),
+			PublicKeys: []string{"-----BEGIN PUBLIC KEY-----\nDEFAULT\n-----END PUBLIC KEY-----\n"},
+		},
 		Rosetta: Rosetta{
 			Enabled: ptr.Of(true),
 			BinFmt:  ptr.Of(true),
@@ -468,6 +489,7 @@ func TestFillDefault(t *testing.T) {
 	expect.CACertificates.Certs = []string{
 		"-----BEGIN CERTIFICATE-----\nYOUR-ORGS-TRUSTED-CA-CERT\n-----END CERTIFICATE-----\n",
 	}
+	expect.ChecksumSignature.SignatureSuffix = ptr.Of(".sig")
 
 	if runtime.GOOS == "darwin" && IsNativeArch(AARCH64) {
 		expect.Rosetta = Rosetta{
@@ -512,6 +534,10 @@ func TestFillDefault(t *testing.T) {
 	expect.Networks = append(append([]Network{}, dExpect.Networks...), y.Networks...)
 
 	expect.HostResolver.Hosts["default"] = dExpect.HostResolver.Hosts["default"]
+	expect.HostResolver.Upstreams = dExpect.HostResolver.Upstreams
+	expect.HostResolver.Domains = dExpect.HostResolver.Domains
+	expect.HostResolver.Records = dExpect.HostResolver.Records
+	expect.ChecksumSignature.PublicKeys = dExpect.ChecksumSignature.PublicKeys
 
 	// dExpect.DNS will be ignored, and not appended to y.DNS
 
@@ -583,6 +609,15 @@ func TestFillDefault(t *testing.T) {
 			Hosts: map[string]string{
 				"override.": "underflow",
 			},
+			Upstreams: []string{"https://dns.example.com/dns-query"},
+			Records: []HostResolverRecord{
+				{Name: "svc.test", Type: HostResolverRecordMX, Target: "mail.svc.test", Preference: 10},
+			},
+			CacheSize: ptr.Of(0),
+			QueryLog:  ptr.Of(false),
+			Domains: map[string][]string{
+				"corp.example": {"tls://10.0.0.55"},
+			},
 		},
 		PropagateProxyEnv: ptr.Of(false),
 
@@ -627,8 +662,9 @@ func TestFillDefault(t *testing.T) {
 				Metric:     ptr.Of(uint32(25)),
 			},
 			{
-				Lima:      "bridged",
-				Interface: "def0",
+				Lima:       "user-v2",
+				Interface:  "def0",
+				Conditions: &networks.Conditions{Latency: "100ms"},
 			},
 		},
 		DNS: []net.IP{
@@ -655,6 +691,10 @@ func TestFillDefault(t *testing.T) {
 		CACertificates: CACertificates{
 			RemoveDefaults: ptr.Of(true),
 		},
+		ChecksumSignature: ChecksumSignature{
+			PublicKeys:      []string{"-----BEGIN PUBLIC KEY-----\nOVERRIDE\n-----END PUBLIC KEY-----\n"},
+			SignatureSuffix: ptr.Of(".sign"),
+		},
 		Rosetta: Rosetta{
 			Enabled: ptr.Of(false),
 			BinFmt:  ptr.Of(false),
@@ -683,6 +723,7 @@ func TestFillDefault(t *testing.T) {
 
 	expect.HostResolver.Hosts["default"] = dExpect.HostResolver.Hosts["default"]
 	expect.HostResolver.Hosts["MY.Host"] = dExpect.HostResolver.Hosts["host.lima.internal"]
+	expect.HostResolver.Domains["dev.example"] = dExpect.HostResolver.Domains["dev.example"]
 
 	// o.Mounts just makes dExpect.Mounts[0] writable because the Location matches
 	expect.Mounts = append(append([]Mount{}, dExpect.Mounts...), y.Mounts...)
@@ -701,6 +742,7 @@ func TestFillDefault(t *testing.T) {
 	// o.Networks[1] is overriding the dExpect.Networks[0].Lima entry for the "def0" interface
 	expect.Networks = append(append(dExpect.Networks, y.Networks...), o.Networks[0])
 	expect.Networks[0].Lima = o.Networks[1].Lima
+	expect.Networks[0].Conditions = o.Networks[1].Conditions
 
 	// Only highest prio DNS are retained
 	expect.DNS = slices.Clone(o.DNS)
@@ -716,6 +758,8 @@ func TestFillDefault(t *testing.T) {
 		"-----BEGIN CERTIFICATE-----\nYOUR-ORGS-TRUSTED-CA-CERT\n-----END CERTIFICATE-----\n",
 	}
 
+	expect.ChecksumSignature.Type = y.ChecksumSignature.Type
+
 	expect.Rosetta = Rosetta{
 		Enabled: ptr.Of(false),
 		BinFmt:  ptr.Of(false),
@@ -3,10 +3,12 @@ package limayaml
 import (
 	"net"
 
+	"github.com/lima-vm/lima/pkg/networks"
 	"github.com/opencontainers/go-digest"
 )
 
 type LimaYAML struct {
+	Base                  []string      `yaml:"base,omitempty" json:"base,omitempty" jsonschema:"nullable"`
 	MinimumLimaVersion    *string       `yaml:"minimumLimaVersion,omitempty" json:"minimumLimaVersion,omitempty" jsonschema:"nullable"`
 	VMType                *VMType       `yaml:"vmType,omitempty" json:"vmType,omitempty" jsonschema:"nullable"`
 	VMOpts                VMOpts        `yaml:"vmOpts,omitempty" json:"vmOpts,omitempty"`
@@ -36,18 +38,21 @@ type LimaYAML struct {
 	Message               string        `yaml:"message,omitempty" json:"message,omitempty"`
 	Networks              []Network     `yaml:"networks,omitempty" json:"networks,omitempty" jsonschema:"nullable"`
 	// `network` was deprecated in Lima v0.7.0, removed in Lima v0.14.0. Use `networks` instead.
-	Env          map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
-	Param        map[string]string `yaml:"param,omitempty" json:"param,omitempty"`
-	DNS          []net.IP          `yaml:"dns,omitempty" json:"dns,omitempty"`
-	HostResolver HostResolver      `yaml:"hostResolver,omitempty" json:"hostResolver,omitempty"`
+	Env          map[string]string      `yaml:"env,omitempty" json:"env,omitempty"`
+	Param        map[string]string      `yaml:"param,omitempty" json:"param,omitempty"`
+	ParamSchema  map[string]ParamSchema `yaml:"paramSchema,omitempty" json:"paramSchema,omitempty"`
+	DNS          []net.IP               `yaml:"dns,omitempty" json:"dns,omitempty"`
+	HostResolver HostResolver           `yaml:"hostResolver,omitempty" json:"hostResolver,omitempty"`
 	// `useHostResolver` was deprecated in Lima v0.8.1, removed in Lima v0.14.0. Use `hostResolver.enabled` instead.
-	PropagateProxyEnv    *bool          `yaml:"propagateProxyEnv,omitempty" json:"propagateProxyEnv,omitempty" jsonschema:"nullable"`
-	CACertificates       CACertificates `yaml:"caCerts,omitempty" json:"caCerts,omitempty"`
-	Rosetta              Rosetta        `yaml:"rosetta,omitempty" json:"rosetta,omitempty"`
-	Plain                *bool          `yaml:"plain,omitempty" json:"plain,omitempty" jsonschema:"nullable"`
-	TimeZone             *string        `yaml:"timezone,omitempty" json:"timezone,omitempty" jsonschema:"nullable"`
-	NestedVirtualization *bool          `yaml:"nestedVirtualization,omitempty" json:"nestedVirtualization,omitempty" jsonschema:"nullable"`
-	User                 User           `yaml:"user,omitempty" json:"user,omitempty"`
+	PropagateProxyEnv    *bool             `yaml:"propagateProxyEnv,omitempty" json:"propagateProxyEnv,omitempty" jsonschema:"nullable"`
+	CACertificates       CACertificates    `yaml:"caCerts,omitempty" json:"caCerts,omitempty"`
+	ChecksumSignature    ChecksumSignature `yaml:"checksumSignature,omitempty" json:"checksumSignature,omitempty"`
+	Rosetta              Rosetta           `yaml:"rosetta,omitempty" json:"rosetta,omitempty"`
+	Plain                *bool             `yaml:"plain,omitempty" json:"plain,omitempty" jsonschema:"nullable"`
+	TimeZone             *string           `yaml:"timezone,omitempty" json:"timezone,omitempty" jsonschema:"nullable"`
+	NestedVirtualization *bool             `yaml:"nestedVirtualization,omitempty" json:"nestedVirtualization,omitempty" jsonschema:"nullable"`
+	User                 User              `yaml:"user,omitempty" json:"user,omitempty"`
+	Metadata             Metadata          `yaml:"metadata,omitempty" json:"metadata,omitempty"`
 }
 
 type (
@@ -99,6 +104,13 @@ type QEMUOpts struct {
 	MinimumVersion *string `yaml:"minimumVersion,omitempty" json:"minimumVersion,omitempty" jsonschema:"nullable"`
 }
 
+// Metadata describes the template for `limactl template list`. It does not affect the instance.
+type Metadata struct {
+	Description *string  `yaml:"description,omitempty" json:"description,omitempty" jsonschema:"nullable"`
+	Tags        []string `yaml:"tags,omitempty" json:"tags,omitempty" jsonschema:"nullable"`
+	Deprecated  *bool    `yaml:"deprecated,omitempty" json:"deprecated,omitempty" jsonschema:"nullable"`
+}
+
 type Rosetta struct {
 	Enabled *bool `yaml:"enabled,omitempty" json:"enabled,omitempty" jsonschema:"nullable"`
 	BinFmt  *bool `yaml:"binfmt,omitempty" json:"binfmt,omitempty" jsonschema:"nullable"`
@@ -108,6 +120,22 @@ type File struct {
 	Location string        `yaml:"location" json:"location"` // REQUIRED
 	Arch     Arch          `yaml:"arch,omitempty" json:"arch,omitempty"`
 	Digest   digest.Digest `yaml:"digest,omitempty" json:"digest,omitempty"`
+	// Mirrors are the alternative URLs of the same file, tried in order when downloading from Location fails.
+	Mirrors []string `yaml:"mirrors,omitempty" json:"mirrors,omitempty" jsonschema:"nullable"`
+}
+
+type SignatureType = string
+
+const (
+	SignatureTypeGPG    SignatureType = "gpg"
+	SignatureTypeCosign SignatureType = "cosign"
+)
+
+// ChecksumSignature verifies the detached signatures of the checksum files referred by `digest: "sha256sums:URL"`.
+type ChecksumSignature struct {
+	Type            *SignatureType `yaml:"type,omitempty" json:"type,omitempty" jsonschema:"nullable"` // default: "gpg"
+	PublicKeys      []string       `yaml:"publicKeys,omitempty" json:"publicKeys,omitempty" jsonschema:"nullable"`
+	SignatureSuffix *string        `yaml:"signatureSuffix,omitempty" json:"signatureSuffix,omitempty" jsonschema:"nullable"` // default: ".gpg" for "gpg", ".sig" for "cosign"
 }
 
 type FileWithVMType struct {
@@ -277,12 +305,63 @@ type Network struct {
 	MACAddress string  `yaml:"macAddress,omitempty" json:"macAddress,omitempty"`
 	Interface  string  `yaml:"interface,omitempty" json:"interface,omitempty"`
 	Metric     *uint32 `yaml:"metric,omitempty" json:"metric,omitempty"`
+	// Conditions override the default link conditions of a "user-v2" network defined in networks.yaml.
+	Conditions *networks.Conditions `yaml:"conditions,omitempty" json:"conditions,omitempty" jsonschema:"nullable"`
 }
 
 type HostResolver struct {
 	Enabled *bool             `yaml:"enabled,omitempty" json:"enabled,omitempty" jsonschema:"nullable"`
 	IPv6    *bool             `yaml:"ipv6,omitempty" json:"ipv6,omitempty" jsonschema:"nullable"`
 	Hosts   map[string]string `yaml:"hosts,omitempty" json:"hosts,omitempty" jsonschema:"nullable"`
+	// Upstreams are the encrypted DNS servers that the queries are forwarded to, instead of the DNS servers of the host.
+	// "tls://HOST[:PORT]" for DNS-over-TLS, "https://HOST[:PORT]/PATH" for DNS-over-HTTPS.
+	Upstreams []string `yaml:"upstreams,omitempty" json:"upstreams,omitempty" jsonschema:"nullable"`
+	// Domains maps the domains to the DNS servers that the queries for the domain and its subdomains are forwarded to.
+	// A server is "IP[:PORT]", or an encrypted upstream.
+	Domains map[string][]string `yaml:"domains,omitempty" json:"domains,omitempty" jsonschema:"nullable"`
+	// CacheSize is the maximum number of the replies cached by the DNS server of the hostagent. 0 disables the cache.
+	CacheSize *int `yaml:"cacheSize,omitempty" json:"cacheSize,omitempty" jsonschema:"nullable"`
+	// QueryLog records the recent queries, so that they can be read through the hostagent API.
+	QueryLog *bool `yaml:"queryLog,omitempty" json:"queryLog,omitempty" jsonschema:"nullable"`
+	// Records are the static SRV, TXT, and MX records, that are served authoritatively.
+	Records []HostResolverRecord `yaml:"records,omitempty" json:"records,omitempty" jsonschema:"nullable"`
+}
+
+type HostResolverRecordType = string
+
+const (
+	HostResolverRecordSRV HostResolverRecordType = "SRV"
+	HostResolverRecordTXT HostResolverRecordType = "TXT"
+	HostResolverRecordMX  HostResolverRecordType = "MX"
+)
+
+type HostResolverRecord struct {
+	Name       string                 `yaml:"name" json:"name"`
+	Type       HostResolverRecordType `yaml:"type" json:"type" jsonschema:"enum=SRV,enum=TXT,enum=MX"`
+	Target     string                 `yaml:"target,omitempty" json:"target,omitempty"`         // SRV and MX
+	Port       uint16                 `yaml:"port,omitempty" json:"port,omitempty"`             // SRV
+	Priority   uint16                 `yaml:"priority,omitempty" json:"priority,omitempty"`     // SRV
+	Weight     uint16                 `yaml:"weight,omitempty" json:"weight,omitempty"`         // SRV
+	Preference uint16                 `yaml:"preference,omitempty" json:"preference,omitempty"` // MX
+	Text       string                 `yaml:"text,omitempty" json:"text,omitempty"`             // TXT
+}
+
+type ParamType = string
+
+const (
+	ParamTypeString ParamType = "string"
+	ParamTypeInt    ParamType = "int"
+	ParamTypeBool   ParamType = "bool"
+)
+
+// ParamSchema describes the value of a `param` key.
+type ParamSchema struct {
+	Type        *ParamType `yaml:"type,omitempty" json:"type,omitempty" jsonschema:"nullable"` // default: "string"
+	Default     *string    `yaml:"default,omitempty" json:"default,omitempty" jsonschema:"nullable"`
+	Enum        []string   `yaml:"enum,omitempty" json:"enum,omitempty" jsonschema:"nullable"`
+	Regex       *string    `yaml:"regex,omitempty" json:"regex,omitempty" jsonschema:"nullable"`
+	Description *string    `yaml:"description,omitempty" json:"description,omitempty" jsonschema:"nullable"`
+	Required    *bool      `yaml:"required,omitempty" json:"required,omitempty" jsonschema:"nullable"` // default: false
 }
 
 type CACertificates struct {
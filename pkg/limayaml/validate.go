@@ -1,14 +1,19 @@
 package limayaml
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -20,6 +25,7 @@ import (
 	"github.com/lima-vm/lima/pkg/osutil"
 	"github.com/lima-vm/lima/pkg/version"
 	"github.com/lima-vm/lima/pkg/version/versionutil"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
@@ -30,12 +36,25 @@ func validateFileObject(f File, fieldName string) error {
 		}
 		// f.Location does NOT need to be accessible, so we do NOT check os.Stat(f.Location)
 	}
+	for i, mirror := range f.Mirrors {
+		if !strings.Contains(mirror, "://") || strings.HasPrefix(mirror, "file://") {
+			return fmt.Errorf("field `%s.mirrors[%d]` must be a remote URL, got %q", fieldName, i, mirror)
+		}
+	}
 	switch f.Arch {
 	case X8664, AARCH64, ARMV7L, RISCV64:
 	default:
 		return fmt.Errorf("field `arch` must be %q, %q, %q, or %q; got %q", X8664, AARCH64, ARMV7L, RISCV64, f.Arch)
 	}
-	if f.Digest != "" {
+	if algo, location, ok := strings.Cut(f.Digest.String(), "sums:"); ok && !strings.Contains(algo, ":") {
+		// "sha256sums:URL" refers to a checksum file
+		if !digest.Algorithm(algo).Available() {
+			return fmt.Errorf("field `%s.digest` refers to a checksum file of an unavailable digest algorithm %q", fieldName, algo)
+		}
+		if location == "" {
+			return fmt.Errorf("field `%s.digest` must be \"%ssums:URL\", got %q", fieldName, algo, f.Digest)
+		}
+	} else if f.Digest != "" {
 		if !f.Digest.Algorithm().Available() {
 			return fmt.Errorf("field `%s.digest` refers to an unavailable digest algorithm", fieldName)
 		}
@@ -47,6 +66,9 @@ func validateFileObject(f File, fieldName string) error {
 }
 
 func Validate(y *LimaYAML, warn bool) error {
+	if len(y.Base) > 0 {
+		return errors.New("field `base` must be embedded into the template before validation")
+	}
 	if y.MinimumLimaVersion != nil {
 		if _, err := versionutil.Parse(*y.MinimumLimaVersion); err != nil {
 			return fmt.Errorf("field `minimumLimaVersion` must be a semvar value, got %q: %w", *y.MinimumLimaVersion, err)
@@ -350,6 +372,80 @@ func Validate(y *LimaYAML, warn bool) error {
 	if y.HostResolver.Enabled != nil && *y.HostResolver.Enabled && len(y.DNS) > 0 {
 		return errors.New("field `dns` must be empty when field `HostResolver.Enabled` is true")
 	}
+	for host := range y.HostResolver.Hosts {
+		if err := validateHostResolverHost(host); err != nil {
+			return fmt.Errorf("field `hostResolver.hosts` is invalid: %w", err)
+		}
+	}
+	if len(y.HostResolver.Upstreams) > 0 {
+		if y.HostResolver.Enabled != nil && !*y.HostResolver.Enabled {
+			return errors.New("field `hostResolver.upstreams` must be empty when field `hostResolver.enabled` is false")
+		}
+		// The DNS server of a user-v2 network does not forward the queries to the upstreams
+		if FirstUsernetIndex(y) != -1 {
+			return errors.New("field `hostResolver.upstreams` is not supported for instances with a user-v2 network")
+		}
+		for i, upstream := range y.HostResolver.Upstreams {
+			if err := validateDNSUpstream(upstream); err != nil {
+				return fmt.Errorf("field `hostResolver.upstreams[%d]` is invalid: %w", i, err)
+			}
+		}
+	}
+
+	if len(y.HostResolver.Records) > 0 {
+		if y.HostResolver.Enabled != nil && !*y.HostResolver.Enabled {
+			return errors.New("field `hostResolver.records` must be empty when field `hostResolver.enabled` is false")
+		}
+		if FirstUsernetIndex(y) != -1 {
+			return errors.New("field `hostResolver.records` is not supported for instances with a user-v2 network")
+		}
+		for i, record := range y.HostResolver.Records {
+			if err := validateHostResolverRecord(record); err != nil {
+				return fmt.Errorf("field `hostResolver.records[%d]` is invalid: %w", i, err)
+			}
+		}
+	}
+	if y.HostResolver.CacheSize != nil && *y.HostResolver.CacheSize < 0 {
+		return fmt.Errorf("field `hostResolver.cacheSize` must be 0 or greater, but is %d", *y.HostResolver.CacheSize)
+	}
+	if len(y.HostResolver.Domains) > 0 {
+		if y.HostResolver.Enabled != nil && !*y.HostResolver.Enabled {
+			return errors.New("field `hostResolver.domains` must be empty when field `hostResolver.enabled` is false")
+		}
+		if FirstUsernetIndex(y) != -1 {
+			return errors.New("field `hostResolver.domains` is not supported for instances with a user-v2 network")
+		}
+		for domain, servers := range y.HostResolver.Domains {
+			field := fmt.Sprintf("hostResolver.domains[%q]", domain)
+			if strings.Trim(domain, ".") == "" || strings.Contains(domain, "*") || strings.Contains(domain, "..") {
+				return fmt.Errorf("field `%s` must be a domain name, e.g., \"corp.example.com\"", field)
+			}
+			if len(servers) == 0 {
+				return fmt.Errorf("field `%s` must have at least one server", field)
+			}
+			for i, server := range servers {
+				if err := validateDNSServer(server); err != nil {
+					return fmt.Errorf("field `%s[%d]` is invalid: %w", field, i, err)
+				}
+			}
+		}
+	}
+
+	if y.ChecksumSignature.Type != nil {
+		switch *y.ChecksumSignature.Type {
+		case SignatureTypeGPG, SignatureTypeCosign:
+		default:
+			return fmt.Errorf("field `checksumSignature.type` must be %q or %q, got %q", SignatureTypeGPG, SignatureTypeCosign, *y.ChecksumSignature.Type)
+		}
+		for i, key := range y.ChecksumSignature.PublicKeys {
+			if err := validatePublicKey(*y.ChecksumSignature.Type, key); err != nil {
+				return fmt.Errorf("field `checksumSignature.publicKeys[%d]` is invalid: %w", i, err)
+			}
+		}
+	}
+	if y.ChecksumSignature.SignatureSuffix != nil && *y.ChecksumSignature.SignatureSuffix == "" {
+		return errors.New("field `checksumSignature.signatureSuffix` must not be empty")
+	}
 
 	if err := validateNetwork(y); err != nil {
 		return err
@@ -371,10 +467,75 @@ func Validate(y *LimaYAML, warn bool) error {
 			}
 		}
 	}
+	for param, schema := range y.ParamSchema {
+		if !validParamName.MatchString(param) {
+			return fmt.Errorf("field `paramSchema` key %q does not match regex %q", param, validParamName.String())
+		}
+		if schema.Type != nil {
+			switch *schema.Type {
+			case ParamTypeString, ParamTypeInt, ParamTypeBool:
+			default:
+				return fmt.Errorf("field `paramSchema.%s.type` must be %q, %q, or %q; got %q",
+					param, ParamTypeString, ParamTypeInt, ParamTypeBool, *schema.Type)
+			}
+		}
+		if schema.Regex != nil {
+			if _, err := regexp.Compile(*schema.Regex); err != nil {
+				return fmt.Errorf("field `paramSchema.%s.regex` is invalid: %w", param, err)
+			}
+		}
+		if schema.Default != nil {
+			if err := ValidateParamValue(schema, *schema.Default); err != nil {
+				return fmt.Errorf("field `paramSchema.%s.default` is invalid: %w", param, err)
+			}
+		}
+		value, ok := y.Param[param]
+		if !ok || value == "" {
+			if schema.Required != nil && *schema.Required {
+				return fmt.Errorf("param %q is required", param)
+			}
+			continue
+		}
+		if err := ValidateParamValue(schema, value); err != nil {
+			return fmt.Errorf("param %q is invalid: %w", param, err)
+		}
+	}
 
 	return nil
 }
 
+// ValidateParamValue validates the value of a param against its schema.
+// The regex of the schema has to match the whole value.
+func ValidateParamValue(schema ParamSchema, value string) error {
+	typ := ParamTypeString
+	if schema.Type != nil {
+		typ = *schema.Type
+	}
+	switch typ {
+	case ParamTypeInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("must be an integer, got %q", value)
+		}
+	case ParamTypeBool:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("must be a boolean, got %q", value)
+		}
+	}
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		return fmt.Errorf("must be one of %q, got %q", schema.Enum, value)
+	}
+	if schema.Regex != nil {
+		re, err := regexp.Compile("^(?:" + *schema.Regex + ")$")
+		if err != nil {
+			return err
+		}
+		if !re.MatchString(value) {
+			return fmt.Errorf("must match regex %q, got %q", *schema.Regex, value)
+		}
+	}
+	return nil
+}
+
 func validateNetwork(y *LimaYAML) error {
 	interfaceName := make(map[string]int)
 	for i, nw := range y.Networks {
@@ -401,6 +562,14 @@ func validateNetwork(y *LimaYAML) error {
 			if nw.VZNAT != nil && *nw.VZNAT {
 				return fmt.Errorf("field `%s.lima` and field `%s.vzNAT` are mutually exclusive", field, field)
 			}
+			if nw.Conditions != nil {
+				if !usernet {
+					return fmt.Errorf("field `%s.conditions` is only supported for %q networks", field, networks.ModeUserV2)
+				}
+				if _, err := nw.Conditions.Parse(); err != nil {
+					return fmt.Errorf("field `%s.conditions` error: %w", field, err)
+				}
+			}
 		case nw.Socket != "":
 			if nw.VZNAT != nil && *nw.VZNAT {
 				return fmt.Errorf("field `%s.socket` and field `%s.vzNAT` are mutually exclusive", field, field)
@@ -423,6 +592,9 @@ func validateNetwork(y *LimaYAML) error {
 		default:
 			return fmt.Errorf("field `%s.lima` or  field `%s.socket must be set", field, field)
 		}
+		if nw.Conditions != nil && nw.Lima == "" {
+			return fmt.Errorf("field `%s.conditions` requires field `%s.lima` to be set", field, field)
+		}
 		if nw.MACAddress != "" {
 			hw, err := net.ParseMAC(nw.MACAddress)
 			if err != nil {
@@ -450,10 +622,17 @@ func validateNetwork(y *LimaYAML) error {
 	return nil
 }
 
-// ValidateParamIsUsed checks if the keys in the `param` field are used in any script, probe, copyToHost, or portForward.
+// ValidateParamIsUsed checks if the keys in the `param` and `paramSchema` fields are used in any script, probe, copyToHost, or portForward.
 // It should be called before the `y` parameter is passed to FillDefault() that execute template.
 func ValidateParamIsUsed(y *LimaYAML) error {
+	fields := make(map[string]string)
+	for key := range y.ParamSchema {
+		fields[key] = "paramSchema"
+	}
 	for key := range y.Param {
+		fields[key] = "param"
+	}
+	for key, field := range fields {
 		re, err := regexp.Compile(`{{[^}]*\.Param\.` + key + `[^}]*}}|\bPARAM_` + key + `\b`)
 		if err != nil {
 			return fmt.Errorf("field to compile regexp for key %q: %w", key, err)
@@ -494,7 +673,7 @@ func ValidateParamIsUsed(y *LimaYAML) error {
 			}
 		}
 		if !keyIsUsed {
-			return fmt.Errorf("field `param` key %q is not used in any provision, probe, copyToHost, or portForward", key)
+			return fmt.Errorf("field `%s` key %q is not used in any provision, probe, copyToHost, or portForward", field, key)
 		}
 	}
 	return nil
@@ -531,3 +710,115 @@ func warnExperimental(y *LimaYAML) {
 		logrus.Warn("`mountInotify` is experimental")
 	}
 }
+
+// validatePublicKey validates a public key of `checksumSignature.publicKeys`:
+// an armored OpenPGP public key for "gpg", or a PEM public key for "cosign".
+func validatePublicKey(signatureType SignatureType, key string) error {
+	switch signatureType {
+	case SignatureTypeGPG:
+		if !strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+			return errors.New("must be an armored OpenPGP public key (\"-----BEGIN PGP PUBLIC KEY BLOCK-----\")")
+		}
+	case SignatureTypeCosign:
+		block, _ := pem.Decode([]byte(key))
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return errors.New("must be a PEM public key (\"-----BEGIN PUBLIC KEY-----\")")
+		}
+		if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateHostResolverHost validates a name of `hostResolver.hosts`.
+// A wildcard is only allowed as the first label ("*.example.com"), and a leading dot denotes a suffix (".example.com").
+func validateHostResolverHost(host string) error {
+	name := strings.TrimPrefix(strings.TrimPrefix(host, "*"), ".")
+	if name == "" {
+		return fmt.Errorf("host %q must have a domain", host)
+	}
+	if strings.Contains(name, "*") || (strings.HasPrefix(host, "*") && !strings.HasPrefix(host, "*.")) {
+		return fmt.Errorf("host %q may only have a wildcard as the first label, e.g., \"*.example.com\"", host)
+	}
+	if strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return fmt.Errorf("host %q must not have an empty label", host)
+	}
+	return nil
+}
+
+func validateHostResolverRecord(record HostResolverRecord) error {
+	if strings.Trim(record.Name, ".") == "" || strings.Contains(record.Name, "*") {
+		return fmt.Errorf("field `name` must be a domain name, but is %q", record.Name)
+	}
+	switch record.Type {
+	case HostResolverRecordSRV:
+		if record.Target == "" {
+			return errors.New("field `target` must be set for a SRV record")
+		}
+		if record.Port == 0 {
+			return errors.New("field `port` must be set for a SRV record")
+		}
+		if record.Preference != 0 || record.Text != "" {
+			return errors.New("fields `preference` and `text` must be empty for a SRV record")
+		}
+	case HostResolverRecordMX:
+		if record.Target == "" {
+			return errors.New("field `target` must be set for a MX record")
+		}
+		if record.Port != 0 || record.Priority != 0 || record.Weight != 0 || record.Text != "" {
+			return errors.New("fields `port`, `priority`, `weight`, and `text` must be empty for a MX record")
+		}
+	case HostResolverRecordTXT:
+		if record.Target != "" || record.Port != 0 || record.Priority != 0 || record.Weight != 0 || record.Preference != 0 {
+			return errors.New("fields `target`, `port`, `priority`, `weight`, and `preference` must be empty for a TXT record")
+		}
+	default:
+		return fmt.Errorf("field `type` must be %q, %q, or %q, but is %q",
+			HostResolverRecordSRV, HostResolverRecordTXT, HostResolverRecordMX, record.Type)
+	}
+	return nil
+}
+
+// validateDNSServer validates a plain "IP[:PORT]" DNS server, or an encrypted upstream.
+func validateDNSServer(server string) error {
+	if strings.Contains(server, "://") {
+		return validateDNSUpstream(server)
+	}
+	if net.ParseIP(server) != nil {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("server %q must be an \"IP[:PORT]\", \"tls://HOST[:PORT]\", or \"https://HOST[:PORT]/PATH\"", server)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("server %q has an invalid port", server)
+	}
+	return nil
+}
+
+func validateDNSUpstream(upstream string) error {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "tls":
+		if u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("DNS-over-TLS upstream %q must not have a path", upstream)
+		}
+	case "https":
+	default:
+		return fmt.Errorf("upstream %q must be a \"tls://HOST[:PORT]\" or \"https://HOST[:PORT]/PATH\" URL", upstream)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("upstream %q must have a host", upstream)
+	}
+	if port := u.Port(); port != "" {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("upstream %q has an invalid port", upstream)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"testing"
 
+	"github.com/lima-vm/lima/pkg/ptr"
 	"gotest.tools/v3/assert"
 )
 
@@ -15,6 +16,13 @@ func TestValidateEmpty(t *testing.T) {
 	assert.Error(t, err, "field `images` must be set")
 }
 
+func TestValidateBase(t *testing.T) {
+	y, err := Load([]byte(`base: ["template://ubuntu"]`+"\nimages: [{location: /}]"), "lima.yaml")
+	assert.NilError(t, err)
+	err = Validate(y, false)
+	assert.Error(t, err, "field `base` must be embedded into the template before validation")
+}
+
 // Note: can't embed symbolic links, use "os"
 
 func TestValidateDefault(t *testing.T) {
@@ -186,3 +194,228 @@ func TestValidateParamIsUsed(t *testing.T) {
 		assert.Error(t, err, "field `param` key \"rootFul\" is not used in any provision, probe, copyToHost, or portForward")
 	}
 }
+
+func TestValidateParamSchema(t *testing.T) {
+	images := `images: [{"location": "/"}]`
+	provision := `provision: [{"script": "echo $PARAM_size $PARAM_distro"}]`
+	load := func(s string) *LimaYAML {
+		y, err := Load([]byte(s+"\n"+provision+"\n"+images), "lima.yaml")
+		assert.NilError(t, err)
+		return y
+	}
+	schema := `paramSchema:
+  size: {type: int, default: "2"}
+  distro: {enum: [ubuntu, debian], required: true}`
+
+	y := load(schema)
+	assert.Error(t, Validate(y, false), "param \"distro\" is required")
+
+	y = load(schema + "\nparam: {distro: debian}")
+	assert.NilError(t, Validate(y, false))
+	// The default of the schema is used for the param that is not set
+	assert.Equal(t, y.Param["size"], "2")
+	assert.Equal(t, *y.ParamSchema["distro"].Type, ParamTypeString)
+
+	y = load(schema + "\nparam: {distro: fedora}")
+	assert.Error(t, Validate(y, false), "param \"distro\" is invalid: must be one of [\"ubuntu\" \"debian\"], got \"fedora\"")
+
+	y = load(schema + "\nparam: {distro: debian, size: two}")
+	assert.Error(t, Validate(y, false), "param \"size\" is invalid: must be an integer, got \"two\"")
+
+	y = load(`paramSchema: {size: {type: float}}`)
+	assert.Error(t, Validate(y, false), "field `paramSchema.size.type` must be \"string\", \"int\", or \"bool\"; got \"float\"")
+
+	y = load(`paramSchema: {size: {type: bool, default: "yes"}}`)
+	assert.Error(t, Validate(y, false), "field `paramSchema.size.default` is invalid: must be a boolean, got \"yes\"")
+
+	// The regex has to match the whole value
+	y = load(`paramSchema: {distro: {regex: "[a-z]+"}}` + "\nparam: {distro: Ubuntu}")
+	assert.Error(t, Validate(y, false), "param \"distro\" is invalid: must match regex \"[a-z]+\", got \"Ubuntu\"")
+	y = load(`paramSchema: {distro: {regex: "[a-z]+"}}` + "\nparam: {distro: ubuntu}")
+	assert.NilError(t, Validate(y, false))
+
+	_, err := Load([]byte(`paramSchema: {unused: {}}`), "lima.yaml")
+	assert.Error(t, err, "field `paramSchema` key \"unused\" is not used in any provision, probe, copyToHost, or portForward")
+}
+
+func TestValidateHostResolverUpstreams(t *testing.T) {
+	images := `images: [{"location": "/"}]`
+	tests := []struct {
+		upstreams string
+		expected  string
+	}{
+		{`upstreams: ["tls://1.1.1.1", "tls://dns.example.com:853", "https://dns.example.com/dns-query"]`, ""},
+		{`upstreams: ["1.1.1.1"]`, "field `hostResolver.upstreams[0]` is invalid: upstream \"1.1.1.1\" must be a \"tls://HOST[:PORT]\" or \"https://HOST[:PORT]/PATH\" URL"},
+		{`upstreams: ["tls://1.1.1.1", "udp://1.1.1.1"]`, "field `hostResolver.upstreams[1]` is invalid: upstream \"udp://1.1.1.1\" must be a \"tls://HOST[:PORT]\" or \"https://HOST[:PORT]/PATH\" URL"},
+		{`upstreams: ["tls://1.1.1.1/dns-query"]`, "field `hostResolver.upstreams[0]` is invalid: DNS-over-TLS upstream \"tls://1.1.1.1/dns-query\" must not have a path"},
+		{`upstreams: ["https:///dns-query"]`, "field `hostResolver.upstreams[0]` is invalid: upstream \"https:///dns-query\" must have a host"},
+		{`upstreams: ["tls://1.1.1.1:0"]`, "field `hostResolver.upstreams[0]` is invalid: upstream \"tls://1.1.1.1:0\" has an invalid port"},
+		{"enabled: false\n  upstreams: [\"tls://1.1.1.1\"]", "field `hostResolver.upstreams` must be empty when field `hostResolver.enabled` is false"},
+	}
+	for _, tc := range tests {
+		y, err := Load([]byte("hostResolver:\n  "+tc.upstreams+"\n"+images), "lima.yaml")
+		assert.NilError(t, err)
+		err = Validate(y, false)
+		if tc.expected == "" {
+			assert.NilError(t, err)
+		} else {
+			assert.Error(t, err, tc.expected)
+		}
+	}
+
+	y, err := Load([]byte("hostResolver: {upstreams: [\"tls://1.1.1.1\"]}\nnetworks: [{lima: user-v2}]\n"+images), "lima.yaml")
+	assert.NilError(t, err)
+	err = Validate(y, false)
+	assert.Error(t, err, "field `hostResolver.upstreams` is not supported for instances with a user-v2 network")
+}
+
+func TestValidateHostResolverHosts(t *testing.T) {
+	images := `images: [{"location": "/"}]`
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"*.dev.test", ""},
+		{".dev.test", ""},
+		{"*.test", ""},
+		{"*", "field `hostResolver.hosts` is invalid: host \"*\" must have a domain"},
+		{"*dev.test", "field `hostResolver.hosts` is invalid: host \"*dev.test\" may only have a wildcard as the first label, e.g., \"*.example.com\""},
+		{"api.*.test", "field `hostResolver.hosts` is invalid: host \"api.*.test\" may only have a wildcard as the first label, e.g., \"*.example.com\""},
+		{"*..test", "field `hostResolver.hosts` is invalid: host \"*..test\" must not have an empty label"},
+	}
+	for _, tc := range tests {
+		y, err := Load([]byte("hostResolver:\n  hosts:\n    \""+tc.host+"\": host.lima.internal\n"+images), "lima.yaml")
+		assert.NilError(t, err)
+		err = Validate(y, false)
+		if tc.expected == "" {
+			assert.NilError(t, err)
+		} else {
+			assert.Error(t, err, tc.expected)
+		}
+	}
+}
+
+func TestValidateHostResolverDomains(t *testing.T) {
+	images := `images: [{"location": "/"}]`
+	tests := []struct {
+		domains  string
+		expected string
+	}{
+		{`domains: {corp.example: ["10.0.0.53", "10.0.0.54:5353", "[fd00::53]:53", "tls://dns.corp.example"]}`, ""},
+		{`domains: {corp.example: []}`, "field `hostResolver.domains[\"corp.example\"]` must have at least one server"},
+		{`domains: {"*.corp.example": ["10.0.0.53"]}`, "field `hostResolver.domains[\"*.corp.example\"]` must be a domain name, e.g., \"corp.example.com\""},
+		{`domains: {corp.example: ["dns.corp.example"]}`, "field `hostResolver.domains[\"corp.example\"][0]` is invalid: server \"dns.corp.example\" must be an \"IP[:PORT]\", \"tls://HOST[:PORT]\", or \"https://HOST[:PORT]/PATH\""},
+		{`domains: {corp.example: ["10.0.0.53:0"]}`, "field `hostResolver.domains[\"corp.example\"][0]` is invalid: server \"10.0.0.53:0\" has an invalid port"},
+		{`domains: {corp.example: ["udp://10.0.0.53"]}`, "field `hostResolver.domains[\"corp.example\"][0]` is invalid: upstream \"udp://10.0.0.53\" must be a \"tls://HOST[:PORT]\" or \"https://HOST[:PORT]/PATH\" URL"},
+		{"enabled: false\n  domains: {corp.example: [\"10.0.0.53\"]}", "field `hostResolver.domains` must be empty when field `hostResolver.enabled` is false"},
+	}
+	for _, tc := range tests {
+		y, err := Load([]byte("hostResolver:\n  "+tc.domains+"\n"+images), "lima.yaml")
+		assert.NilError(t, err)
+		err = Validate(y, false)
+		if tc.expected == "" {
+			assert.NilError(t, err)
+		} else {
+			assert.Error(t, err, tc.expected)
+		}
+	}
+}
+
+func TestValidateHostResolverRecords(t *testing.T) {
+	images := `images: [{"location": "/"}]`
+	tests := []struct {
+		record   string
+		expected string
+	}{
+		{`{name: _http._tcp.svc.test, type: SRV, target: web.svc.test, port: 8080, priority: 10, weight: 5}`, ""},
+		{`{name: svc.test, type: MX, target: mail.svc.test, preference: 10}`, ""},
+		{`{name: svc.test, type: TXT, text: "v=spf1 -all"}`, ""},
+		{`{name: "", type: TXT, text: foo}`, "field `hostResolver.records[0]` is invalid: field `name` must be a domain name, but is \"\""},
+		{`{name: svc.test, type: A, target: 192.168.5.15}`, "field `hostResolver.records[0]` is invalid: field `type` must be \"SRV\", \"TXT\", or \"MX\", but is \"A\""},
+		{`{name: _http._tcp.svc.test, type: SRV, target: web.svc.test}`, "field `hostResolver.records[0]` is invalid: field `port` must be set for a SRV record"},
+		{`{name: svc.test, type: MX, preference: 10}`, "field `hostResolver.records[0]` is invalid: field `target` must be set for a MX record"},
+		{`{name: svc.test, type: TXT, target: foo}`, "field `hostResolver.records[0]` is invalid: fields `target`, `port`, `priority`, `weight`, and `preference` must be empty for a TXT record"},
+	}
+	for _, tc := range tests {
+		y, err := Load([]byte("hostResolver:\n  records: ["+tc.record+"]\n"+images), "lima.yaml")
+		assert.NilError(t, err)
+		err = Validate(y, false)
+		if tc.expected == "" {
+			assert.NilError(t, err)
+		} else {
+			assert.Error(t, err, tc.expected)
+		}
+	}
+}
+
+func TestValidateImageMirrors(t *testing.T) {
+	tests := []struct {
+		mirror   string
+		expected string
+	}{
+		{"https://mirror.example.com/image.img", ""},
+		{"/tmp/image.img", "field `images[0].mirrors[0]` must be a remote URL, got \"/tmp/image.img\""},
+		{"file:///tmp/image.img", "field `images[0].mirrors[0]` must be a remote URL, got \"file:///tmp/image.img\""},
+	}
+	for _, tc := range tests {
+		y, err := Load([]byte(`images: [{"location": "https://example.com/image.img", "mirrors": ["`+tc.mirror+`"]}]`), "lima.yaml")
+		assert.NilError(t, err)
+		err = Validate(y, false)
+		if tc.expected == "" {
+			assert.NilError(t, err)
+		} else {
+			assert.Error(t, err, tc.expected)
+		}
+	}
+}
+
+func TestValidateChecksumSignature(t *testing.T) {
+	const pgpKey = "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmDMEZ...\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	const cosignKey = "-----BEGIN PUBLIC KEY-----\nMFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEC8veMembfBOdNesPG7X59j4JGo78\nsV1fbpzXKPEz/iVetU8lUGsdIoJPMNHQOJqGDYdP/QMpG95B2m5mIUZviQ==\n-----END PUBLIC KEY-----\n"
+	tests := []struct {
+		name      string
+		signature ChecksumSignature
+		digest    string
+		expected  string
+	}{
+		{"gpg", ChecksumSignature{Type: ptr.Of(SignatureTypeGPG), PublicKeys: []string{pgpKey}}, "sha256sums:https://example.com/SHA256SUMS", ""},
+		{"cosign", ChecksumSignature{Type: ptr.Of(SignatureTypeCosign), PublicKeys: []string{cosignKey}}, "sha512sums:/tmp/SHA512SUMS", ""},
+		{
+			"unknown type", ChecksumSignature{Type: ptr.Of("minisign")}, "",
+			"field `checksumSignature.type` must be \"gpg\" or \"cosign\", got \"minisign\"",
+		},
+		{
+			"gpg with PEM key", ChecksumSignature{Type: ptr.Of(SignatureTypeGPG), PublicKeys: []string{cosignKey}}, "",
+			"field `checksumSignature.publicKeys[0]` is invalid: must be an armored OpenPGP public key (\"-----BEGIN PGP PUBLIC KEY BLOCK-----\")",
+		},
+		{
+			"cosign with PGP key", ChecksumSignature{Type: ptr.Of(SignatureTypeCosign), PublicKeys: []string{pgpKey}}, "",
+			"field `checksumSignature.publicKeys[0]` is invalid: must be a PEM public key (\"-----BEGIN PUBLIC KEY-----\")",
+		},
+		{
+			"empty suffix", ChecksumSignature{SignatureSuffix: ptr.Of("")}, "",
+			"field `checksumSignature.signatureSuffix` must not be empty",
+		},
+		{
+			"unavailable algorithm", ChecksumSignature{}, "md5sums:https://example.com/MD5SUMS",
+			"field `images[0].digest` refers to a checksum file of an unavailable digest algorithm \"md5\"",
+		},
+		{
+			"empty location", ChecksumSignature{}, "sha256sums:",
+			"field `images[0].digest` must be \"sha256sums:URL\", got \"sha256sums:\"",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			y, err := Load([]byte(`images: [{"location": "https://example.com/image.img", "digest": "`+tc.digest+`"}]`), "lima.yaml")
+			assert.NilError(t, err)
+			y.ChecksumSignature = tc.signature
+			err = Validate(y, false)
+			if tc.expected == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.Error(t, err, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package networks
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Conditions emulates an impaired link between an instance and a "user-v2" network.
+// Each condition applies to both directions of the link. The zero value emulates a perfect link.
+type Conditions struct {
+	Latency   string  `yaml:"latency,omitempty" json:"latency,omitempty"`     // added delay, e.g. "100ms"
+	Jitter    string  `yaml:"jitter,omitempty" json:"jitter,omitempty"`       // random variation of the delay, e.g. "10ms"
+	Loss      float64 `yaml:"loss,omitempty" json:"loss,omitempty"`           // percentage of dropped frames, e.g. 0.5
+	Bandwidth string  `yaml:"bandwidth,omitempty" json:"bandwidth,omitempty"` // e.g. "512kbit", "10Mbit", "1Gbit"
+}
+
+// ParsedConditions holds the parsed values of Conditions.
+type ParsedConditions struct {
+	Latency   time.Duration
+	Jitter    time.Duration
+	Loss      float64 // percentage
+	Bandwidth uint64  // bits per second; 0 means unlimited
+}
+
+// IsZero returns true when the conditions do not impair the link.
+func (p ParsedConditions) IsZero() bool {
+	return p == ParsedConditions{}
+}
+
+// Parse parses and validates the conditions.
+func (c *Conditions) Parse() (ParsedConditions, error) {
+	var p ParsedConditions
+	var err error
+	if c == nil {
+		return p, nil
+	}
+	if c.Latency != "" {
+		if p.Latency, err = time.ParseDuration(c.Latency); err != nil {
+			return p, fmt.Errorf("field `latency` is invalid: %w", err)
+		}
+		if p.Latency < 0 {
+			return p, fmt.Errorf("field `latency` must not be negative, got %q", c.Latency)
+		}
+	}
+	if c.Jitter != "" {
+		if p.Jitter, err = time.ParseDuration(c.Jitter); err != nil {
+			return p, fmt.Errorf("field `jitter` is invalid: %w", err)
+		}
+		if p.Jitter < 0 {
+			return p, fmt.Errorf("field `jitter` must not be negative, got %q", c.Jitter)
+		}
+	}
+	if math.IsNaN(c.Loss) || c.Loss < 0 || c.Loss > 100 {
+		return p, fmt.Errorf("field `loss` must be a percentage between 0 and 100, got %v", c.Loss)
+	}
+	p.Loss = c.Loss
+	if c.Bandwidth != "" {
+		if p.Bandwidth, err = ParseBandwidth(c.Bandwidth); err != nil {
+			return p, fmt.Errorf("field `bandwidth` is invalid: %w", err)
+		}
+	}
+	return p, nil
+}
+
+var bandwidthUnits = []struct {
+	suffix string
+	factor float64
+}{
+	// longest suffixes first
+	{"gbit", 1e9},
+	{"mbit", 1e6},
+	{"kbit", 1e3},
+	{"bit", 1},
+}
+
+// ParseBandwidth parses a bandwidth such as "512kbit", "10Mbit", or "1.5Gbit" into bits per second.
+// The units are case-insensitive, and use powers of 1000.
+func ParseBandwidth(s string) (uint64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	for _, unit := range bandwidthUnits {
+		num, ok := strings.CutSuffix(lower, unit.suffix)
+		if !ok {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid bandwidth %q: %w", s, err)
+		}
+		bps := f * unit.factor
+		if math.IsNaN(bps) || bps < 1 || bps > math.MaxInt64 {
+			return 0, fmt.Errorf("bandwidth %q is out of range", s)
+		}
+		return uint64(bps), nil
+	}
+	return 0, fmt.Errorf("invalid bandwidth %q, expected a number followed by \"bit\", \"kbit\", \"Mbit\", or \"Gbit\"", s)
+}
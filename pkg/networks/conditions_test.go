@@ -0,0 +1,47 @@
+package networks
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestConditionsParse(t *testing.T) {
+	var nilConditions *Conditions
+	p, err := nilConditions.Parse()
+	assert.NilError(t, err)
+	assert.Assert(t, p.IsZero())
+
+	p, err = (&Conditions{Latency: "100ms", Jitter: "10ms", Loss: 0.5, Bandwidth: "10Mbit"}).Parse()
+	assert.NilError(t, err)
+	assert.Equal(t, p, ParsedConditions{Latency: 100 * time.Millisecond, Jitter: 10 * time.Millisecond, Loss: 0.5, Bandwidth: 10_000_000})
+	assert.Assert(t, !p.IsZero())
+
+	_, err = (&Conditions{Latency: "100"}).Parse()
+	assert.ErrorContains(t, err, "field `latency` is invalid")
+	_, err = (&Conditions{Jitter: "-1ms"}).Parse()
+	assert.ErrorContains(t, err, "field `jitter` must not be negative")
+	_, err = (&Conditions{Loss: -1}).Parse()
+	assert.ErrorContains(t, err, "field `loss` must be a percentage")
+	_, err = (&Conditions{Bandwidth: "10MB"}).Parse()
+	assert.ErrorContains(t, err, "field `bandwidth` is invalid")
+}
+
+func TestParseBandwidth(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"1bit":     1,
+		"512kbit":  512_000,
+		"10Mbit":   10_000_000,
+		"1.5Gbit":  1_500_000_000,
+		" 2 mbit ": 2_000_000,
+	} {
+		bps, err := ParseBandwidth(s)
+		assert.NilError(t, err, s)
+		assert.Equal(t, bps, expected, s)
+	}
+	for _, s := range []string{"", "10", "Mbit", "10Mbps", "0bit", "-1kbit", "0.1bit"} {
+		_, err := ParseBandwidth(s)
+		assert.Assert(t, err != nil, s)
+	}
+}
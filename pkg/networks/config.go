@@ -2,17 +2,20 @@ package networks
 
 import (
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"sync"
 
 	"github.com/goccy/go-yaml"
 	"github.com/lima-vm/lima/pkg/store/dirnames"
 	"github.com/lima-vm/lima/pkg/store/filenames"
 	"github.com/lima-vm/lima/pkg/textutil"
+	"github.com/lima-vm/lima/pkg/yqutil"
 	"github.com/sirupsen/logrus"
 )
 
@@ -156,6 +159,73 @@ func LoadConfig() (Config, error) {
 	return cache.cfg, cache.err
 }
 
+// AddNetwork adds the network definition to the _config/networks.yaml file.
+// It fails if a network with the same name is already defined.
+func AddNetwork(name string, nw Network) error {
+	loadCache()
+	if cache.err != nil {
+		return cache.err
+	}
+	if _, ok := cache.cfg.Networks[name]; ok {
+		return fmt.Errorf("network %q already exists", name)
+	}
+	j, err := json.Marshal(nw)
+	if err != nil {
+		return err
+	}
+	return editConfig(fmt.Sprintf(".networks.%q = %s", name, j))
+}
+
+// RemoveNetwork removes the network definition from the _config/networks.yaml file.
+func RemoveNetwork(name string) error {
+	loadCache()
+	if cache.err != nil {
+		return cache.err
+	}
+	if err := cache.cfg.Check(name); err != nil {
+		return err
+	}
+	return editConfig(fmt.Sprintf("del(.networks.%q)", name))
+}
+
+// editConfig applies the yq expression to the _config/networks.yaml file.
+// The added and modified networks are validated before the file is written, and the modified config replaces the cached config.
+func editConfig(expression string) error {
+	cfgFile, err := ConfigFile()
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(cfgFile)
+	if err != nil {
+		return err
+	}
+	b, err = yqutil.EvaluateExpression(expression, b)
+	if err != nil {
+		return err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return fmt.Errorf("cannot parse modified %q: %w", cfgFile, err)
+	}
+	cfg, err = fillDefaults(cfg)
+	if err != nil {
+		return err
+	}
+	// Only the added and modified networks are validated, so that an invalid network definition
+	// that was written before the validation was added does not prevent editing the other networks.
+	if err := cfg.validateNetworks(func(name string) bool {
+		nw, ok := cache.cfg.Networks[name]
+		return !ok || !reflect.DeepEqual(nw, cfg.Networks[name])
+	}); err != nil {
+		return err
+	}
+	if err := os.WriteFile(cfgFile, b, 0o644); err != nil {
+		return err
+	}
+	cache.cfg = cfg
+	return nil
+}
+
 // Sock returns a socket_vmnet socket.
 func Sock(name string) (string, error) {
 	loadCache()
@@ -2,8 +2,12 @@ package networks
 
 import (
 	"net"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/lima-vm/lima/pkg/yqutil"
 	"gotest.tools/v3/assert"
 )
 
@@ -48,3 +52,54 @@ func TestFillDefaultWithV2AndGateway(t *testing.T) {
 	assert.DeepEqual(t, userNet.Gateway, net.ParseIP("192.168.105.1"))
 	assert.DeepEqual(t, userNet.DHCPEnd, net.IP{})
 }
+
+func TestAddAndRemoveNetwork(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+
+	err := AddNetwork("foo", Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1"), NetMask: net.ParseIP("255.255.255.0")})
+	assert.NilError(t, err)
+	cfg, err := LoadConfig()
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.Networks["foo"].Mode, ModeUserV2)
+	assert.Assert(t, cfg.Networks["foo"].Gateway.Equal(net.ParseIP("192.168.42.1")))
+
+	err = AddNetwork("foo", Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.43.1")})
+	assert.ErrorContains(t, err, "already exists")
+
+	err = AddNetwork("bar", Network{Mode: ModeShared})
+	assert.ErrorContains(t, err, "field `gateway` must be an IPv4 address")
+
+	assert.NilError(t, RemoveNetwork("foo"))
+	cfg, err = LoadConfig()
+	assert.NilError(t, err)
+	assert.ErrorContains(t, cfg.Check("foo"), "not defined")
+	assert.NilError(t, cfg.Check(ModeShared))
+
+	assert.ErrorContains(t, RemoveNetwork("foo"), "not defined")
+}
+
+func TestEditConfigWithInvalidNetwork(t *testing.T) {
+	t.Setenv("LIMA_HOME", t.TempDir())
+	cache.Once = sync.Once{}
+	t.Cleanup(func() { cache.Once = sync.Once{} })
+
+	// A user-v2 network with `dhcpEnd` was accepted before the network definitions were validated
+	cfgFile, err := ConfigFile()
+	assert.NilError(t, err)
+	b, err := defaultConfigBytes()
+	assert.NilError(t, err)
+	b, err = yqutil.EvaluateExpression(`.networks.legacy = {"mode": "user-v2", "gateway": "192.168.42.1", "dhcpEnd": "192.168.42.254"}`, b)
+	assert.NilError(t, err)
+	assert.NilError(t, os.MkdirAll(filepath.Dir(cfgFile), 0o755))
+	assert.NilError(t, os.WriteFile(cfgFile, b, 0o644))
+
+	cfg, err := LoadConfig()
+	assert.NilError(t, err)
+	assert.NilError(t, cfg.Check("legacy"))
+
+	assert.NilError(t, AddNetwork("foo", Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.43.1"), NetMask: net.ParseIP("255.255.255.0")}))
+	err = AddNetwork("bar", Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.100"), NetMask: net.ParseIP("255.255.255.0")})
+	assert.ErrorContains(t, err, "overlaps with subnet 192.168.42.0/24 of network \"legacy\"")
+	assert.NilError(t, RemoveNetwork("foo"))
+	assert.NilError(t, RemoveNetwork("legacy"))
+}
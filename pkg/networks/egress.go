@@ -0,0 +1,132 @@
+package networks
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+const (
+	EgressAllow = "allow"
+	EgressDeny  = "deny"
+)
+
+// EgressPolicy restricts the connections from the instances of a "user-v2" network to the outside.
+// The rules are evaluated in order, and the first matching rule decides.
+// Connections that do not match any rule are decided by Default.
+type EgressPolicy struct {
+	Default string       `yaml:"default,omitempty" json:"default,omitempty"` // "allow" (default) or "deny"
+	Rules   []EgressRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+}
+
+// EgressRule matches a connection when all the specified fields match.
+// A rule without any field except Action matches all connections.
+type EgressRule struct {
+	Action string `yaml:"action" json:"action"` // "allow" or "deny"
+	// CIDR is a subnet or a single address, e.g. "10.0.0.0/8", "192.168.1.1", "2001:db8::/32"
+	CIDR string `yaml:"cidr,omitempty" json:"cidr,omitempty"`
+	// Host is a DNS name, e.g. "example.com"; "*.example.com" matches the subdomains of "example.com".
+	// The addresses of the names are learned from the DNS responses received by the instances.
+	Host     string `yaml:"host,omitempty" json:"host,omitempty"`
+	Ports    []int  `yaml:"ports,omitempty" json:"ports,omitempty"`
+	Protocol string `yaml:"protocol,omitempty" json:"protocol,omitempty"` // "tcp", "udp", or "icmp"; default: any
+}
+
+// DefaultAllows returns true when the connections that do not match any rule are allowed.
+func (p *EgressPolicy) DefaultAllows() bool {
+	return p == nil || p.Default != EgressDeny
+}
+
+// Validate checks the policy.
+func (p *EgressPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	switch p.Default {
+	case "", EgressAllow, EgressDeny:
+	default:
+		return fmt.Errorf("field `default` must be %q or %q, got %q", EgressAllow, EgressDeny, p.Default)
+	}
+	for i, rule := range p.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("field `rules[%d]` error: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the rule.
+func (r *EgressRule) Validate() error {
+	switch r.Action {
+	case EgressAllow, EgressDeny:
+	default:
+		return fmt.Errorf("field `action` must be %q or %q, got %q", EgressAllow, EgressDeny, r.Action)
+	}
+	if _, err := r.Prefix(); err != nil {
+		return fmt.Errorf("field `cidr` is invalid: %w", err)
+	}
+	if r.Host != "" {
+		if err := validateHostPattern(r.Host); err != nil {
+			return fmt.Errorf("field `host` is invalid: %w", err)
+		}
+	}
+	for _, port := range r.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("field `ports` must contain port numbers between 1 and 65535, got %d", port)
+		}
+	}
+	switch r.Protocol {
+	case "", "tcp", "udp":
+	case "icmp":
+		if len(r.Ports) > 0 {
+			return errors.New("field `ports` must not be set for protocol \"icmp\"")
+		}
+	default:
+		return fmt.Errorf("field `protocol` must be \"tcp\", \"udp\", or \"icmp\", got %q", r.Protocol)
+	}
+	return nil
+}
+
+// Prefix returns the parsed CIDR. A single address is returned as a prefix of the full length.
+// Prefix returns the zero netip.Prefix when CIDR is not set.
+func (r *EgressRule) Prefix() (netip.Prefix, error) {
+	if r.CIDR == "" {
+		return netip.Prefix{}, nil
+	}
+	if !strings.Contains(r.CIDR, "/") {
+		addr, err := netip.ParseAddr(r.CIDR)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(r.CIDR)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// MatchHost returns true when the DNS name matches the Host of the rule. The name may end with a dot.
+func (r *EgressRule) MatchHost(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	pattern := strings.ToLower(strings.TrimSuffix(r.Host, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(name, suffix)
+	}
+	return name == pattern
+}
+
+func validateHostPattern(host string) error {
+	name := strings.TrimSuffix(strings.TrimPrefix(host, "*."), ".")
+	if name == "" {
+		return fmt.Errorf("%q is not a DNS name", host)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || strings.ContainsAny(label, "*/: ") {
+			return fmt.Errorf("%q is not a DNS name, or a DNS name prefixed with \"*.\"", host)
+		}
+	}
+	return nil
+}
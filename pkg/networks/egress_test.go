@@ -0,0 +1,60 @@
+package networks
+
+import (
+	"net/netip"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestEgressPolicyValidate(t *testing.T) {
+	var nilPolicy *EgressPolicy
+	assert.NilError(t, nilPolicy.Validate())
+	assert.Assert(t, nilPolicy.DefaultAllows())
+
+	policy := &EgressPolicy{
+		Default: EgressDeny,
+		Rules: []EgressRule{
+			{Action: EgressDeny, CIDR: "10.0.0.0/8"},
+			{Action: EgressAllow, Host: "*.github.com", Ports: []int{443}, Protocol: "tcp"},
+			{Action: EgressAllow, CIDR: "2001:db8::1"},
+			{Action: EgressAllow, Protocol: "icmp"},
+		},
+	}
+	assert.NilError(t, policy.Validate())
+	assert.Assert(t, !policy.DefaultAllows())
+
+	cases := map[string]EgressRule{
+		"field `action` must be":              {Action: "reject"},
+		"field `cidr` is invalid":             {Action: EgressDeny, CIDR: "10.0.0.0/33"},
+		"field `host` is invalid":             {Action: EgressDeny, Host: "*"},
+		"field `ports` must contain":          {Action: EgressDeny, Ports: []int{0}},
+		"field `protocol` must be":            {Action: EgressDeny, Protocol: "sctp"},
+		"field `ports` must not be set for p": {Action: EgressDeny, Protocol: "icmp", Ports: []int{1}},
+	}
+	for errorMsg, rule := range cases {
+		assert.ErrorContains(t, rule.Validate(), errorMsg)
+	}
+	assert.ErrorContains(t, (&EgressPolicy{Default: "reject"}).Validate(), "field `default` must be")
+}
+
+func TestEgressRule(t *testing.T) {
+	prefix, err := (&EgressRule{CIDR: "192.168.1.1"}).Prefix()
+	assert.NilError(t, err)
+	assert.Equal(t, prefix, netip.MustParsePrefix("192.168.1.1/32"))
+	prefix, err = (&EgressRule{CIDR: "10.1.2.3/8"}).Prefix()
+	assert.NilError(t, err)
+	assert.Equal(t, prefix, netip.MustParsePrefix("10.0.0.0/8"))
+	prefix, err = (&EgressRule{}).Prefix()
+	assert.NilError(t, err)
+	assert.Assert(t, !prefix.IsValid())
+
+	exact := &EgressRule{Host: "Example.com"}
+	assert.Assert(t, exact.MatchHost("example.com."))
+	assert.Assert(t, !exact.MatchHost("www.example.com"))
+	wildcard := &EgressRule{Host: "*.example.com"}
+	assert.Assert(t, wildcard.MatchHost("www.example.com."))
+	assert.Assert(t, wildcard.MatchHost("a.b.example.com"))
+	assert.Assert(t, !wildcard.MatchHost("example.com"))
+	assert.Assert(t, !wildcard.MatchHost("badexample.com"))
+}
@@ -1,6 +1,10 @@
 package networks
 
-import "net"
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
 
 type Config struct {
 	Paths    Paths              `yaml:"paths"`
@@ -22,9 +26,53 @@ const (
 )
 
 type Network struct {
-	Mode      string `yaml:"mode"`                // "host", "shared", or "bridged"
-	Interface string `yaml:"interface,omitempty"` // only used by "bridged" networks
-	Gateway   net.IP `yaml:"gateway,omitempty"`   // only used by "host" and "shared" networks
-	DHCPEnd   net.IP `yaml:"dhcpEnd,omitempty"`   // default: same as Gateway, last byte is 254
-	NetMask   net.IP `yaml:"netmask,omitempty"`   // default: 255.255.255.0
+	Mode      string `yaml:"mode" json:"mode"`                               // "user-v2", "host", "shared", or "bridged"
+	Interface string `yaml:"interface,omitempty" json:"interface,omitempty"` // only used by "bridged" networks
+	Gateway   net.IP `yaml:"gateway,omitempty" json:"gateway,omitempty"`     // only used by "user-v2", "host" and "shared" networks
+	DHCPEnd   net.IP `yaml:"dhcpEnd,omitempty" json:"dhcpEnd,omitempty"`     // default: same as Gateway, last byte is 254
+	NetMask   net.IP `yaml:"netmask,omitempty" json:"netmask,omitempty"`     // default: 255.255.255.0
+	// IPv6Subnet enables IPv6 in addition to IPv4, e.g. "fd00:6c69:6d61::/64". Only used by "user-v2" networks.
+	IPv6Subnet string `yaml:"ipv6Subnet,omitempty" json:"ipv6Subnet,omitempty"`
+	// Conditions are the default link conditions of the instances. Only used by "user-v2" networks.
+	Conditions *Conditions `yaml:"conditions,omitempty" json:"conditions,omitempty"`
+	// Egress restricts the connections from the instances to the outside. Only used by "user-v2" networks.
+	Egress *EgressPolicy `yaml:"egress,omitempty" json:"egress,omitempty"`
+}
+
+// Subnet returns the subnet of the network, derived from Gateway and NetMask.
+// NetMask defaults to 255.255.255.0.
+// Subnet returns nil when Gateway is not set.
+func (nw *Network) Subnet() *net.IPNet {
+	gateway := nw.Gateway.To4()
+	if gateway == nil {
+		return nil
+	}
+	mask := net.IPv4Mask(255, 255, 255, 0)
+	if nw.NetMask != nil {
+		mask = net.IPMask(nw.NetMask.To4())
+	}
+	return &net.IPNet{IP: gateway.Mask(mask), Mask: mask}
+}
+
+// IPv6Prefix returns the parsed IPv6Subnet.
+// IPv6Prefix returns the zero netip.Prefix when IPv6Subnet is not set.
+func (nw *Network) IPv6Prefix() (netip.Prefix, error) {
+	if nw.IPv6Subnet == "" {
+		return netip.Prefix{}, nil
+	}
+	prefix, err := netip.ParsePrefix(nw.IPv6Subnet)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		return netip.Prefix{}, fmt.Errorf("%q is not an IPv6 subnet", nw.IPv6Subnet)
+	}
+	// The addresses of the VMs are derived from their MAC addresses (modified EUI-64), like with SLAAC
+	if prefix.Bits() != 64 {
+		return netip.Prefix{}, fmt.Errorf("the prefix length of %q must be 64", nw.IPv6Subnet)
+	}
+	if prefix.Masked() != prefix {
+		return netip.Prefix{}, fmt.Errorf("%q has host bits set, expected %q", nw.IPv6Subnet, prefix.Masked())
+	}
+	return prefix, nil
 }
@@ -0,0 +1,209 @@
+package usernet
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/httpclientutil"
+	"github.com/lima-vm/lima/pkg/httputil"
+	"github.com/sirupsen/logrus"
+)
+
+// CapturePath is the usernet API endpoint that streams the frames passing the virtual switch in pcap format.
+// Query parameters: "filter" (see CaptureFilter), "count" (stop after capturing this many frames).
+const CapturePath = "/lima/capture"
+
+const (
+	pcapMagic        = 0xa1b2c3d4
+	pcapSnapLen      = 65535
+	pcapLinkTypeEth  = 1
+	captureQueueSize = 1024
+)
+
+// captureHub fans out the frames passing the virtual switch to the capture subscribers.
+type captureHub struct {
+	active atomic.Int32
+	mu     sync.RWMutex
+	subs   map[*captureSubscriber]struct{}
+}
+
+type capturedFrame struct {
+	timestamp time.Time
+	data      []byte
+}
+
+type captureSubscriber struct {
+	filter  *CaptureFilter
+	frames  chan capturedFrame
+	dropped atomic.Uint64
+}
+
+func newCaptureHub() *captureHub {
+	return &captureHub{subs: make(map[*captureSubscriber]struct{})}
+}
+
+func (h *captureHub) subscribe(filter *CaptureFilter) *captureSubscriber {
+	sub := &captureSubscriber{
+		filter: filter,
+		frames: make(chan capturedFrame, captureQueueSize),
+	}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.active.Store(int32(len(h.subs)))
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *captureHub) unsubscribe(sub *captureSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.active.Store(int32(len(h.subs)))
+	h.mu.Unlock()
+}
+
+// publish copies the frame to all subscribers whose filter matches.
+// Frames are dropped when a subscriber cannot keep up, so the virtual switch is never blocked.
+func (h *captureHub) publish(frame []byte) {
+	if h == nil || h.active.Load() == 0 {
+		return
+	}
+	now := time.Now()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if !sub.filter.Match(frame) {
+			continue
+		}
+		select {
+		case sub.frames <- capturedFrame{timestamp: now, data: append([]byte(nil), frame...)}:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// ServeHTTP implements the CapturePath endpoint.
+func (h *captureHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := ParseCaptureFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	var count uint64
+	if s := r.URL.Query().Get("count"); s != "" {
+		if count, err = strconv.ParseUint(s, 10, 64); err != nil {
+			writeError(w, fmt.Errorf("invalid count %q: %w", s, err), http.StatusBadRequest)
+			return
+		}
+	}
+	// The capture is a long-running stream, so the server's write timeout must not apply
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	sub := h.subscribe(filter)
+	defer h.unsubscribe(sub)
+	logrus.Infof("Starting packet capture (filter: %q)", filter)
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.WriteHeader(http.StatusOK)
+	if err := writePcapHeader(w); err != nil {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	var captured uint64
+	for count == 0 || captured < count {
+		select {
+		case <-r.Context().Done():
+			logrus.Infof("Stopped packet capture (filter: %q): %d frames captured, %d dropped", filter, captured, sub.dropped.Load())
+			return
+		case frame := <-sub.frames:
+			if err := writePcapRecord(w, frame); err != nil {
+				logrus.WithError(err).Info("Packet capture client went away")
+				return
+			}
+			captured++
+			// Flush once the queue is drained, so frames arrive in (near) real time without a syscall per frame
+			if flusher != nil && len(sub.frames) == 0 {
+				flusher.Flush()
+			}
+		}
+	}
+	logrus.Infof("Finished packet capture (filter: %q): %d frames captured, %d dropped", filter, captured, sub.dropped.Load())
+}
+
+func writeError(w http.ResponseWriter, err error, ec int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ec)
+	_ = json.NewEncoder(w).Encode(httputil.ErrorJSON{Message: err.Error()})
+}
+
+func writePcapHeader(w io.Writer) error {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2) // version major
+	binary.LittleEndian.PutUint16(hdr[6:8], 4) // version minor
+	binary.LittleEndian.PutUint32(hdr[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], pcapLinkTypeEth)
+	_, err := w.Write(hdr[:])
+	return err
+}
+
+func writePcapRecord(w io.Writer, frame capturedFrame) error {
+	data := frame.data
+	if len(data) > pcapSnapLen {
+		data = data[:pcapSnapLen]
+	}
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(frame.timestamp.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(frame.timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(len(frame.data)))
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// Capture streams the frames passing the virtual switch in pcap format to w, until ctx is cancelled,
+// or until count frames have been captured when count is non-zero.
+func (c *Client) Capture(ctx context.Context, w io.Writer, filter string, count uint64) error {
+	if _, err := ParseCaptureFilter(filter); err != nil {
+		return err
+	}
+	q := url.Values{}
+	if filter != "" {
+		q.Set("filter", filter)
+	}
+	if count != 0 {
+		q.Set("count", strconv.FormatUint(count, 10))
+	}
+	u := fmt.Sprintf("%s%s?%s", c.base, CapturePath, q.Encode())
+	res, err := httpclientutil.Get(ctx, c.client, u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(w, res.Body); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
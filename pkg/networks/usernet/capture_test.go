@@ -0,0 +1,50 @@
+package usernet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCapture(t *testing.T) {
+	hub := newCaptureHub()
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+	client := &Client{client: srv.Client(), base: srv.URL}
+
+	_, err := ParseCaptureFilter("foo")
+	assert.ErrorContains(t, client.Capture(context.Background(), io.Discard, "foo", 0), err.Error())
+
+	dns := testFrame("52:55:55:00:00:01", gatewayMacAddr, "192.168.104.3", "192.168.104.2", ipProtoUDP, 40000, 53)
+	web := testFrame(gatewayMacAddr, "52:55:55:00:00:01", "192.168.104.2", "192.168.104.3", ipProtoTCP, 80, 40001)
+	go func() {
+		for hub.active.Load() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		hub.publish(web)
+		hub.publish(dns)
+	}()
+	var buf bytes.Buffer
+	err = client.Capture(context.Background(), &buf, "udp port 53", 1)
+	assert.NilError(t, err)
+
+	b := buf.Bytes()
+	assert.Equal(t, len(b), 24+16+len(dns))
+	assert.Equal(t, binary.LittleEndian.Uint32(b[0:4]), uint32(pcapMagic))
+	assert.Equal(t, binary.LittleEndian.Uint32(b[20:24]), uint32(pcapLinkTypeEth))
+	assert.Equal(t, binary.LittleEndian.Uint32(b[24+8:24+12]), uint32(len(dns)))
+	assert.DeepEqual(t, b[24+16:], dns)
+}
+
+func TestCaptureMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newCaptureHub().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, CapturePath, http.NoBody))
+	assert.Equal(t, rec.Code, http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,371 @@
+package usernet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// CaptureFilter matches Ethernet frames against a filter expression.
+//
+// The expression syntax is a subset of the pcap-filter(7) syntax used by tcpdump:
+//
+//	arp, ip, ip6, icmp, icmp6, tcp, udp
+//	[src|dst] host IP
+//	[src|dst] net CIDR
+//	[tcp|udp] [src|dst] port PORT
+//	ether [src|dst] host MAC
+//
+// Primitives can be combined with "and" ("&&"), "or" ("||"), "not" ("!"), and parentheses.
+type CaptureFilter struct {
+	expr   string
+	filter frameFilter
+}
+
+// ParseCaptureFilter parses the filter expression. An empty expression matches all frames.
+func ParseCaptureFilter(expr string) (*CaptureFilter, error) {
+	p := &filterParser{tokens: tokenizeFilter(expr)}
+	if len(p.tokens) == 0 {
+		return &CaptureFilter{}, nil
+	}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid capture filter %q: %w", expr, err)
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("invalid capture filter %q: unexpected %q", expr, tok)
+	}
+	return &CaptureFilter{expr: expr, filter: f}, nil
+}
+
+// String returns the filter expression.
+func (f *CaptureFilter) String() string {
+	return f.expr
+}
+
+// Match returns true if the Ethernet frame matches the filter.
+func (f *CaptureFilter) Match(frame []byte) bool {
+	if f == nil || f.filter == nil {
+		return true
+	}
+	pkt, ok := decodeFrame(frame)
+	if !ok {
+		return false
+	}
+	return f.filter(&pkt)
+}
+
+// decodedFrame holds the frame fields that filters can match against.
+type decodedFrame struct {
+	srcMAC, dstMAC   net.HardwareAddr
+	etherType        uint16
+	srcIP, dstIP     netip.Addr
+	ipProto          uint8
+	srcPort, dstPort uint16
+	hasPorts         bool
+}
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeARP  = 0x0806
+	etherTypeIPv6 = 0x86dd
+
+	ipProtoICMP   = 1
+	ipProtoTCP    = 6
+	ipProtoUDP    = 17
+	ipProtoICMPv6 = 58
+)
+
+func decodeFrame(frame []byte) (decodedFrame, bool) {
+	var pkt decodedFrame
+	if len(frame) < 14 {
+		return pkt, false
+	}
+	pkt.dstMAC = net.HardwareAddr(frame[0:6])
+	pkt.srcMAC = net.HardwareAddr(frame[6:12])
+	pkt.etherType = binary.BigEndian.Uint16(frame[12:14])
+	payload := frame[14:]
+	var transport []byte
+	switch pkt.etherType {
+	case etherTypeARP:
+		// Only IPv4 over Ethernet is supported: sender IP at offset 14, target IP at offset 24
+		if len(payload) >= 28 {
+			pkt.srcIP, _ = netip.AddrFromSlice(payload[14:18])
+			pkt.dstIP, _ = netip.AddrFromSlice(payload[24:28])
+		}
+		return pkt, true
+	case etherTypeIPv4:
+		if len(payload) < 20 {
+			return pkt, true
+		}
+		ihl := int(payload[0]&0x0f) * 4
+		pkt.ipProto = payload[9]
+		pkt.srcIP, _ = netip.AddrFromSlice(payload[12:16])
+		pkt.dstIP, _ = netip.AddrFromSlice(payload[16:20])
+		// Ports are only present in the first fragment
+		if fragOffset := binary.BigEndian.Uint16(payload[6:8]) & 0x1fff; fragOffset == 0 && ihl >= 20 && len(payload) >= ihl {
+			transport = payload[ihl:]
+		}
+	case etherTypeIPv6:
+		if len(payload) < 40 {
+			return pkt, true
+		}
+		// Extension headers are not traversed
+		pkt.ipProto = payload[6]
+		pkt.srcIP, _ = netip.AddrFromSlice(payload[8:24])
+		pkt.dstIP, _ = netip.AddrFromSlice(payload[24:40])
+		transport = payload[40:]
+	default:
+		return pkt, true
+	}
+	if (pkt.ipProto == ipProtoTCP || pkt.ipProto == ipProtoUDP) && len(transport) >= 4 {
+		pkt.srcPort = binary.BigEndian.Uint16(transport[0:2])
+		pkt.dstPort = binary.BigEndian.Uint16(transport[2:4])
+		pkt.hasPorts = true
+	}
+	return pkt, true
+}
+
+type frameFilter func(pkt *decodedFrame) bool
+
+func tokenizeFilter(expr string) []string {
+	var tokens []string
+	for _, field := range strings.Fields(expr) {
+		for field != "" {
+			switch {
+			case field[0] == '(' || field[0] == ')':
+				tokens = append(tokens, field[:1])
+				field = field[1:]
+			case strings.HasPrefix(field, "&&") || strings.HasPrefix(field, "||"):
+				tokens = append(tokens, field[:2])
+				field = field[2:]
+			case field[0] == '!':
+				tokens = append(tokens, "!")
+				field = field[1:]
+			default:
+				i := strings.IndexAny(field, "()!&|")
+				if i < 0 {
+					i = len(field)
+				}
+				if i == 0 {
+					// a lone '&' or '|'
+					i = 1
+				}
+				tokens = append(tokens, field[:i])
+				field = field[i:]
+			}
+		}
+	}
+	return tokens
+}
+
+type filterParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *filterParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) parseOr() (frameFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok == "or" || tok == "||"; tok = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *decodedFrame) bool { return l(pkt) || right(pkt) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (frameFilter, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok == "and" || tok == "&&"; tok = p.peek() {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(pkt *decodedFrame) bool { return l(pkt) && right(pkt) }
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (frameFilter, error) {
+	if tok := p.peek(); tok == "not" || tok == "!" {
+		p.next()
+		f, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(pkt *decodedFrame) bool { return !f(pkt) }, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *filterParser) parsePrimary() (frameFilter, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, errors.New("unexpected end of expression")
+	case "(":
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, errors.New("missing ')'")
+		}
+		return f, nil
+	case "arp":
+		return matchEtherType(etherTypeARP), nil
+	case "ip":
+		return matchEtherType(etherTypeIPv4), nil
+	case "ip6":
+		return matchEtherType(etherTypeIPv6), nil
+	case "icmp":
+		return matchIPProto(ipProtoICMP), nil
+	case "icmp6":
+		return matchIPProto(ipProtoICMPv6), nil
+	case "tcp", "udp":
+		proto := uint8(ipProtoTCP)
+		if tok == "udp" {
+			proto = ipProtoUDP
+		}
+		if next := p.peek(); next == "port" || next == "src" || next == "dst" {
+			f, err := p.parseQualified()
+			if err != nil {
+				return nil, err
+			}
+			return func(pkt *decodedFrame) bool { return pkt.ipProto == proto && f(pkt) }, nil
+		}
+		return matchIPProto(proto), nil
+	case "ether":
+		return p.parseEther()
+	default:
+		p.pos--
+		return p.parseQualified()
+	}
+}
+
+// parseQualified parses `[src|dst] (host|net|port) VALUE`.
+func (p *filterParser) parseQualified() (frameFilter, error) {
+	dir := ""
+	if tok := p.peek(); tok == "src" || tok == "dst" {
+		dir = p.next()
+	}
+	kind := p.next()
+	value := p.next()
+	if value == "" {
+		return nil, fmt.Errorf("missing value after %q", kind)
+	}
+	switch kind {
+	case "host":
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchDir(dir, func(pkt *decodedFrame, src bool) bool {
+			if src {
+				return pkt.srcIP == addr
+			}
+			return pkt.dstIP == addr
+		}), nil
+	case "net":
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		return matchDir(dir, func(pkt *decodedFrame, src bool) bool {
+			if src {
+				return prefix.Contains(pkt.srcIP)
+			}
+			return prefix.Contains(pkt.dstIP)
+		}), nil
+	case "port":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", value)
+		}
+		return matchDir(dir, func(pkt *decodedFrame, src bool) bool {
+			if !pkt.hasPorts {
+				return false
+			}
+			if src {
+				return pkt.srcPort == uint16(port)
+			}
+			return pkt.dstPort == uint16(port)
+		}), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", kind)
+	}
+}
+
+// parseEther parses `ether [src|dst] host MAC`, after "ether" has been consumed.
+func (p *filterParser) parseEther() (frameFilter, error) {
+	dir := ""
+	if tok := p.peek(); tok == "src" || tok == "dst" {
+		dir = p.next()
+	}
+	if tok := p.next(); tok != "host" {
+		return nil, fmt.Errorf("expected \"host\" after \"ether\", got %q", tok)
+	}
+	mac, err := net.ParseMAC(p.next())
+	if err != nil {
+		return nil, err
+	}
+	return matchDir(dir, func(pkt *decodedFrame, src bool) bool {
+		if src {
+			return bytes.Equal(pkt.srcMAC, mac)
+		}
+		return bytes.Equal(pkt.dstMAC, mac)
+	}), nil
+}
+
+func matchDir(dir string, match func(pkt *decodedFrame, src bool) bool) frameFilter {
+	switch dir {
+	case "src":
+		return func(pkt *decodedFrame) bool { return match(pkt, true) }
+	case "dst":
+		return func(pkt *decodedFrame) bool { return match(pkt, false) }
+	default:
+		return func(pkt *decodedFrame) bool { return match(pkt, true) || match(pkt, false) }
+	}
+}
+
+func matchEtherType(etherType uint16) frameFilter {
+	return func(pkt *decodedFrame) bool { return pkt.etherType == etherType }
+}
+
+func matchIPProto(proto uint8) frameFilter {
+	return func(pkt *decodedFrame) bool {
+		return (pkt.etherType == etherTypeIPv4 || pkt.etherType == etherTypeIPv6) && pkt.ipProto == proto
+	}
+}
@@ -0,0 +1,93 @@
+package usernet
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+// testFrame builds an Ethernet frame carrying an IPv4 packet with a UDP or TCP header.
+func testFrame(srcMAC, dstMAC, srcIP, dstIP string, proto uint8, srcPort, dstPort uint16) []byte {
+	frame := make([]byte, 14+20+8)
+	src, _ := net.ParseMAC(srcMAC)
+	dst, _ := net.ParseMAC(dstMAC)
+	copy(frame[0:6], dst)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeIPv4)
+	ip := frame[14:]
+	ip[0] = 0x45
+	ip[9] = proto
+	copy(ip[12:16], net.ParseIP(srcIP).To4())
+	copy(ip[16:20], net.ParseIP(dstIP).To4())
+	binary.BigEndian.PutUint16(ip[20:22], srcPort)
+	binary.BigEndian.PutUint16(ip[22:24], dstPort)
+	return frame
+}
+
+func testARPFrame(srcMAC, senderIP, targetIP string) []byte {
+	frame := make([]byte, 14+28)
+	src, _ := net.ParseMAC(srcMAC)
+	copy(frame[0:6], net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], etherTypeARP)
+	copy(frame[14+14:14+18], net.ParseIP(senderIP).To4())
+	copy(frame[14+24:14+28], net.ParseIP(targetIP).To4())
+	return frame
+}
+
+func TestCaptureFilter(t *testing.T) {
+	dns := testFrame("52:55:55:00:00:01", gatewayMacAddr, "192.168.104.3", "192.168.104.2", ipProtoUDP, 40000, 53)
+	web := testFrame(gatewayMacAddr, "52:55:55:00:00:01", "192.168.104.2", "192.168.104.3", ipProtoTCP, 80, 40001)
+	arp := testARPFrame("52:55:55:00:00:01", "192.168.104.3", "192.168.104.2")
+
+	cases := []struct {
+		expr  string
+		match [3]bool // dns, web, arp
+	}{
+		{"", [3]bool{true, true, true}},
+		{"udp", [3]bool{true, false, false}},
+		{"tcp", [3]bool{false, true, false}},
+		{"arp", [3]bool{false, false, true}},
+		{"ip", [3]bool{true, true, false}},
+		{"port 53", [3]bool{true, false, false}},
+		{"udp port 53", [3]bool{true, false, false}},
+		{"tcp port 53", [3]bool{false, false, false}},
+		{"dst port 53", [3]bool{true, false, false}},
+		{"src port 53", [3]bool{false, false, false}},
+		{"host 192.168.104.3", [3]bool{true, true, true}},
+		{"src host 192.168.104.3", [3]bool{true, false, true}},
+		{"net 192.168.104.0/24 and not arp", [3]bool{true, true, false}},
+		{"not (udp or arp)", [3]bool{false, true, false}},
+		{"!udp&&!tcp", [3]bool{false, false, true}},
+		{"ether src host " + gatewayMacAddr, [3]bool{false, true, false}},
+		{"tcp or udp and port 53", [3]bool{true, true, false}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.expr, func(t *testing.T) {
+			f, err := ParseCaptureFilter(tc.expr)
+			assert.NilError(t, err)
+			assert.Equal(t, f.Match(dns), tc.match[0], "dns")
+			assert.Equal(t, f.Match(web), tc.match[1], "web")
+			assert.Equal(t, f.Match(arp), tc.match[2], "arp")
+		})
+	}
+}
+
+func TestCaptureFilterErrors(t *testing.T) {
+	for _, expr := range []string{
+		"foo",
+		"host",
+		"host 300.1.1.1",
+		"port http",
+		"(udp",
+		"udp)",
+		"udp and",
+		"ether host foo",
+		"ether port 53",
+	} {
+		_, err := ParseCaptureFilter(expr)
+		assert.ErrorContains(t, err, "invalid capture filter", expr)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"strconv"
 	"time"
@@ -16,9 +17,14 @@ import (
 	"github.com/lima-vm/lima/pkg/driver"
 	"github.com/lima-vm/lima/pkg/httpclientutil"
 	"github.com/lima-vm/lima/pkg/limayaml"
+	"github.com/lima-vm/lima/pkg/networks"
 	"github.com/lima-vm/lima/pkg/networks/usernet/dnshosts"
 )
 
+// InstanceHostsDomain is the domain under which the hostnames of the instances are resolvable
+// from the other instances on the network, e.g. "lima-default.internal".
+const InstanceHostsDomain = "internal"
+
 type Client struct {
 	Directory string
 
@@ -26,10 +32,23 @@ type Client struct {
 	delegate *gvproxyclient.Client
 	base     string
 	subnet   net.IP
+	// subnetIPv6 is the zero netip.Prefix when IPv6 is not enabled
+	subnetIPv6 netip.Prefix
+	// conditions are the default link conditions of the network, or nil
+	conditions *networks.Conditions
 }
 
 func (c *Client) ConfigureDriver(ctx context.Context, driver *driver.BaseDriver) error {
 	macAddress := limayaml.MACAddress(driver.Instance.Dir)
+	conditions := c.conditions
+	if i := limayaml.FirstUsernetIndex(driver.Instance.Config); i != -1 && driver.Instance.Config.Networks[i].Conditions != nil {
+		conditions = driver.Instance.Config.Networks[i].Conditions
+	}
+	if conditions != nil {
+		if err := c.SetConditions(ctx, InstanceConditions{MACAddress: macAddress, Conditions: *conditions}); err != nil {
+			return err
+		}
+	}
 	ipAddress, err := c.ResolveIPAddress(ctx, macAddress)
 	if err != nil {
 		return err
@@ -39,11 +58,24 @@ func (c *Client) ConfigureDriver(ctx context.Context, driver *driver.BaseDriver)
 		return err
 	}
 	hosts := driver.Instance.Config.HostResolver.Hosts
-	hosts[fmt.Sprintf("%s.internal", driver.Instance.Hostname)] = ipAddress
+	hosts[fmt.Sprintf("%s.%s", driver.Instance.Hostname, InstanceHostsDomain)] = ipAddress
 	err = c.AddDNSHosts(hosts)
 	return err
 }
 
+// UnconfigureDriver reverts ConfigureDriver when the instance is stopped:
+// the SSH port is no longer forwarded, and the link conditions are cleared.
+//
+// The hostname of the instance remains resolvable until the network daemon is stopped,
+// as gvisor-tap-vsock has no API for removing a DNS record.
+// It is registered again with the current IP address when the instance is started.
+func (c *Client) UnconfigureDriver(ctx context.Context, driver *driver.BaseDriver) error {
+	return errors.Join(
+		c.UnExposeSSH(driver.SSHLocalPort),
+		c.ClearConditions(ctx, limayaml.MACAddress(driver.Instance.Dir)),
+	)
+}
+
 func (c *Client) UnExposeSSH(sshPort int) error {
 	return c.delegate.Unexpose(&types.UnexposeRequest{
 		Local:    fmt.Sprintf("127.0.0.1:%d", sshPort),
@@ -129,7 +161,18 @@ func NewClientByName(nwName string) *Client {
 	if err != nil {
 		return nil
 	}
-	return NewClient(endpointSock, subnet)
+	subnetIPv6, err := IPv6Subnet(nwName)
+	if err != nil {
+		return nil
+	}
+	conditions, err := DefaultConditions(nwName)
+	if err != nil {
+		return nil
+	}
+	c := NewClient(endpointSock, subnet)
+	c.subnetIPv6 = subnetIPv6
+	c.conditions = conditions
+	return c
 }
 
 func NewClient(endpointSock string, subnet net.IP) *Client {
@@ -0,0 +1,254 @@
+package usernet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/httpclientutil"
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/sirupsen/logrus"
+)
+
+// ConditionsPath is the usernet API endpoint for the emulated link conditions of the VMs:
+//
+//	GET                           lists the InstanceConditions
+//	POST                          sets the conditions in the InstanceConditions body
+//	DELETE ?macAddress=MACADDRESS restores a perfect link
+//
+// The conditions apply to both directions of the link between the VM and the virtual switch.
+const ConditionsPath = "/lima/conditions"
+
+// linkQueueSize is the maximum number of frames delayed in each direction of a link; further frames are dropped.
+// Same as the default limit of the Linux netem qdisc.
+const linkQueueSize = 1000
+
+// InstanceConditions are the emulated link conditions of the VM with the MAC address.
+type InstanceConditions struct {
+	MACAddress string `json:"macAddress"`
+	networks.Conditions
+}
+
+// linkConditions holds the emulated link conditions, keyed by the MAC address of the VM.
+type linkConditions struct {
+	mu    sync.RWMutex
+	byMAC map[string]linkCondition
+}
+
+type linkCondition struct {
+	conditions networks.Conditions
+	parsed     networks.ParsedConditions
+}
+
+func newLinkConditions() *linkConditions {
+	return &linkConditions{byMAC: make(map[string]linkCondition)}
+}
+
+// get returns the conditions of the VM with the MAC address; mac must be in the net.HardwareAddr.String() format.
+func (l *linkConditions) get(mac string) networks.ParsedConditions {
+	if l == nil {
+		return networks.ParsedConditions{}
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.byMAC[mac].parsed
+}
+
+func (l *linkConditions) set(req InstanceConditions) error {
+	mac, err := net.ParseMAC(req.MACAddress)
+	if err != nil {
+		return err
+	}
+	parsed, err := req.Conditions.Parse()
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if parsed.IsZero() {
+		delete(l.byMAC, mac.String())
+	} else {
+		l.byMAC[mac.String()] = linkCondition{conditions: req.Conditions, parsed: parsed}
+	}
+	return nil
+}
+
+// ServeHTTP implements the ConditionsPath endpoint.
+func (l *linkConditions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		l.mu.RLock()
+		list := make([]InstanceConditions, 0, len(l.byMAC))
+		for mac, c := range l.byMAC {
+			list = append(list, InstanceConditions{MACAddress: mac, Conditions: c.conditions})
+		}
+		l.mu.RUnlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].MACAddress < list[j].MACAddress })
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	case http.MethodPost:
+		var req InstanceConditions
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err := l.set(req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		logrus.Infof("Setting the link conditions of %s to %+v", req.MACAddress, req.Conditions)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		mac := r.URL.Query().Get("macAddress")
+		if err := l.set(InstanceConditions{MACAddress: mac}); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		logrus.Infof("Restoring a perfect link for %s", mac)
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// linkEmulator delays and drops the frames passing one direction of the link of a VM.
+// The frames are never reordered.
+type linkEmulator struct {
+	out    func([]byte)
+	done   <-chan struct{}
+	queue  chan delayedFrame
+	queued atomic.Int32 // frames that have not been passed to out yet
+
+	mu sync.Mutex
+	// busyUntil is the time when the emulated link has finished transmitting the queued frames
+	busyUntil time.Time
+	lastDue   time.Time
+}
+
+type delayedFrame struct {
+	due   time.Time
+	frame []byte
+}
+
+func newLinkEmulator(out func([]byte), done <-chan struct{}) *linkEmulator {
+	e := &linkEmulator{out: out, done: done, queue: make(chan delayedFrame, linkQueueSize)}
+	go e.run()
+	return e
+}
+
+// idle returns true when no frames are delayed, so that frames can bypass the emulator without being reordered.
+func (e *linkEmulator) idle() bool {
+	return e.queued.Load() == 0
+}
+
+// send passes the frame to out after applying the conditions. The emulator takes the ownership of the frame.
+func (e *linkEmulator) send(frame []byte, cond networks.ParsedConditions) {
+	if cond.Loss > 0 && rand.Float64()*100 < cond.Loss {
+		return
+	}
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	due := now
+	busyUntil := e.busyUntil
+	if cond.Bandwidth > 0 {
+		due = later(now, e.busyUntil).Add(time.Duration(uint64(len(frame)) * 8 * uint64(time.Second) / cond.Bandwidth))
+		busyUntil = due
+	}
+	delay := cond.Latency
+	if cond.Jitter > 0 {
+		delay = max(0, delay+time.Duration(rand.Int64N(2*int64(cond.Jitter)+1))-cond.Jitter)
+	}
+	due = later(due.Add(delay), e.lastDue)
+	e.queued.Add(1)
+	select {
+	case e.queue <- delayedFrame{due: due, frame: frame}:
+		e.busyUntil = busyUntil
+		e.lastDue = due
+	default:
+		// The queue is full, like the buffer of a congested router
+		e.queued.Add(-1)
+	}
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func (e *linkEmulator) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case f := <-e.queue:
+			if d := time.Until(f.due); d > 0 {
+				timer.Reset(d)
+				select {
+				case <-e.done:
+					return
+				case <-timer.C:
+				}
+			}
+			e.out(f.frame)
+			e.queued.Add(-1)
+		}
+	}
+}
+
+// Conditions returns the emulated link conditions of the VMs.
+func (c *Client) Conditions(ctx context.Context) ([]InstanceConditions, error) {
+	u := fmt.Sprintf("%s%s", c.base, ConditionsPath)
+	res, err := httpclientutil.Get(ctx, c.client, u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var list []InstanceConditions
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SetConditions sets the emulated link conditions of a VM. The zero conditions restore a perfect link.
+func (c *Client) SetConditions(ctx context.Context, conditions InstanceConditions) error {
+	b, err := json.Marshal(conditions)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s%s", c.base, ConditionsPath)
+	res, err := httpclientutil.Post(ctx, c.client, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// ClearConditions restores a perfect link for the VM with the MAC address.
+func (c *Client) ClearConditions(ctx context.Context, macAddress string) error {
+	u := fmt.Sprintf("%s%s?%s", c.base, ConditionsPath, url.Values{"macAddress": {macAddress}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u, http.NoBody)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return httpclientutil.Successful(res)
+}
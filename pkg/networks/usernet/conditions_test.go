@@ -0,0 +1,129 @@
+package usernet
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/networks"
+	"gotest.tools/v3/assert"
+)
+
+func TestLinkConditionsHTTP(t *testing.T) {
+	l := newLinkConditions()
+	post := func(req InstanceConditions) int {
+		b, err := json.Marshal(req)
+		assert.NilError(t, err)
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest(http.MethodPost, ConditionsPath, bytes.NewReader(b)))
+		return w.Code
+	}
+	list := func() []InstanceConditions {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest(http.MethodGet, ConditionsPath, http.NoBody))
+		assert.Equal(t, w.Code, http.StatusOK)
+		var list []InstanceConditions
+		assert.NilError(t, json.NewDecoder(w.Body).Decode(&list))
+		return list
+	}
+
+	conditions := networks.Conditions{Latency: "100ms", Loss: 1}
+	assert.Equal(t, post(InstanceConditions{MACAddress: "52:55:55:AB:CD:EF", Conditions: conditions}), http.StatusOK)
+	assert.DeepEqual(t, list(), []InstanceConditions{{MACAddress: "52:55:55:ab:cd:ef", Conditions: conditions}})
+	assert.Equal(t, l.get("52:55:55:ab:cd:ef").Latency, 100*time.Millisecond)
+
+	assert.Equal(t, post(InstanceConditions{MACAddress: "52:55:55:ab:cd:ef", Conditions: networks.Conditions{Loss: 200}}), http.StatusBadRequest)
+	assert.Equal(t, post(InstanceConditions{MACAddress: "invalid", Conditions: conditions}), http.StatusBadRequest)
+
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, ConditionsPath+"?macAddress=52:55:55:ab:cd:ef", http.NoBody))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, len(list()), 0)
+	assert.Assert(t, l.get("52:55:55:ab:cd:ef").IsZero())
+}
+
+func TestLinkEmulator(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	out := make(chan []byte, linkQueueSize)
+	e := newLinkEmulator(func(b []byte) { out <- b }, done)
+
+	// 10 frames of 1250 bytes take 10ms each on a 1Mbit link, in addition to the latency
+	start := time.Now()
+	cond := networks.ParsedConditions{Latency: 20 * time.Millisecond, Bandwidth: 1_000_000}
+	for i := range 10 {
+		e.send(bytes.Repeat([]byte{byte(i)}, 1250), cond)
+	}
+	assert.Assert(t, !e.idle())
+	for i := range 10 {
+		frame := <-out
+		assert.Equal(t, frame[0], byte(i), "frames must not be reordered")
+	}
+	assert.Assert(t, time.Since(start) >= 120*time.Millisecond, time.Since(start))
+
+	// Jitter never reorders the frames either
+	cond = networks.ParsedConditions{Latency: 5 * time.Millisecond, Jitter: 5 * time.Millisecond}
+	for i := range 10 {
+		e.send([]byte{byte(i)}, cond)
+	}
+	for i := range 10 {
+		assert.Equal(t, (<-out)[0], byte(i), "frames must not be reordered")
+	}
+
+	// All frames are dropped
+	e.send([]byte{0}, networks.ParsedConditions{Loss: 100})
+	select {
+	case <-out:
+		t.Fatal("the frame must be dropped")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Assert(t, e.idle())
+}
+
+func TestFrameConnConditions(t *testing.T) {
+	hooks := &frameHooks{capture: newCaptureHub(), conditions: newLinkConditions()}
+	assert.NilError(t, hooks.conditions.set(InstanceConditions{
+		MACAddress: "52:55:55:00:00:01",
+		Conditions: networks.Conditions{Latency: "50ms"},
+	}))
+
+	vm, sw := net.Pipe()
+	conn := newFrameConn(sw, hooks, false)
+	defer conn.Close()
+
+	fromVM := testFrame("52:55:55:00:00:01", gatewayMacAddr, "192.168.104.3", "192.168.104.2", ipProtoUDP, 40000, 53)
+	start := time.Now()
+	go func() {
+		_, _ = vm.Write(fromVM)
+	}()
+	buf := make([]byte, 65536)
+	n, err := conn.Read(buf)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, buf[:n], fromVM)
+	assert.Assert(t, time.Since(start) >= 50*time.Millisecond, time.Since(start))
+
+	toVM := testFrame(gatewayMacAddr, "52:55:55:00:00:01", "192.168.104.2", "192.168.104.3", ipProtoUDP, 53, 40000)
+	start = time.Now()
+	// The switch is not blocked while the frame is delayed
+	_, err = conn.Write(toVM)
+	assert.NilError(t, err)
+	assert.Assert(t, time.Since(start) < 50*time.Millisecond, time.Since(start))
+	n, err = vm.Read(buf)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, buf[:n], toVM)
+	assert.Assert(t, time.Since(start) >= 50*time.Millisecond, time.Since(start))
+
+	// Restoring a perfect link
+	assert.NilError(t, hooks.conditions.set(InstanceConditions{MACAddress: "52:55:55:00:00:01"}))
+	start = time.Now()
+	go func() {
+		_, _ = conn.Write(toVM)
+	}()
+	_, err = vm.Read(buf)
+	assert.NilError(t, err)
+	assert.Assert(t, time.Since(start) < 50*time.Millisecond, time.Since(start))
+}
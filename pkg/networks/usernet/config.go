@@ -3,6 +3,7 @@ package usernet
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"path/filepath"
 
 	"github.com/apparentlymart/go-cidr/cidr"
@@ -94,6 +95,72 @@ func DNSIP(subnet net.IP) string {
 	return cidr.Inc(cidr.Inc(cidr.Inc(subnet))).String()
 }
 
+// IPv6Subnet returns the IPv6 subnet for the given network name.
+// IPv6Subnet returns the zero netip.Prefix when IPv6 is not enabled for the network.
+func IPv6Subnet(name string) (netip.Prefix, error) {
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	err = cfg.Check(name)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	nw := cfg.Networks[name]
+	return nw.IPv6Prefix()
+}
+
+// DefaultConditions returns the default link conditions of the instances for the given network name.
+// DefaultConditions returns nil when the network does not define conditions.
+func DefaultConditions(name string) (*networks.Conditions, error) {
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Check(name)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Networks[name].Conditions, nil
+}
+
+// EgressPolicy returns the egress policy for the given network name.
+// EgressPolicy returns nil when the network does not restrict the connections to the outside.
+func EgressPolicy(name string) (*networks.EgressPolicy, error) {
+	cfg, err := networks.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Check(name)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Networks[name].Egress, nil
+}
+
+// IPv6GatewayIP returns the 2nd IP for the given IPv6 subnet.
+func IPv6GatewayIP(subnet netip.Prefix) netip.Addr {
+	return subnet.Masked().Addr().Next().Next()
+}
+
+// IPv6Address returns the IPv6 address of the VM with the given MAC address.
+// The interface identifier is the modified EUI-64 format of the MAC address, as used by SLAAC.
+func IPv6Address(subnet netip.Prefix, macAddress string) (netip.Addr, error) {
+	mac, err := net.ParseMAC(macAddress)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	if len(mac) != 6 {
+		return netip.Addr{}, fmt.Errorf("invalid MAC address %q: expected 6 bytes", macAddress)
+	}
+	addr := subnet.Masked().Addr().As16()
+	addr[8] = mac[0] ^ 0x02
+	addr[9], addr[10] = mac[1], mac[2]
+	addr[11], addr[12] = 0xff, 0xfe
+	addr[13], addr[14], addr[15] = mac[3], mac[4], mac[5]
+	return netip.AddrFrom16(addr), nil
+}
+
 // Leases returns a leases file based on network name.
 func Leases(name string) (string, error) {
 	dir, err := dirnames.LimaNetworksDir()
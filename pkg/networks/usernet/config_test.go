@@ -2,6 +2,7 @@ package usernet
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/lima-vm/lima/pkg/networks"
@@ -26,4 +27,20 @@ func TestUsernetConfig(t *testing.T) {
 		assert.NilError(t, err)
 		assert.Equal(t, subnet.String(), "192.168.104.0")
 	})
+
+	t.Run("verify ipv6 addresses", func(t *testing.T) {
+		subnet := netip.MustParsePrefix("fd00:6c69:6d61::/64")
+		assert.Equal(t, IPv6GatewayIP(subnet).String(), "fd00:6c69:6d61::2")
+		addr, err := IPv6Address(subnet, "52:55:55:12:34:56")
+		assert.NilError(t, err)
+		assert.Equal(t, addr.String(), "fd00:6c69:6d61:0:5055:55ff:fe12:3456")
+		_, err = IPv6Address(subnet, "foo")
+		assert.ErrorContains(t, err, "invalid MAC address")
+	})
+
+	t.Run("verify ipv6 subnet via config", func(t *testing.T) {
+		subnet, err := IPv6Subnet("user-v2")
+		assert.NilError(t, err)
+		assert.Assert(t, !subnet.IsValid())
+	})
 }
@@ -26,16 +26,33 @@
 package dnshosts
 
 import (
+	"cmp"
 	"net"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/containers/gvisor-tap-vsock/pkg/types"
 )
 
+// ExtractZones converts the hosts to the zones of gvisor-tap-vsock.
+// A host may be a pattern: "*.example.com" matches the subdomains of example.com,
+// and ".example.com" matches example.com and its subdomains.
+// In a zone, the exact records precede the patterns, which are sorted from the most specific one.
 func ExtractZones(hosts hostMap) []types.Zone {
 	list := make(map[string]types.Zone)
+	patterns := make(map[string][]pattern)
 
 	for host := range hosts {
+		if p, ok := parsePattern(host); ok {
+			p.ip = hosts.hostIP(host)
+			h := zoneHost(p.domain)
+			patterns[h.name()] = append(patterns[h.name()], p)
+			if _, ok := list[h.name()]; !ok {
+				list[h.name()] = types.Zone{Name: h.name()}
+			}
+			continue
+		}
 		h := zoneHost(host)
 
 		zone := types.Zone{Name: h.name()}
@@ -58,12 +75,62 @@ func ExtractZones(hosts hostMap) []types.Zone {
 	}
 
 	zones := make([]types.Zone, 0, len(list))
-	for _, zone := range list {
+	for name, zone := range list {
+		slices.SortFunc(patterns[name], comparePatterns)
+		for _, p := range patterns[name] {
+			zone.Records = append(zone.Records, p.record())
+		}
 		zones = append(zones, zone)
 	}
 	return zones
 }
 
+// pattern is a wildcard ("*.example.com") or a suffix (".example.com") host.
+type pattern struct {
+	domain string // e.g., "example.com"
+	apex   bool   // true for the suffix hosts, that also match the domain itself
+	ip     net.IP
+}
+
+func parsePattern(host string) (pattern, bool) {
+	if domain, ok := strings.CutPrefix(host, "*."); ok && domain != "" {
+		return pattern{domain: domain}, true
+	}
+	if domain, ok := strings.CutPrefix(host, "."); ok && domain != "" {
+		return pattern{domain: domain, apex: true}, true
+	}
+	return pattern{}, false
+}
+
+// comparePatterns sorts the patterns with the most labels first.
+// A wildcard is more specific than a suffix of the same domain, as it does not match the domain itself.
+func comparePatterns(a, b pattern) int {
+	if c := cmp.Compare(strings.Count(b.domain, "."), strings.Count(a.domain, ".")); c != 0 {
+		return c
+	}
+	if a.apex != b.apex {
+		if a.apex {
+			return 1
+		}
+		return -1
+	}
+	return strings.Compare(a.domain, b.domain)
+}
+
+// record returns the record that matches the name without the zone suffix.
+// The apex of a single-label domain is the zone itself, which gvisor-tap-vsock does not match.
+func (p pattern) record() types.Record {
+	expr := "^.+$"
+	if name := zoneHost(p.domain).recordName(); name != "" {
+		if p.apex {
+			expr = `^(.+\.)?` + regexp.QuoteMeta(name) + "$"
+		} else {
+			expr = `^.+\.` + regexp.QuoteMeta(name) + "$"
+		}
+	}
+	return types.Record{Regexp: regexp.MustCompile(expr), IP: p.ip}
+}
+
 type hostMap map[string]string
 
 func (z hostMap) hostIP(host string) net.IP {
@@ -186,3 +186,48 @@ func TestExtractZones(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractZonesPatterns(t *testing.T) {
+	hosts := hostMap{
+		"host.lima.internal": "192.168.5.2",
+		"*.dev.test":         "host.lima.internal",
+		".api.dev.test":      "192.168.5.15",
+		"*.api.dev.test":     "192.168.5.16",
+		"exact.api.dev.test": "192.168.5.17",
+	}
+	zones := ExtractZones(hosts)
+	i := slices.IndexFunc(zones, func(z types.Zone) bool { return z.Name == "test." })
+	if i < 0 {
+		t.Fatalf("zone \"test.\" not found in %+v", zones)
+	}
+	records := zones[i].Records
+	if len(records) != 4 {
+		t.Fatalf("records = %+v, want 4 records", records)
+	}
+	// The first record that matches wins
+	match := func(name string) net.IP {
+		for _, record := range records {
+			if record.Name == name || (record.Regexp != nil && record.Regexp.MatchString(name)) {
+				return record.IP
+			}
+		}
+		return nil
+	}
+	tests := []struct {
+		name string
+		want net.IP
+	}{
+		{name: "foo.dev", want: net.ParseIP("192.168.5.2")},
+		{name: "foo.bar.dev", want: net.ParseIP("192.168.5.2")},
+		{name: "api.dev", want: net.ParseIP("192.168.5.15")},
+		{name: "foo.api.dev", want: net.ParseIP("192.168.5.16")},
+		{name: "exact.api.dev", want: net.ParseIP("192.168.5.17")},
+		{name: "dev", want: nil},
+		{name: "foodev", want: nil},
+	}
+	for _, tt := range tests {
+		if got := match(tt.name); !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package usernet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minDNSNameTTL is the minimum time for which the addresses in a DNS response are associated with the names,
+	// as the resolvers of the VMs may cache the responses for longer than their TTL.
+	minDNSNameTTL = 5 * time.Minute
+	// blockedLogInterval is the minimum interval between the log messages for the same blocked flow.
+	blockedLogInterval = time.Minute
+	// maxLoggedFlows limits the memory used for rate-limiting the log messages.
+	maxLoggedFlows = 4096
+)
+
+// egressFilter enforces the egress policy of the network on the packets sent by the VMs to the outside.
+// The VM-to-VM traffic is not restricted, and neither are DHCP and DNS queries sent to the gateway.
+// Blocked packets are dropped, and the blocked flows are logged.
+type egressFilter struct {
+	policy    *networks.EgressPolicy
+	rules     []egressRule
+	gatewayIP netip.Addr
+	// gatewayIPv6 is the zero netip.Addr when IPv6 is not enabled
+	gatewayIPv6 netip.Addr
+	// names is nil when the policy has no rules for DNS names
+	names *dnsNames
+
+	logMu  sync.Mutex
+	logged map[egressFlow]time.Time
+}
+
+type egressRule struct {
+	networks.EgressRule
+	index  int
+	prefix netip.Prefix
+}
+
+type egressFlow struct {
+	protocol string
+	src, dst netip.Addr
+	port     uint16
+}
+
+func newEgressFilter(policy *networks.EgressPolicy, gatewayIP net.IP, gatewayIPv6 netip.Addr) (*egressFilter, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	gateway, ok := netip.AddrFromSlice(gatewayIP.To4())
+	if !ok {
+		return nil, fmt.Errorf("invalid gateway IP %q", gatewayIP)
+	}
+	f := &egressFilter{
+		policy:      policy,
+		gatewayIP:   gateway,
+		gatewayIPv6: gatewayIPv6,
+		logged:      make(map[egressFlow]time.Time),
+	}
+	for i, rule := range policy.Rules {
+		prefix, err := rule.Prefix()
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, egressRule{EgressRule: rule, index: i, prefix: prefix})
+		if rule.Host != "" && f.names == nil {
+			f.names = newDNSNames()
+		}
+	}
+	return f, nil
+}
+
+// allowFrame returns false when the frame sent by a VM must be dropped.
+// Only IPv4 frames are checked; the IPv6 connections are checked by the ipv6Gateway.
+func (f *egressFilter) allowFrame(frame []byte) bool {
+	if len(frame) < 14 || !bytes.Equal(frame[0:6], gatewayHardwareAddr) || binary.BigEndian.Uint16(frame[12:14]) != etherTypeIPv4 {
+		return true
+	}
+	pkt, _ := decodeFrame(frame)
+	if !pkt.dstIP.IsValid() || !pkt.dstIP.IsGlobalUnicast() {
+		// Broadcast (e.g. DHCP), multicast, and link-local
+		return true
+	}
+	var protocol string
+	switch pkt.ipProto {
+	case ipProtoTCP, ipProtoUDP:
+		if !pkt.hasPorts {
+			// The following fragments of a packet are dropped by the reassembly when the first fragment was dropped
+			return true
+		}
+		protocol = "tcp"
+		if pkt.ipProto == ipProtoUDP {
+			protocol = "udp"
+		}
+	case ipProtoICMP:
+		protocol = "icmp"
+	default:
+		protocol = "ip proto " + strconv.Itoa(int(pkt.ipProto))
+	}
+	if pkt.dstIP == f.gatewayIP && (pkt.dstPort == 53 || protocol == "udp" && pkt.dstPort == 67) {
+		return true
+	}
+	return f.allow(protocol, pkt.srcIP, pkt.dstIP, pkt.dstPort)
+}
+
+// allow returns true when the policy allows the connection, and logs the blocked connections.
+func (f *egressFilter) allow(protocol string, src, dst netip.Addr, port uint16) bool {
+	names := f.names.lookup(dst)
+	rule := f.match(protocol, dst, port, names)
+	if rule == nil && f.policy.DefaultAllows() || rule != nil && rule.Action == networks.EgressAllow {
+		return true
+	}
+	if f.shouldLog(egressFlow{protocol: protocol, src: src, dst: dst, port: port}) {
+		by := "the default policy"
+		if rule != nil {
+			by = fmt.Sprintf("rules[%d]", rule.index)
+		}
+		target := dst.String()
+		if protocol == "tcp" || protocol == "udp" {
+			target = net.JoinHostPort(target, strconv.Itoa(int(port)))
+		}
+		if len(names) > 0 {
+			target += " (" + strings.Join(names, ", ") + ")"
+		}
+		logrus.Warnf("Blocked %s flow from %s to %s by %s", protocol, src, target, by)
+	}
+	return false
+}
+
+// match returns the first rule matching the connection, or nil.
+func (f *egressFilter) match(protocol string, dst netip.Addr, port uint16, names []string) *egressRule {
+	for i := range f.rules {
+		rule := &f.rules[i]
+		if rule.Protocol != "" && rule.Protocol != protocol {
+			continue
+		}
+		if len(rule.Ports) > 0 && !slices.Contains(rule.Ports, int(port)) {
+			continue
+		}
+		if rule.prefix.IsValid() && !rule.prefix.Contains(dst.Unmap()) {
+			continue
+		}
+		if rule.Host != "" && !slices.ContainsFunc(names, rule.MatchHost) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+func (f *egressFilter) shouldLog(flow egressFlow) bool {
+	now := time.Now()
+	f.logMu.Lock()
+	defer f.logMu.Unlock()
+	if last, ok := f.logged[flow]; ok && now.Sub(last) < blockedLogInterval {
+		return false
+	}
+	if len(f.logged) >= maxLoggedFlows {
+		for flow, last := range f.logged {
+			if now.Sub(last) >= blockedLogInterval {
+				delete(f.logged, flow)
+			}
+		}
+		if len(f.logged) >= maxLoggedFlows {
+			return false
+		}
+	}
+	f.logged[flow] = now
+	return true
+}
+
+// learnFrame associates the addresses with the names in the DNS responses sent to the VMs over UDP.
+// Only the responses of the DNS server of the gateway are trusted; the responses of other DNS servers,
+// which might be controlled by the VMs themselves, are ignored.
+func (f *egressFilter) learnFrame(frame []byte) {
+	if f.names == nil || len(frame) < 14 || !bytes.Equal(frame[6:12], gatewayHardwareAddr) {
+		return
+	}
+	var udp []byte
+	switch binary.BigEndian.Uint16(frame[12:14]) {
+	case etherTypeIPv4:
+		ip := frame[14:]
+		if len(ip) < 20 || ip[9] != ipProtoUDP || binary.BigEndian.Uint16(ip[6:8])&0x3fff != 0 ||
+			netip.AddrFrom4([4]byte(ip[12:16])) != f.gatewayIP {
+			return
+		}
+		ihl := int(ip[0]&0x0f) * 4
+		if ihl < 20 || len(ip) < ihl {
+			return
+		}
+		udp = ip[ihl:]
+	case etherTypeIPv6:
+		ip := frame[14:]
+		if len(ip) < 40 || ip[6] != ipProtoUDP || !f.gatewayIPv6.IsValid() ||
+			netip.AddrFrom16([16]byte(ip[8:24])) != f.gatewayIPv6 {
+			return
+		}
+		udp = ip[40:]
+	default:
+		return
+	}
+	if len(udp) < 8 || binary.BigEndian.Uint16(udp[0:2]) != 53 {
+		return
+	}
+	msg := new(dns.Msg)
+	if err := msg.Unpack(udp[8:]); err != nil || !msg.Response {
+		return
+	}
+	f.names.learn(msg)
+}
+
+// dnsNames associates addresses with the DNS names that resolved to them.
+type dnsNames struct {
+	mu        sync.RWMutex
+	names     map[netip.Addr]map[string]time.Time // value: expiry, key: lower-case name without a trailing dot
+	lastPrune time.Time
+}
+
+func newDNSNames() *dnsNames {
+	return &dnsNames{names: make(map[netip.Addr]map[string]time.Time)}
+}
+
+func (d *dnsNames) learn(msg *dns.Msg) {
+	// The names of a CNAME chain all resolve to the addresses at its end
+	var names []string
+	for _, q := range msg.Question {
+		names = append(names, strings.ToLower(strings.TrimSuffix(q.Name, ".")))
+	}
+	for _, rr := range msg.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			names = append(names, strings.ToLower(strings.TrimSuffix(cname.Hdr.Name, ".")))
+		}
+	}
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, rr := range msg.Answer {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		default:
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ip)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		expiry := now.Add(max(time.Duration(rr.Header().Ttl)*time.Second, minDNSNameTTL))
+		if d.names[addr] == nil {
+			d.names[addr] = make(map[string]time.Time)
+		}
+		add := func(name string) {
+			if expiry.After(d.names[addr][name]) {
+				d.names[addr][name] = expiry
+			}
+		}
+		for _, name := range names {
+			add(name)
+		}
+		add(strings.ToLower(strings.TrimSuffix(rr.Header().Name, ".")))
+	}
+	if now.Sub(d.lastPrune) >= minDNSNameTTL {
+		d.lastPrune = now
+		for addr, names := range d.names {
+			for name, expiry := range names {
+				if now.After(expiry) {
+					delete(names, name)
+				}
+			}
+			if len(names) == 0 {
+				delete(d.names, addr)
+			}
+		}
+	}
+}
+
+// lookup returns the names that resolved to the address, sorted.
+func (d *dnsNames) lookup(addr netip.Addr) []string {
+	if d == nil {
+		return nil
+	}
+	now := time.Now()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var names []string
+	for name, expiry := range d.names[addr.Unmap()] {
+		if now.Before(expiry) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
@@ -0,0 +1,101 @@
+package usernet
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/miekg/dns"
+	"gotest.tools/v3/assert"
+)
+
+// testDNSResponse returns a frame carrying a DNS response from src to the VM.
+func testDNSResponse(t *testing.T, srcMAC, srcIP string, rrs ...string) []byte {
+	var msg dns.Msg
+	msg.SetQuestion("www.example.com.", dns.TypeA)
+	msg.Response = true
+	for _, s := range rrs {
+		rr, err := dns.NewRR(s)
+		assert.NilError(t, err)
+		msg.Answer = append(msg.Answer, rr)
+	}
+	payload, err := msg.Pack()
+	assert.NilError(t, err)
+	return append(testFrame(srcMAC, "52:55:55:00:00:01", srcIP, "192.168.104.3", ipProtoUDP, 53, 40000), payload...)
+}
+
+func TestEgressFilter(t *testing.T) {
+	f, err := newEgressFilter(&networks.EgressPolicy{
+		Default: networks.EgressDeny,
+		Rules: []networks.EgressRule{
+			{Action: networks.EgressDeny, Host: "blocked.example.com"},
+			{Action: networks.EgressAllow, Host: "*.example.com", Ports: []int{443}, Protocol: "tcp"},
+			{Action: networks.EgressAllow, CIDR: "1.1.1.0/24"},
+			{Action: networks.EgressAllow, CIDR: "2001:db8::/32", Protocol: "udp"},
+		},
+	}, net.ParseIP("192.168.104.2"), netip.MustParseAddr("fd00:6c69:6d61::2"))
+	assert.NilError(t, err)
+
+	const vm = "52:55:55:00:00:01"
+	fromVM := func(dstIP string, proto uint8, dstPort uint16) []byte {
+		return testFrame(vm, gatewayMacAddr, "192.168.104.3", dstIP, proto, 40000, dstPort)
+	}
+	// DNS and DHCP are always allowed, and VM-to-VM traffic is not restricted
+	assert.Assert(t, f.allowFrame(fromVM("192.168.104.2", ipProtoUDP, 53)))
+	assert.Assert(t, f.allowFrame(fromVM("255.255.255.255", ipProtoUDP, 67)))
+	assert.Assert(t, f.allowFrame(testFrame(vm, "52:55:55:00:00:02", "192.168.104.3", "192.168.104.4", ipProtoTCP, 40000, 22)))
+
+	assert.Assert(t, f.allowFrame(fromVM("1.1.1.1", ipProtoUDP, 53)))
+	assert.Assert(t, f.allowFrame(fromVM("1.1.1.1", ipProtoICMP, 0)))
+	assert.Assert(t, !f.allowFrame(fromVM("8.8.8.8", ipProtoUDP, 53)))
+	assert.Assert(t, !f.allowFrame(fromVM("192.168.104.2", ipProtoTCP, 80)))
+
+	// The addresses of the names are only learned from the DNS responses of the gateway
+	assert.Assert(t, !f.allowFrame(fromVM("93.184.215.14", ipProtoTCP, 443)))
+	f.learnFrame(testDNSResponse(t, gatewayMacAddr, "8.8.8.8", "www.example.com. 60 IN A 93.184.215.14"))
+	f.learnFrame(testDNSResponse(t, "52:55:55:00:00:02", "192.168.104.2", "www.example.com. 60 IN A 93.184.215.14"))
+	assert.Assert(t, !f.allowFrame(fromVM("93.184.215.14", ipProtoTCP, 443)))
+	f.learnFrame(testDNSResponse(t, gatewayMacAddr, "192.168.104.2",
+		"www.example.com. 60 IN CNAME cdn.example.net.",
+		"cdn.example.net. 60 IN A 93.184.215.14",
+		"cdn.example.net. 60 IN A 93.184.215.15"))
+	assert.DeepEqual(t, f.names.lookup(netip.MustParseAddr("93.184.215.15")), []string{"cdn.example.net", "www.example.com"})
+	assert.Assert(t, f.allowFrame(fromVM("93.184.215.14", ipProtoTCP, 443)))
+	assert.Assert(t, f.allowFrame(fromVM("93.184.215.15", ipProtoTCP, 443)))
+	assert.Assert(t, !f.allowFrame(fromVM("93.184.215.14", ipProtoTCP, 80)))
+	assert.Assert(t, !f.allowFrame(fromVM("93.184.215.14", ipProtoUDP, 443)))
+
+	// The first matching rule decides
+	f.learnFrame(testDNSResponse(t, gatewayMacAddr, "192.168.104.2", "blocked.example.com. 60 IN A 93.184.215.16"))
+	assert.Assert(t, !f.allowFrame(fromVM("93.184.215.16", ipProtoTCP, 443)))
+
+	// IPv6 connections are checked by the ipv6Gateway
+	vm6 := netip.MustParseAddr("fd00:6c69:6d61:0:5055:55ff:fe00:1")
+	assert.Assert(t, f.allow("udp", vm6, netip.MustParseAddr("2001:db8::1"), 53))
+	assert.Assert(t, !f.allow("tcp", vm6, netip.MustParseAddr("2001:db8::1"), 53))
+
+	// Blocked flows are only logged once per interval
+	flow := egressFlow{protocol: "tcp", src: vm6, dst: netip.MustParseAddr("2001:db8::1"), port: 53}
+	assert.Assert(t, !f.shouldLog(flow))
+	flow.port = 54
+	assert.Assert(t, f.shouldLog(flow))
+}
+
+func TestEgressFilterDefaultAllow(t *testing.T) {
+	f, err := newEgressFilter(&networks.EgressPolicy{
+		Rules: []networks.EgressRule{
+			{Action: networks.EgressDeny, CIDR: "10.0.0.0/8"},
+		},
+	}, net.ParseIP("192.168.104.2"), netip.Addr{})
+	assert.NilError(t, err)
+	assert.Assert(t, f.names == nil)
+	from := func(dstIP string) []byte {
+		return testFrame("52:55:55:00:00:01", gatewayMacAddr, "192.168.104.3", dstIP, ipProtoTCP, 40000, 443)
+	}
+	assert.Assert(t, f.allowFrame(from("1.1.1.1")))
+	assert.Assert(t, !f.allowFrame(from("10.1.2.3")))
+
+	_, err = newEgressFilter(&networks.EgressPolicy{Default: "reject"}, net.ParseIP("192.168.104.2"), netip.Addr{})
+	assert.ErrorContains(t, err, "field `default` must be")
+}
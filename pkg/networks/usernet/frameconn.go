@@ -0,0 +1,261 @@
+package usernet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lima-vm/lima/pkg/networks"
+	"github.com/sirupsen/logrus"
+)
+
+// frameHooks holds the Lima-specific handlers for the frames exchanged between the VMs and the virtual switch.
+type frameHooks struct {
+	capture    *captureHub
+	conditions *linkConditions
+	// egress is nil when the network has no egress policy
+	egress *egressFilter
+	// ipv6 is nil when IPv6 is not enabled
+	ipv6 *ipv6Gateway
+}
+
+// frameConn wraps a connection between a VM and the virtual switch, and passes the frames to the hooks.
+//
+// Frames received from the VM are always captured. Frames sent to the VM are only captured when they originate
+// from the gateway, because frames from other VMs have already been captured when they were received.
+// Frames sent by the VM to the outside are dropped when the egress policy of the network blocks them.
+// When IPv6 is enabled, the IPv6 frames are passed to the ipv6Gateway instead of the switch.
+// When link conditions are set for the MAC address of the VM, the frames are delayed and dropped in both directions.
+type frameConn struct {
+	net.Conn
+	hooks *frameHooks
+	// stream is true for the QEMU protocol, where each frame is prefixed with its length as a 4-byte big-endian integer.
+	// Otherwise each Read and Write carries exactly one frame.
+	stream bool
+	// mac is the MAC address of the VM, learned from the first frame received from the VM
+	mac atomic.Value
+
+	reader *bufio.Reader
+	buf    []byte
+	// rx passes the frames received from the VM, including the length prefix for stream connections, to Read
+	rx      chan []byte
+	rxErr   error
+	pending []byte
+	// done is closed when the connection is closed, or when receiving from the VM has failed
+	done      chan struct{}
+	closeOnce sync.Once
+
+	egress  *linkEmulator // from the VM
+	ingress *linkEmulator // to the VM
+
+	writeMu sync.Mutex
+}
+
+func newFrameConn(conn net.Conn, hooks *frameHooks, stream bool) *frameConn {
+	c := &frameConn{
+		Conn:   conn,
+		hooks:  hooks,
+		stream: stream,
+		rx:     make(chan []byte),
+		done:   make(chan struct{}),
+	}
+	if stream {
+		c.reader = bufio.NewReader(conn)
+	}
+	c.egress = newLinkEmulator(c.forward, c.done)
+	c.ingress = newLinkEmulator(func(b []byte) {
+		if _, err := c.writeToVM(b); err != nil {
+			logrus.WithError(err).Debug("Failed to write a delayed frame")
+		}
+	}, c.done)
+	if hooks.ipv6 != nil {
+		hooks.ipv6.connect(c)
+	}
+	go c.receive()
+	return c
+}
+
+// shutdown stops the goroutines of the connection; Read returns err afterwards.
+func (c *frameConn) shutdown(err error) {
+	c.closeOnce.Do(func() {
+		c.rxErr = err
+		close(c.done)
+	})
+}
+
+// Close implements net.Conn.
+func (c *frameConn) Close() error {
+	if c.hooks.ipv6 != nil {
+		c.hooks.ipv6.disconnect(c)
+	}
+	c.shutdown(net.ErrClosed)
+	return c.Conn.Close()
+}
+
+// Read implements net.Conn. For stream connections it returns the data of whole frames, including the length prefix.
+func (c *frameConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		select {
+		case data := <-c.rx:
+			if !c.stream {
+				return copy(b, data), nil
+			}
+			c.pending = data
+		case <-c.done:
+			return 0, c.rxErr
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// receive reads the frames from the VM until the connection fails.
+func (c *frameConn) receive() {
+	for {
+		data, err := c.readFrame()
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		if c.mac.Load() == nil {
+			// The first frame is sent by the VM itself, e.g. a DHCP request
+			if src := net.HardwareAddr(c.payload(data)[6:12]); src[0]&1 == 0 {
+				c.mac.Store(src.String())
+			}
+		}
+		if cond := c.conditions(); !cond.IsZero() || !c.egress.idle() {
+			c.egress.send(data, cond)
+			continue
+		}
+		c.forward(data)
+	}
+}
+
+// readFrame returns the data of the next frame received from the VM, including the length prefix for stream connections.
+// The frame is at least an Ethernet header long.
+func (c *frameConn) readFrame() ([]byte, error) {
+	for {
+		var data []byte
+		if c.stream {
+			var size [4]byte
+			if _, err := io.ReadFull(c.reader, size[:]); err != nil {
+				return nil, err
+			}
+			data = make([]byte, 4+binary.BigEndian.Uint32(size[:]))
+			copy(data, size[:])
+			if _, err := io.ReadFull(c.reader, data[4:]); err != nil {
+				return nil, err
+			}
+		} else {
+			if c.buf == nil {
+				c.buf = make([]byte, 65536)
+			}
+			n, err := c.Conn.Read(c.buf)
+			if err != nil {
+				return nil, err
+			}
+			data = bytes.Clone(c.buf[:n])
+		}
+		if len(c.payload(data)) >= 14 {
+			return data, nil
+		}
+	}
+}
+
+// payload strips the length prefix of stream connections from data.
+func (c *frameConn) payload(data []byte) []byte {
+	if c.stream {
+		return data[4:]
+	}
+	return data
+}
+
+// conditions returns the emulated link conditions of the VM.
+func (c *frameConn) conditions() networks.ParsedConditions {
+	mac, _ := c.mac.Load().(string)
+	if mac == "" {
+		return networks.ParsedConditions{}
+	}
+	return c.hooks.conditions.get(mac)
+}
+
+// forward passes the data of a frame received from the VM to the hooks, and then to Read.
+func (c *frameConn) forward(data []byte) {
+	if c.handleFrame(c.payload(data)) {
+		return
+	}
+	select {
+	case c.rx <- data:
+	case <-c.done:
+	}
+}
+
+// handleFrame passes a frame received from the VM to the hooks.
+// It returns true when the frame has been consumed, and must not be passed to the switch.
+func (c *frameConn) handleFrame(frame []byte) bool {
+	c.hooks.capture.publish(frame)
+	if c.hooks.egress != nil && !c.hooks.egress.allowFrame(frame) {
+		return true
+	}
+	if c.hooks.ipv6 != nil && isIPv6Frame(frame) {
+		c.hooks.ipv6.receive(c, frame)
+		return true
+	}
+	return false
+}
+
+// writeFrame sends a frame, which did not pass the switch, to the VM.
+func (c *frameConn) writeFrame(frame []byte) (int, error) {
+	if c.stream {
+		frame = append(binary.BigEndian.AppendUint32(nil, uint32(len(frame))), frame...)
+	}
+	return c.Write(frame)
+}
+
+// Write implements net.Conn.
+// The virtual switch always writes whole frames, so each Write is inspected on its own.
+func (c *frameConn) Write(b []byte) (int, error) {
+	if c.hooks.capture.active.Load() != 0 || c.hooks.egress != nil {
+		if c.stream {
+			for frames := b; len(frames) >= 4; {
+				size := int(binary.BigEndian.Uint32(frames[:4]))
+				if len(frames) < 4+size {
+					break
+				}
+				c.inspectSent(frames[4 : 4+size])
+				frames = frames[4+size:]
+			}
+		} else {
+			c.inspectSent(b)
+		}
+	}
+	if cond := c.conditions(); !cond.IsZero() || !c.ingress.idle() {
+		c.ingress.send(bytes.Clone(b), cond)
+		return len(b), nil
+	}
+	return c.writeToVM(b)
+}
+
+func (c *frameConn) writeToVM(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.Write(b)
+}
+
+var gatewayHardwareAddr, _ = net.ParseMAC(gatewayMacAddr)
+
+// inspectSent passes a frame sent to the VM to the hooks.
+func (c *frameConn) inspectSent(frame []byte) {
+	if len(frame) < 12 || !bytes.Equal(frame[6:12], gatewayHardwareAddr) {
+		return
+	}
+	c.hooks.capture.publish(frame)
+	if c.hooks.egress != nil {
+		c.hooks.egress.learnFrame(frame)
+	}
+}
@@ -0,0 +1,49 @@
+package usernet
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestFrameConn(t *testing.T) {
+	hooks := &frameHooks{capture: newCaptureHub()}
+	sub := hooks.capture.subscribe(nil)
+	defer hooks.capture.unsubscribe(sub)
+
+	vm, sw := net.Pipe()
+	conn := newFrameConn(sw, hooks, true)
+
+	fromVM := testFrame("52:55:55:00:00:01", gatewayMacAddr, "192.168.104.3", "192.168.104.2", ipProtoUDP, 40000, 53)
+	go func() {
+		var stream []byte
+		stream = binary.BigEndian.AppendUint32(stream, uint32(len(fromVM)))
+		stream = append(stream, fromVM...)
+		_, _ = vm.Write(stream)
+	}()
+	// The switch must see the unmodified stream, even when reading in small chunks
+	got := make([]byte, 4+len(fromVM))
+	_, err := io.ReadFull(io.LimitReader(conn, int64(len(got))), got)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got[4:], fromVM)
+	assert.DeepEqual(t, (<-sub.frames).data, fromVM)
+
+	// Frames sent to the VM are only captured when they originate from the gateway
+	fromGateway := testFrame(gatewayMacAddr, "52:55:55:00:00:01", "192.168.104.2", "192.168.104.3", ipProtoUDP, 53, 40000)
+	fromOtherVM := testFrame("52:55:55:00:00:02", "52:55:55:00:00:01", "192.168.104.4", "192.168.104.3", ipProtoUDP, 53, 40000)
+	go func() {
+		_, _ = io.Copy(io.Discard, vm)
+	}()
+	for _, frame := range [][]byte{fromGateway, fromOtherVM} {
+		var stream []byte
+		stream = binary.BigEndian.AppendUint32(stream, uint32(len(frame)))
+		stream = append(stream, frame...)
+		_, err = conn.Write(stream)
+		assert.NilError(t, err)
+	}
+	assert.DeepEqual(t, (<-sub.frames).data, fromGateway)
+	assert.Equal(t, len(sub.frames), 0)
+}
@@ -2,10 +2,12 @@ package usernet
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"fmt"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"runtime"
 	"strings"
@@ -15,6 +17,7 @@ import (
 	"github.com/containers/gvisor-tap-vsock/pkg/transport"
 	"github.com/containers/gvisor-tap-vsock/pkg/types"
 	"github.com/containers/gvisor-tap-vsock/pkg/virtualnetwork"
+	"github.com/lima-vm/lima/pkg/networks"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 )
@@ -27,6 +30,10 @@ type GVisorNetstackOpts struct {
 	Endpoint   string
 
 	Subnet string
+	// SubnetIPv6 enables IPv6 with the given /64 subnet, in addition to IPv4.
+	SubnetIPv6 string
+	// EgressPolicy restricts the connections from the VMs to the outside; nil allows all connections.
+	EgressPolicy *networks.EgressPolicy
 
 	Async bool
 
@@ -99,16 +106,51 @@ func run(ctx context.Context, g *errgroup.Group, configuration *types.Configurat
 	if err != nil {
 		return err
 	}
-	httpServe(ctx, g, ln, vn.Mux())
+	hooks := &frameHooks{
+		capture:    newCaptureHub(),
+		conditions: newLinkConditions(),
+	}
+	mux := vn.Mux()
+	mux.Handle(CapturePath, hooks.capture)
+	mux.Handle(ConditionsPath, hooks.conditions)
+	var subnetIPv6 netip.Prefix
+	if opts.SubnetIPv6 != "" {
+		if subnetIPv6, err = parseIPv6Subnet(opts.SubnetIPv6); err != nil {
+			return err
+		}
+	}
+	if opts.EgressPolicy != nil {
+		var gatewayIPv6 netip.Addr
+		if subnetIPv6.IsValid() {
+			gatewayIPv6 = IPv6GatewayIP(subnetIPv6)
+		}
+		if hooks.egress, err = newEgressFilter(opts.EgressPolicy, net.ParseIP(configuration.GatewayIP), gatewayIPv6); err != nil {
+			return err
+		}
+		logrus.Infof("Enforcing the egress policy (default: %s, %d rules)", cmp.Or(opts.EgressPolicy.Default, networks.EgressAllow), len(opts.EgressPolicy.Rules))
+	}
+	if subnetIPv6.IsValid() {
+		hooks.ipv6, err = newIPv6Gateway(ctx, subnetIPv6, configuration.MTU, hooks.egress)
+		if err != nil {
+			return err
+		}
+		forwarder := newIPv6Forwarder(hooks.ipv6.stack)
+		go func() {
+			<-ctx.Done()
+			forwarder.closeAll()
+		}()
+		mux.Handle(IPv6ForwarderPath+"/", http.StripPrefix(IPv6ForwarderPath, forwarder.Mux()))
+	}
+	httpServe(ctx, g, ln, mux)
 
 	if opts.QemuSocket != "" {
-		err = listenQEMU(ctx, vn)
+		err = listenQEMU(ctx, vn, hooks)
 		if err != nil {
 			return err
 		}
 	}
 	if opts.FdSocket != "" {
-		err = listenFD(ctx, vn)
+		err = listenFD(ctx, vn, hooks)
 		if err != nil {
 			return err
 		}
@@ -116,7 +158,7 @@ func run(ctx context.Context, g *errgroup.Group, configuration *types.Configurat
 	return nil
 }
 
-func listenQEMU(ctx context.Context, vn *virtualnetwork.VirtualNetwork) error {
+func listenQEMU(ctx context.Context, vn *virtualnetwork.VirtualNetwork, hooks *frameHooks) error {
 	listener, err := net.Listen("unix", opts.QemuSocket)
 	if err != nil {
 		return err
@@ -131,7 +173,7 @@ func listenQEMU(ctx context.Context, vn *virtualnetwork.VirtualNetwork) error {
 			}
 
 			go func() {
-				err = vn.AcceptQemu(ctx, conn)
+				err = vn.AcceptQemu(ctx, newFrameConn(conn, hooks, true))
 				if err != nil {
 					logrus.Error("QEMU connection closed with error", err)
 				}
@@ -149,7 +191,7 @@ func listenQEMU(ctx context.Context, vn *virtualnetwork.VirtualNetwork) error {
 	return nil
 }
 
-func listenFD(ctx context.Context, vn *virtualnetwork.VirtualNetwork) error {
+func listenFD(ctx context.Context, vn *virtualnetwork.VirtualNetwork, hooks *frameHooks) error {
 	listener, err := net.Listen("unix", opts.FdSocket)
 	if err != nil {
 		return err
@@ -178,7 +220,7 @@ func listenFD(ctx context.Context, vn *virtualnetwork.VirtualNetwork) error {
 			files[0].Close()
 
 			go func() {
-				err = vn.AcceptBess(ctx, &UDPFileConn{Conn: fileConn})
+				err = vn.AcceptBess(ctx, newFrameConn(&UDPFileConn{Conn: fileConn}, hooks, false))
 				if err != nil {
 					logrus.Error("FD connection closed with error", err)
 				}
@@ -0,0 +1,363 @@
+package usernet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/services/forwarder"
+	"github.com/containers/gvisor-tap-vsock/pkg/tcpproxy"
+	hostagentdns "github.com/lima-vm/lima/pkg/hostagent/dns"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const ipv6NICID = 1
+
+// ipv6Gateway implements IPv6 for the usernet network, as gvisor-tap-vsock only implements IPv4.
+//
+// The IPv6 frames of the VMs never reach the gvisor-tap-vsock switch, which does not forward multicast frames,
+// so Neighbor Discovery would not work. Instead, they are switched by the ipv6Gateway itself.
+// The frames for the gateway are passed to a separate gvisor network stack, which serves DNS
+// on the gateway address, and forwards TCP and UDP connections to the host (NAT).
+// Connections to the gateway address are forwarded to the loopback address of the host.
+//
+// The VMs are configured with static addresses (see IPv6Address), so there is no SLAAC or DHCPv6 server.
+type ipv6Gateway struct {
+	subnet    netip.Prefix
+	gatewayIP netip.Addr
+	stack     *stack.Stack
+	endpoint  *channel.Endpoint
+	// udpDNS and tcpDNS resolve the DNS queries of the VMs on the host.
+	udpDNS, tcpDNS dns.Handler
+	// egress is nil when the network has no egress policy
+	egress *egressFilter
+
+	mu    sync.RWMutex
+	conns map[*frameConn]struct{}
+	cam   map[string]*frameConn // key: MAC address of the VM
+}
+
+func newIPv6Gateway(ctx context.Context, subnet netip.Prefix, mtu int, egress *egressFilter) (*ipv6Gateway, error) {
+	g := &ipv6Gateway{
+		egress:    egress,
+		subnet:    subnet,
+		gatewayIP: IPv6GatewayIP(subnet),
+		endpoint:  channel.New(512, uint32(mtu+header.EthernetMinimumSize), tcpip.LinkAddress(gatewayHardwareAddr)),
+		conns:     make(map[*frameConn]struct{}),
+		cam:       make(map[string]*frameConn),
+	}
+	g.stack = stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{
+			ipv6.NewProtocol,
+		},
+		TransportProtocols: []stack.TransportProtocolFactory{
+			tcp.NewProtocol,
+			udp.NewProtocol,
+			icmp.NewProtocol6,
+		},
+	})
+	if err := g.stack.CreateNIC(ipv6NICID, ethernet.New(g.endpoint)); err != nil {
+		return nil, errors.New(err.String())
+	}
+	if err := g.stack.AddProtocolAddress(ipv6NICID, tcpip.ProtocolAddress{
+		Protocol: ipv6.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{
+			Address:   tcpip.AddrFrom16(g.gatewayIP.As16()),
+			PrefixLen: subnet.Bits(),
+		},
+	}, stack.AddressProperties{}); err != nil {
+		return nil, errors.New(err.String())
+	}
+	// Accept the connections of the VMs to any address, so that they can be forwarded to the host
+	g.stack.SetSpoofing(ipv6NICID, true)
+	g.stack.SetPromiscuousMode(ipv6NICID, true)
+	routeSubnet, err := tcpip.NewSubnet(tcpip.AddrFrom16(subnet.Masked().Addr().As16()),
+		tcpip.MaskFromBytes(net.CIDRMask(subnet.Bits(), 128)))
+	if err != nil {
+		return nil, err
+	}
+	g.stack.SetRouteTable([]tcpip.Route{
+		{
+			Destination: routeSubnet,
+			NIC:         ipv6NICID,
+		},
+	})
+
+	tcpForwarder := tcp.NewForwarder(g.stack, 0, 10, g.forwardTCP)
+	g.stack.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpForwarder.HandlePacket)
+	udpForwarder := udp.NewForwarder(g.stack, g.forwardUDP)
+	g.stack.SetTransportProtocolHandler(udp.ProtocolNumber, udpForwarder.HandlePacket)
+
+	if err := g.serveDNS(ctx); err != nil {
+		return nil, err
+	}
+	go g.transmit(ctx)
+	go func() {
+		<-ctx.Done()
+		g.stack.Close()
+	}()
+	return g, nil
+}
+
+// connect registers a connection to a VM, so that it receives the multicast frames.
+func (g *ipv6Gateway) connect(c *frameConn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.conns[c] = struct{}{}
+}
+
+func (g *ipv6Gateway) disconnect(c *frameConn) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.conns, c)
+	for mac, conn := range g.cam {
+		if conn == c {
+			delete(g.cam, mac)
+		}
+	}
+}
+
+// receive switches an IPv6 frame received from a VM.
+func (g *ipv6Gateway) receive(c *frameConn, frame []byte) {
+	if len(frame) < header.EthernetMinimumSize+header.IPv6MinimumSize {
+		return
+	}
+	g.mu.Lock()
+	g.cam[string(frame[6:12])] = c
+	g.mu.Unlock()
+
+	dst := frame[0:6]
+	switch {
+	case bytes.Equal(dst, gatewayHardwareAddr):
+		g.deliver(frame)
+	case header.IsMulticastEthernetAddress(tcpip.LinkAddress(dst)):
+		// Neighbor Solicitations are only passed to the stack when they are for the gateway,
+		// as the promiscuous stack would otherwise claim the addresses of the VMs
+		if target, ok := neighborSolicitationTarget(frame); !ok || target == g.gatewayIP {
+			g.deliver(frame)
+		}
+		g.send(c, frame)
+	default:
+		g.send(c, frame)
+	}
+}
+
+// deliver passes a frame to the stack.
+func (g *ipv6Gateway) deliver(frame []byte) {
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(frame),
+	})
+	g.endpoint.InjectInbound(ipv6.ProtocolNumber, pkt)
+	pkt.DecRef()
+}
+
+// send writes a frame to the VM with the destination MAC address, or to all VMs except the sender
+// when the address is a multicast address or unknown.
+func (g *ipv6Gateway) send(from *frameConn, frame []byte) {
+	g.mu.RLock()
+	var conns []*frameConn
+	if conn, ok := g.cam[string(frame[0:6])]; ok {
+		conns = append(conns, conn)
+	} else {
+		for conn := range g.conns {
+			conns = append(conns, conn)
+		}
+	}
+	g.mu.RUnlock()
+	for _, conn := range conns {
+		if conn == from {
+			continue
+		}
+		if _, err := conn.writeFrame(frame); err != nil {
+			logrus.WithError(err).Debug("Failed to write an IPv6 frame")
+		}
+	}
+}
+
+// transmit sends the frames of the stack to the VMs.
+func (g *ipv6Gateway) transmit(ctx context.Context) {
+	for {
+		pkt := g.endpoint.ReadContext(ctx)
+		if pkt == nil {
+			return
+		}
+		view := pkt.ToView()
+		pkt.DecRef()
+		g.send(nil, view.AsSlice())
+		view.Release()
+	}
+}
+
+// neighborSolicitationTarget returns the target address of the frame when it is a Neighbor Solicitation.
+func neighborSolicitationTarget(frame []byte) (netip.Addr, bool) {
+	ip := header.IPv6(frame[header.EthernetMinimumSize:])
+	if ip.NextHeader() != uint8(header.ICMPv6ProtocolNumber) {
+		return netip.Addr{}, false
+	}
+	icmpv6 := header.ICMPv6(ip.Payload())
+	if len(icmpv6) < header.ICMPv6NeighborSolicitMinimumSize || icmpv6.Type() != header.ICMPv6NeighborSolicit {
+		return netip.Addr{}, false
+	}
+	target := header.NDPNeighborSolicit(icmpv6.MessageBody()).TargetAddress()
+	return netip.AddrFrom16(target.As16()), true
+}
+
+// hostAddrs returns the host addresses to forward a connection to, in order of preference.
+func (g *ipv6Gateway) hostAddrs(dst tcpip.Address) ([]netip.Addr, bool) {
+	addr := netip.AddrFrom16(dst.As16())
+	switch {
+	case addr == g.gatewayIP:
+		return []netip.Addr{netip.IPv6Loopback(), netip.AddrFrom4([4]byte{127, 0, 0, 1})}, true
+	case g.subnet.Contains(addr), !addr.IsGlobalUnicast():
+		return nil, false
+	default:
+		return []netip.Addr{addr}, true
+	}
+}
+
+// allowEgress returns true when the egress policy allows the connection.
+func (g *ipv6Gateway) allowEgress(protocol string, id stack.TransportEndpointID) bool {
+	return g.egress == nil || g.egress.allow(protocol,
+		netip.AddrFrom16(id.RemoteAddress.As16()), netip.AddrFrom16(id.LocalAddress.As16()), id.LocalPort)
+}
+
+func (g *ipv6Gateway) forwardTCP(r *tcp.ForwarderRequest) {
+	id := r.ID()
+	addrs, ok := g.hostAddrs(id.LocalAddress)
+	if !ok || !g.allowEgress("tcp", id) {
+		r.Complete(true)
+		return
+	}
+	var outbound net.Conn
+	var err error
+	for _, addr := range addrs {
+		outbound, err = net.Dial("tcp", net.JoinHostPort(addr.String(), strconv.Itoa(int(id.LocalPort))))
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		logrus.Tracef("net.Dial() = %v", err)
+		r.Complete(true)
+		return
+	}
+
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	r.Complete(false)
+	if tcpErr != nil {
+		logrus.Debugf("r.CreateEndpoint() = %v", tcpErr)
+		_ = outbound.Close()
+		return
+	}
+	remote := tcpproxy.DialProxy{
+		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+			return outbound, nil
+		},
+	}
+	remote.HandleConn(gonet.NewTCPConn(&wq, ep))
+}
+
+func (g *ipv6Gateway) forwardUDP(r *udp.ForwarderRequest) {
+	id := r.ID()
+	addrs, ok := g.hostAddrs(id.LocalAddress)
+	if !ok || !g.allowEgress("udp", id) {
+		return
+	}
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		logrus.Debugf("r.CreateEndpoint() = %v", tcpErr)
+		return
+	}
+	p, _ := forwarder.NewUDPProxy(&autoStoppingUDPConn{UDPConn: gonet.NewUDPConn(&wq, ep)}, func() (net.Conn, error) {
+		// UDP is connectionless, so falling back to the IPv4 loopback address is not possible
+		return net.Dial("udp", net.JoinHostPort(addrs[0].String(), strconv.Itoa(int(id.LocalPort))))
+	})
+	go func() {
+		p.Run()
+		ep.Close()
+	}()
+}
+
+// autoStoppingUDPConn stops the UDP proxy of a forwarded connection once it has been idle.
+type autoStoppingUDPConn struct {
+	*gonet.UDPConn
+}
+
+func (c *autoStoppingUDPConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	_ = c.SetReadDeadline(time.Now().Add(forwarder.UDPConnTrackTimeout))
+	return c.UDPConn.ReadFrom(b)
+}
+
+// serveDNS serves DNS on port 53 of the gateway address.
+func (g *ipv6Gateway) serveDNS(ctx context.Context) error {
+	var err error
+	g.udpDNS, err = hostagentdns.NewHandler(hostagentdns.HandlerOptions{IPv6: true, TruncateReply: true})
+	if err != nil {
+		return err
+	}
+	g.tcpDNS, err = hostagentdns.NewHandler(hostagentdns.HandlerOptions{IPv6: true})
+	if err != nil {
+		return err
+	}
+	addr := tcpip.FullAddress{NIC: ipv6NICID, Addr: tcpip.AddrFrom16(g.gatewayIP.As16()), Port: 53}
+	udpConn, err := gonet.DialUDP(g.stack, &addr, nil, ipv6.ProtocolNumber)
+	if err != nil {
+		return err
+	}
+	tcpLn, err := gonet.ListenTCP(g.stack, addr, ipv6.ProtocolNumber)
+	if err != nil {
+		return err
+	}
+	udpServer := &dns.Server{PacketConn: udpConn, Handler: g.udpDNS}
+	tcpServer := &dns.Server{Listener: tcpLn, Handler: g.tcpDNS}
+	go func() {
+		if err := udpServer.ActivateAndServe(); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("IPv6 DNS server (UDP) failed")
+		}
+	}()
+	go func() {
+		if err := tcpServer.ActivateAndServe(); err != nil && ctx.Err() == nil {
+			logrus.WithError(err).Error("IPv6 DNS server (TCP) failed")
+		}
+	}()
+	return nil
+}
+
+// isIPv6Frame returns true when the frame carries an IPv6 packet.
+func isIPv6Frame(frame []byte) bool {
+	return len(frame) >= 14 && binary.BigEndian.Uint16(frame[12:14]) == etherTypeIPv6
+}
+
+// parseIPv6Subnet parses the --subnet value for IPv6.
+func parseIPv6Subnet(s string) (netip.Prefix, error) {
+	subnet, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if !subnet.Addr().Is6() || subnet.Addr().Is4In6() || subnet.Bits() != 64 {
+		return netip.Prefix{}, fmt.Errorf("invalid IPv6 subnet %q: must be an IPv6 /64 subnet", s)
+	}
+	return subnet.Masked(), nil
+}
@@ -0,0 +1,194 @@
+package usernet
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"gotest.tools/v3/assert"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+var testIPv6Subnet = netip.MustParsePrefix("fd00:6c69:6d61::/64")
+
+// testIPv6VM returns the network stack of a VM connected to the gateway, with the address derived from mac.
+func testIPv6VM(ctx context.Context, t *testing.T, hooks *frameHooks, mac string) (*stack.Stack, netip.Addr) {
+	addr, err := IPv6Address(testIPv6Subnet, mac)
+	assert.NilError(t, err)
+	hwAddr, err := net.ParseMAC(mac)
+	assert.NilError(t, err)
+
+	ep := channel.New(64, 1500+header.EthernetMinimumSize, tcpip.LinkAddress(hwAddr))
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	t.Cleanup(s.Close)
+	assert.Assert(t, s.CreateNIC(1, ethernet.New(ep)) == nil)
+	assert.Assert(t, s.AddProtocolAddress(1, tcpip.ProtocolAddress{
+		Protocol:          ipv6.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddressWithPrefix{Address: tcpip.AddrFrom16(addr.As16()), PrefixLen: 64},
+	}, stack.AddressProperties{}) == nil)
+	subnet, err := tcpip.NewSubnet(tcpip.AddrFrom16(testIPv6Subnet.Addr().As16()), tcpip.MaskFromBytes(net.CIDRMask(64, 128)))
+	assert.NilError(t, err)
+	s.SetRouteTable([]tcpip.Route{
+		{
+			Destination: subnet,
+			NIC:         1,
+		},
+		{
+			Destination: header.IPv6EmptySubnet,
+			Gateway:     tcpip.AddrFrom16(IPv6GatewayIP(testIPv6Subnet).As16()),
+			NIC:         1,
+		},
+	})
+
+	vm, sw := net.Pipe()
+	conn := newFrameConn(sw, hooks, false)
+	t.Cleanup(func() { _ = conn.Close() })
+	// The switch: IPv6 frames never reach it
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	// The NIC of the VM
+	go func() {
+		for {
+			pkt := ep.ReadContext(ctx)
+			if pkt == nil {
+				return
+			}
+			view := pkt.ToView()
+			pkt.DecRef()
+			_, err := vm.Write(view.AsSlice())
+			view.Release()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, err := vm.Read(buf)
+			if err != nil {
+				return
+			}
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(buf[:n])})
+			ep.InjectInbound(ipv6.ProtocolNumber, pkt)
+			pkt.DecRef()
+		}
+	}()
+	return s, addr
+}
+
+func testIPv6Gateway(ctx context.Context, t *testing.T) *frameHooks {
+	g, err := newIPv6Gateway(ctx, testIPv6Subnet, 1500, nil)
+	assert.NilError(t, err)
+	return &frameHooks{capture: newCaptureHub(), ipv6: g}
+}
+
+// testEcho serves an echo server on the listener.
+func testEcho(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
+
+func testEchoRoundTrip(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err := conn.Write([]byte("hello"))
+	assert.NilError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	assert.NilError(t, err)
+	assert.Equal(t, string(buf), "hello")
+}
+
+func TestIPv6GatewayHost(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	hooks := testIPv6Gateway(ctx, t)
+	vm, _ := testIPv6VM(ctx, t, hooks, "52:55:55:00:00:01")
+	gatewayIP := tcpip.AddrFrom16(IPv6GatewayIP(testIPv6Subnet).As16())
+
+	for _, loopback := range []string{"[::1]:0", "127.0.0.1:0"} {
+		t.Run(loopback, func(t *testing.T) {
+			ln, err := net.Listen("tcp", loopback)
+			if err != nil {
+				t.Skip(err)
+			}
+			defer ln.Close()
+			go testEcho(ln)
+			port := ln.Addr().(*net.TCPAddr).Port
+
+			// Connections to the gateway are forwarded to the loopback addresses of the host
+			conn, err := gonet.DialContextTCP(ctx, vm, tcpip.FullAddress{NIC: 1, Addr: gatewayIP, Port: uint16(port)}, ipv6.ProtocolNumber)
+			assert.NilError(t, err)
+			testEchoRoundTrip(t, conn)
+		})
+	}
+}
+
+func TestIPv6GatewayVMs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	hooks := testIPv6Gateway(ctx, t)
+	vm1, _ := testIPv6VM(ctx, t, hooks, "52:55:55:00:00:01")
+	vm2, addr2 := testIPv6VM(ctx, t, hooks, "52:55:55:00:00:02")
+
+	ln, err := gonet.ListenTCP(vm2, tcpip.FullAddress{NIC: 1, Port: 80}, ipv6.ProtocolNumber)
+	assert.NilError(t, err)
+	defer ln.Close()
+	go testEcho(ln)
+
+	t.Run("vm to vm", func(t *testing.T) {
+		conn, err := gonet.DialContextTCP(ctx, vm1, tcpip.FullAddress{NIC: 1, Addr: tcpip.AddrFrom16(addr2.As16()), Port: 80}, ipv6.ProtocolNumber)
+		assert.NilError(t, err)
+		testEchoRoundTrip(t, conn)
+	})
+
+	t.Run("port forwarding", func(t *testing.T) {
+		forwarder := newIPv6Forwarder(hooks.ipv6.stack)
+		defer forwarder.closeAll()
+		// Reserve a free port
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NilError(t, err)
+		local := l.Addr().String()
+		assert.NilError(t, l.Close())
+
+		req := types.ExposeRequest{Local: local, Remote: net.JoinHostPort(addr2.String(), strconv.Itoa(80)), Protocol: types.TCP}
+		assert.NilError(t, forwarder.expose(req))
+		assert.ErrorContains(t, forwarder.expose(req), "already forwarded")
+		assert.DeepEqual(t, forwarder.list(), []types.ExposeRequest{req})
+
+		conn, err := net.Dial("tcp", local)
+		assert.NilError(t, err)
+		testEchoRoundTrip(t, conn)
+
+		assert.NilError(t, forwarder.unexpose(types.UnexposeRequest{Local: local, Protocol: types.TCP}))
+		assert.Equal(t, len(forwarder.list()), 0)
+		assert.ErrorContains(t, forwarder.expose(types.ExposeRequest{Local: local, Remote: "192.168.104.4:80", Protocol: types.TCP}), "not an IPv6 address")
+	})
+}
@@ -0,0 +1,243 @@
+package usernet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+
+	"github.com/containers/gvisor-tap-vsock/pkg/services/forwarder"
+	"github.com/containers/gvisor-tap-vsock/pkg/tcpproxy"
+	"github.com/containers/gvisor-tap-vsock/pkg/types"
+	"github.com/lima-vm/lima/pkg/httpclientutil"
+	"github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// IPv6ForwarderPath is the usernet API endpoint for forwarding host ports to the IPv6 addresses of the VMs.
+// It mirrors the "/services/forwarder" endpoint of gvisor-tap-vsock, which only supports IPv4:
+//
+//	GET  /all       lists the forwarded ports as types.ExposeRequest
+//	POST /expose    forwards the port in the types.ExposeRequest body
+//	POST /unexpose  stops forwarding the port in the types.UnexposeRequest body
+//
+// Only the "tcp" and "udp" protocols are supported.
+const IPv6ForwarderPath = "/lima/ipv6/forwarder"
+
+type ipv6Forwarder struct {
+	stack *stack.Stack
+
+	mu       sync.Mutex
+	forwards map[string]ipv6Forward // key: protocol and local address
+}
+
+type ipv6Forward struct {
+	types.ExposeRequest
+	closer io.Closer
+}
+
+func newIPv6Forwarder(s *stack.Stack) *ipv6Forwarder {
+	return &ipv6Forwarder{stack: s, forwards: make(map[string]ipv6Forward)}
+}
+
+func forwardKey(protocol types.TransportProtocol, local string) string {
+	return string(protocol) + ":" + local
+}
+
+func (f *ipv6Forwarder) expose(req types.ExposeRequest) error {
+	remote, err := netip.ParseAddrPort(req.Remote)
+	if err != nil {
+		return fmt.Errorf("invalid remote address %q: %w", req.Remote, err)
+	}
+	if !remote.Addr().Is6() || remote.Addr().Is4In6() {
+		return fmt.Errorf("remote address %q is not an IPv6 address", req.Remote)
+	}
+	address := tcpip.FullAddress{
+		NIC:  ipv6NICID,
+		Addr: tcpip.AddrFrom16(remote.Addr().As16()),
+		Port: remote.Port(),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := forwardKey(req.Protocol, req.Local)
+	if _, ok := f.forwards[key]; ok {
+		return fmt.Errorf("%s port %q is already forwarded", req.Protocol, req.Local)
+	}
+	var closer io.Closer
+	switch req.Protocol {
+	case types.TCP:
+		var p tcpproxy.Proxy
+		p.AddRoute(req.Local, &tcpproxy.DialProxy{
+			Addr: req.Remote,
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return gonet.DialContextTCP(ctx, f.stack, address, ipv6.ProtocolNumber)
+			},
+		})
+		if err := p.Start(); err != nil {
+			return err
+		}
+		go func() {
+			if err := p.Wait(); err != nil {
+				logrus.WithError(err).Debugf("Stopped forwarding tcp port %q", req.Local)
+			}
+		}()
+		closer = &p
+	case types.UDP:
+		addr, err := net.ResolveUDPAddr("udp", req.Local)
+		if err != nil {
+			return err
+		}
+		listener, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+		p, err := forwarder.NewUDPProxy(listener, func() (net.Conn, error) {
+			return gonet.DialUDP(f.stack, nil, &address, ipv6.ProtocolNumber)
+		})
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		go p.Run()
+		closer = p
+	default:
+		return fmt.Errorf("unsupported protocol %q, must be %q or %q", req.Protocol, types.TCP, types.UDP)
+	}
+	logrus.Infof("Forwarding %s port %q to %q", req.Protocol, req.Local, req.Remote)
+	f.forwards[key] = ipv6Forward{ExposeRequest: req, closer: closer}
+	return nil
+}
+
+func (f *ipv6Forwarder) unexpose(req types.UnexposeRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := forwardKey(req.Protocol, req.Local)
+	fwd, ok := f.forwards[key]
+	if !ok {
+		return fmt.Errorf("%s port %q is not forwarded", req.Protocol, req.Local)
+	}
+	delete(f.forwards, key)
+	logrus.Infof("Stopped forwarding %s port %q to %q", req.Protocol, req.Local, fwd.Remote)
+	return fwd.closer.Close()
+}
+
+func (f *ipv6Forwarder) list() []types.ExposeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	forwards := make([]types.ExposeRequest, 0, len(f.forwards))
+	for _, fwd := range f.forwards {
+		forwards = append(forwards, fwd.ExposeRequest)
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwardKey(forwards[i].Protocol, forwards[i].Local) < forwardKey(forwards[j].Protocol, forwards[j].Local)
+	})
+	return forwards
+}
+
+func (f *ipv6Forwarder) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, fwd := range f.forwards {
+		_ = fwd.closer.Close()
+		delete(f.forwards, key)
+	}
+}
+
+// Mux returns the handler for the IPv6ForwarderPath endpoint, with the IPv6ForwarderPath prefix stripped.
+func (f *ipv6Forwarder) Mux() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/all", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(f.list())
+	})
+	mux.HandleFunc("/expose", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req types.ExposeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err := f.expose(req); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/unexpose", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req types.UnexposeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, err, http.StatusBadRequest)
+			return
+		}
+		if err := f.unexpose(req); err != nil {
+			writeError(w, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// ExposeIPv6 forwards the host address req.Local to the IPv6 address req.Remote of a VM.
+func (c *Client) ExposeIPv6(ctx context.Context, req *types.ExposeRequest) error {
+	return c.postIPv6Forwarder(ctx, "/expose", req)
+}
+
+// UnexposeIPv6 stops forwarding the host address req.Local.
+func (c *Client) UnexposeIPv6(ctx context.Context, req *types.UnexposeRequest) error {
+	return c.postIPv6Forwarder(ctx, "/unexpose", req)
+}
+
+// IPv6Forwards returns the ports forwarded to the IPv6 addresses of the VMs.
+func (c *Client) IPv6Forwards(ctx context.Context) ([]types.ExposeRequest, error) {
+	u := fmt.Sprintf("%s%s/all", c.base, IPv6ForwarderPath)
+	res, err := httpclientutil.Get(ctx, c.client, u)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var forwards []types.ExposeRequest
+	if err := json.NewDecoder(res.Body).Decode(&forwards); err != nil {
+		return nil, err
+	}
+	return forwards, nil
+}
+
+func (c *Client) postIPv6Forwarder(ctx context.Context, path string, req any) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	u := fmt.Sprintf("%s%s%s", c.base, IPv6ForwarderPath, path)
+	res, err := httpclientutil.Post(ctx, c.client, u, bytes.NewReader(b))
+	if err != nil {
+		var statusErr *httpclientutil.HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+			return errors.New("IPv6 is not enabled for the network")
+		}
+		return err
+	}
+	return res.Body.Close()
+}
@@ -60,6 +60,16 @@ func Start(ctx context.Context, name string) error {
 			return err
 		}
 
+		subnetIPv6, err := IPv6Subnet(name)
+		if err != nil {
+			return err
+		}
+
+		egressPolicy, err := EgressPolicy(name)
+		if err != nil {
+			return err
+		}
+
 		leases, err := readLeases(name)
 		if err != nil {
 			return err
@@ -78,6 +88,16 @@ func Start(ctx context.Context, name string) error {
 				"--listen", fdSock,
 				"--subnet", subnet.String(),
 			}
+			if subnetIPv6.IsValid() {
+				args = append(args, "--subnet", subnetIPv6.String())
+			}
+			if egressPolicy != nil {
+				b, err := json.Marshal(egressPolicy)
+				if err != nil {
+					return err
+				}
+				args = append(args, "--egress-policy", string(b))
+			}
 			if leasesString != "" {
 				args = append(args, "--leases", leasesString)
 			}
@@ -213,3 +233,30 @@ func writeLeases(ctx context.Context, nwName string) error {
 	}
 	return nil
 }
+
+// AddStaticLeases adds the static DHCP leases, mapping IP addresses to MAC addresses, to the leases file of the network.
+// The leases take effect when the network daemon starts next time.
+func AddStaticLeases(name string, leases map[string]string) error {
+	current, err := readLeases(name)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		current = make(map[string]string, len(leases))
+	}
+	for ip, mac := range leases {
+		current[ip] = mac
+	}
+	leasesFile, err := Leases(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(leasesFile), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(leasesFile, b, 0o644)
+}
@@ -4,13 +4,18 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 
 	"github.com/lima-vm/lima/pkg/osutil"
+	"github.com/sirupsen/logrus"
 )
 
 func (c *Config) Validate() error {
@@ -48,7 +53,125 @@ func (c *Config) Validate() error {
 	if socketVMNetNotFound {
 		return fmt.Errorf("networks.yaml: %q (`paths.socketVMNet`) has to be installed", pathsMap["socketVMNet"])
 	}
-	// TODO(jandubois): validate network definitions
+	// The network definitions are only enforced when they are edited with `limactl network`,
+	// so that a networks.yaml file written before the validation was added can still be loaded.
+	if err := c.ValidateNetworks(); err != nil {
+		logrus.WithError(err).Warn("networks.yaml contains an invalid network definition")
+	}
+	return nil
+}
+
+var networkNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateNetworks validates the network definitions, without checking the security of cfg.Paths.*.
+func (c *Config) ValidateNetworks() error {
+	return c.validateNetworks(func(string) bool { return true })
+}
+
+// validateNetworks validates the definitions of the networks for which check returns true,
+// and checks that their subnets do not overlap with the subnets of the other networks.
+func (c *Config) validateNetworks(check func(name string) bool) error {
+	subnets := make(map[string]*net.IPNet, len(c.Networks))
+	ipv6Subnets := make(map[string]netip.Prefix)
+	for name, nw := range c.Networks {
+		if check(name) {
+			if !networkNameRegex.MatchString(name) {
+				return fmt.Errorf("networks.yaml: network name %q must match %s", name, networkNameRegex.String())
+			}
+			if err := validateNetwork(nw); err != nil {
+				return fmt.Errorf("networks.yaml field `networks.%s` error: %w", name, err)
+			}
+		}
+		if subnet := nw.Subnet(); subnet != nil {
+			subnets[name] = subnet
+		}
+		if prefix, err := nw.IPv6Prefix(); err == nil && prefix.IsValid() {
+			ipv6Subnets[name] = prefix
+		}
+	}
+	// names must be in stable order for a deterministic error message
+	names := make([]string, 0, len(subnets))
+	for name := range subnets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		for _, other := range names[i+1:] {
+			if !check(name) && !check(other) {
+				continue
+			}
+			if subnets[name].Contains(subnets[other].IP) || subnets[other].Contains(subnets[name].IP) {
+				return fmt.Errorf("networks.yaml: subnet %s of network %q overlaps with subnet %s of network %q",
+					subnets[name], name, subnets[other], other)
+			}
+			if ipv6Subnets[name].IsValid() && ipv6Subnets[name] == ipv6Subnets[other] {
+				return fmt.Errorf("networks.yaml: IPv6 subnet %s of network %q is also used by network %q",
+					ipv6Subnets[name], name, other)
+			}
+		}
+	}
+	return nil
+}
+
+func validateNetwork(nw Network) error {
+	switch nw.Mode {
+	case ModeBridged:
+		if nw.Interface == "" {
+			return fmt.Errorf("field `interface` must be set for mode %q", nw.Mode)
+		}
+		if nw.Gateway != nil || nw.DHCPEnd != nil || nw.NetMask != nil {
+			return fmt.Errorf("fields `gateway`, `dhcpEnd`, and `netmask` must not be set for mode %q", nw.Mode)
+		}
+		return nil
+	case ModeHost, ModeShared, ModeUserV2:
+		if nw.Interface != "" {
+			return fmt.Errorf("field `interface` must not be set for mode %q", nw.Mode)
+		}
+	default:
+		return fmt.Errorf("field `mode` must be one of %q, %q, %q, or %q; got %q",
+			ModeUserV2, ModeHost, ModeShared, ModeBridged, nw.Mode)
+	}
+	if nw.Gateway.To4() == nil {
+		return fmt.Errorf("field `gateway` must be an IPv4 address, got %q", nw.Gateway)
+	}
+	if nw.NetMask != nil {
+		mask := net.IPMask(nw.NetMask.To4())
+		if ones, bits := mask.Size(); mask == nil || (ones == 0 && bits == 0) {
+			return fmt.Errorf("field `netmask` must be a valid IPv4 netmask, got %q", nw.NetMask)
+		}
+	}
+	if nw.IPv6Subnet != "" {
+		if nw.Mode != ModeUserV2 {
+			return fmt.Errorf("field `ipv6Subnet` must not be set for mode %q", nw.Mode)
+		}
+		if _, err := nw.IPv6Prefix(); err != nil {
+			return fmt.Errorf("field `ipv6Subnet` is invalid: %w", err)
+		}
+	}
+	if nw.Conditions != nil {
+		if nw.Mode != ModeUserV2 {
+			return fmt.Errorf("field `conditions` must not be set for mode %q", nw.Mode)
+		}
+		if _, err := nw.Conditions.Parse(); err != nil {
+			return fmt.Errorf("field `conditions` error: %w", err)
+		}
+	}
+	if nw.Egress != nil {
+		if nw.Mode != ModeUserV2 {
+			return fmt.Errorf("field `egress` must not be set for mode %q", nw.Mode)
+		}
+		if err := nw.Egress.Validate(); err != nil {
+			return fmt.Errorf("field `egress` error: %w", err)
+		}
+	}
+	if nw.DHCPEnd != nil {
+		if nw.Mode == ModeUserV2 {
+			return fmt.Errorf("field `dhcpEnd` must not be set for mode %q", nw.Mode)
+		}
+		if !nw.Subnet().Contains(nw.DHCPEnd) {
+			return fmt.Errorf("field `dhcpEnd` %q is not within subnet %s", nw.DHCPEnd, nw.Subnet())
+		}
+	}
 	return nil
 }
 
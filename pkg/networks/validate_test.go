@@ -0,0 +1,164 @@
+package networks
+
+import (
+	"net"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestValidateNetworks(t *testing.T) {
+	cfg, err := DefaultConfig()
+	assert.NilError(t, err)
+	assert.NilError(t, cfg.ValidateNetworks())
+
+	cases := []struct {
+		name     string
+		nwName   string
+		nw       Network
+		errorMsg string
+	}{
+		{
+			name:     "invalid name",
+			nwName:   "foo bar",
+			nw:       Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1")},
+			errorMsg: "must match",
+		},
+		{
+			name:     "unknown mode",
+			nwName:   "foo",
+			nw:       Network{Mode: "vde"},
+			errorMsg: "field `mode` must be one of",
+		},
+		{
+			name:     "bridged without interface",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeBridged},
+			errorMsg: "field `interface` must be set",
+		},
+		{
+			name:     "shared without gateway",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeShared},
+			errorMsg: "field `gateway` must be an IPv4 address",
+		},
+		{
+			name:     "invalid netmask",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeHost, Gateway: net.ParseIP("192.168.42.1"), NetMask: net.ParseIP("255.0.255.0")},
+			errorMsg: "field `netmask` must be a valid IPv4 netmask",
+		},
+		{
+			name:     "dhcpEnd outside of subnet",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeHost, Gateway: net.ParseIP("192.168.42.1"), DHCPEnd: net.ParseIP("192.168.43.254")},
+			errorMsg: "is not within subnet 192.168.42.0/24",
+		},
+		{
+			name:     "ipv6Subnet for shared",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeShared, Gateway: net.ParseIP("192.168.42.1"), IPv6Subnet: "fd00:6c69:6d61::/64"},
+			errorMsg: "field `ipv6Subnet` must not be set",
+		},
+		{
+			name:     "ipv6Subnet with invalid prefix length",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1"), IPv6Subnet: "fd00:6c69:6d61::/48"},
+			errorMsg: "the prefix length of \"fd00:6c69:6d61::/48\" must be 64",
+		},
+		{
+			name:     "ipv6Subnet with IPv4 address",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1"), IPv6Subnet: "192.168.42.0/24"},
+			errorMsg: "is not an IPv6 subnet",
+		},
+		{
+			name:     "conditions for shared",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeShared, Gateway: net.ParseIP("192.168.42.1"), Conditions: &Conditions{Latency: "100ms"}},
+			errorMsg: "field `conditions` must not be set",
+		},
+		{
+			name:     "conditions with invalid loss",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1"), Conditions: &Conditions{Loss: 101}},
+			errorMsg: "field `loss` must be a percentage between 0 and 100",
+		},
+		{
+			name:     "egress for shared",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeShared, Gateway: net.ParseIP("192.168.42.1"), Egress: &EgressPolicy{Default: EgressDeny}},
+			errorMsg: "field `egress` must not be set",
+		},
+		{
+			name:     "egress with invalid rule",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1"), Egress: &EgressPolicy{Rules: []EgressRule{{Action: "reject"}}}},
+			errorMsg: "field `egress` error: field `rules[0]` error: field `action` must be",
+		},
+		{
+			name:     "overlapping subnet",
+			nwName:   "foo",
+			nw:       Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.104.100"), NetMask: net.ParseIP("255.255.0.0")},
+			errorMsg: "overlaps with subnet",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := DefaultConfig()
+			assert.NilError(t, err)
+			cfg.Networks[tc.nwName] = tc.nw
+			assert.ErrorContains(t, cfg.ValidateNetworks(), tc.errorMsg)
+		})
+	}
+
+	t.Run("overlapping IPv6 subnet", func(t *testing.T) {
+		cfg, err := DefaultConfig()
+		assert.NilError(t, err)
+		cfg.Networks["foo"] = Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1"), IPv6Subnet: "fd00:6c69:6d61::/64"}
+		assert.NilError(t, cfg.ValidateNetworks())
+		cfg.Networks["bar"] = Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.43.1"), IPv6Subnet: "fd00:6c69:6d61::/64"}
+		assert.ErrorContains(t, cfg.ValidateNetworks(), "IPv6 subnet fd00:6c69:6d61::/64 of network \"bar\" is also used by network \"foo\"")
+	})
+
+	t.Run("unchecked networks", func(t *testing.T) {
+		cfg, err := DefaultConfig()
+		assert.NilError(t, err)
+		cfg.Networks["legacy"] = Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.1"), DHCPEnd: net.ParseIP("192.168.42.254")}
+		cfg.Networks["foo"] = Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.43.1")}
+		isFoo := func(name string) bool { return name == "foo" }
+		assert.NilError(t, cfg.validateNetworks(isFoo))
+		assert.ErrorContains(t, cfg.ValidateNetworks(), "field `dhcpEnd` must not be set")
+
+		// The subnets of the checked networks must still not overlap with the unchecked ones
+		cfg.Networks["foo"] = Network{Mode: ModeUserV2, Gateway: net.ParseIP("192.168.42.100")}
+		assert.ErrorContains(t, cfg.validateNetworks(isFoo), "overlaps with subnet")
+	})
+}
+
+func TestIPv6Prefix(t *testing.T) {
+	nw := Network{Mode: ModeUserV2}
+	prefix, err := nw.IPv6Prefix()
+	assert.NilError(t, err)
+	assert.Assert(t, !prefix.IsValid())
+
+	nw.IPv6Subnet = "fd00:6c69:6d61::/64"
+	prefix, err = nw.IPv6Prefix()
+	assert.NilError(t, err)
+	assert.Equal(t, prefix.String(), "fd00:6c69:6d61::/64")
+
+	nw.IPv6Subnet = "fd00:6c69:6d61::1/64"
+	_, err = nw.IPv6Prefix()
+	assert.ErrorContains(t, err, "has host bits set")
+}
+
+func TestSubnet(t *testing.T) {
+	nw := Network{Gateway: net.ParseIP("192.168.42.1")}
+	assert.Equal(t, nw.Subnet().String(), "192.168.42.0/24")
+
+	nw.NetMask = net.ParseIP("255.255.0.0")
+	assert.Equal(t, nw.Subnet().String(), "192.168.0.0/16")
+
+	nw.Gateway = nil
+	assert.Assert(t, nw.Subnet() == nil)
+}
@@ -62,3 +62,21 @@ func LimaDisksDir() (string, error) {
 	}
 	return filepath.Join(limaDir, filenames.DisksDir), nil
 }
+
+// LimaClustersDir returns the path of the clusters directory, $LIMA_HOME/_clusters.
+func LimaClustersDir() (string, error) {
+	limaDir, err := LimaDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(limaDir, filenames.ClustersDir), nil
+}
+
+// LimaTemplatesDir returns the path of the user templates directory, $LIMA_HOME/_templates.
+func LimaTemplatesDir() (string, error) {
+	limaDir, err := LimaDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(limaDir, filenames.TemplatesDir), nil
+}
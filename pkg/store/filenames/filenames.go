@@ -7,10 +7,12 @@ package filenames
 // Instance names starting with an underscore are reserved for lima internal usage
 
 const (
-	ConfigDir   = "_config"
-	CacheDir    = "_cache"    // not yet implemented
-	NetworksDir = "_networks" // network log files are stored here
-	DisksDir    = "_disks"    // disks are stored here
+	ConfigDir    = "_config"
+	CacheDir     = "_cache"     // not yet implemented
+	NetworksDir  = "_networks"  // network log files are stored here
+	DisksDir     = "_disks"     // disks are stored here
+	ClustersDir  = "_clusters"  // cluster definitions are stored here
+	TemplatesDir = "_templates" // user templates are stored here
 )
 
 // Filenames used inside the ConfigDir
@@ -19,6 +21,8 @@ const (
 	UserPrivateKey = "user"
 	UserPublicKey  = UserPrivateKey + ".pub"
 	NetworksConfig = "networks.yaml"
+	HTTPConfig     = "http.yaml"
+	TemplateRepos  = "template-repos.yaml"
 	Default        = "default.yaml"
 	Override       = "override.yaml"
 )
@@ -1,12 +1,16 @@
 package templatestore
 
 import (
+	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/lima-vm/lima/pkg/store/dirnames"
 	"github.com/lima-vm/lima/pkg/usrlocalsharelima"
 )
 
@@ -15,46 +19,127 @@ type Template struct {
 	Location string `json:"location"`
 }
 
-func Read(name string) ([]byte, error) {
-	dir, err := usrlocalsharelima.Dir()
+// UserDirs returns the directories of the user templates, in the order of precedence:
+//   - the directories in $LIMA_TEMPLATES_PATH (separated by ":", or ";" on Windows)
+//   - $LIMA_HOME/_templates
+//   - $XDG_CONFIG_HOME/lima/templates (~/.config/lima/templates)
+func UserDirs() ([]string, error) {
+	var dirs []string
+	for _, d := range filepath.SplitList(os.Getenv("LIMA_TEMPLATES_PATH")) {
+		if d != "" {
+			dirs = append(dirs, d)
+		}
+	}
+	limaTemplatesDir, err := dirnames.LimaTemplatesDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs = append(dirs, limaTemplatesDir)
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	return append(dirs, filepath.Join(configHome, "lima", "templates")), nil
+}
+
+// Dirs returns the template search path: the user directories, followed by
+// the directory of the bundled templates (share/lima/templates).
+// The templates in the former directories shadow the templates with the same name in the latter ones.
+func Dirs() ([]string, error) {
+	dirs, err := UserDirs()
 	if err != nil {
 		return nil, err
 	}
-	yamlPath, err := securejoin.SecureJoin(filepath.Join(dir, "templates"), name+".yaml")
+	usrlocalsharelimaDir, err := usrlocalsharelima.Dir()
 	if err != nil {
 		return nil, err
 	}
-	return os.ReadFile(yamlPath)
+	return append(dirs, filepath.Join(usrlocalsharelimaDir, "templates")), nil
+}
+
+func Read(name string) ([]byte, error) {
+	dirs, err := Dirs()
+	if err != nil {
+		return nil, err
+	}
+	return read(dirs, name)
+}
+
+func read(dirs []string, name string) ([]byte, error) {
+	for _, dir := range dirs {
+		yamlPath, err := securejoin.SecureJoin(dir, name+".yaml")
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(yamlPath)
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("template %q not found in %v: %w", name, dirs, os.ErrNotExist)
 }
 
 const Default = "default"
 
 func Templates() ([]Template, error) {
-	usrlocalsharelimaDir, err := usrlocalsharelima.Dir()
+	dirs, err := Dirs()
 	if err != nil {
 		return nil, err
 	}
-	templatesDir := filepath.Join(usrlocalsharelimaDir, "templates")
+	return templates(dirs)
+}
 
+// templates lists the templates in dirs. The last directory must exist.
+func templates(dirs []string) ([]Template, error) {
 	var res []Template
-	walkDirFn := func(p string, _ fs.DirEntry, err error) error {
+	seen := make(map[string]bool)
+	for i, templatesDir := range dirs {
+		// The directory may be a symlink, e.g., to a git clone of the team templates
+		realDir, err := filepath.EvalSymlinks(templatesDir)
 		if err != nil {
-			return err
+			if errors.Is(err, os.ErrNotExist) && i < len(dirs)-1 {
+				continue
+			}
+			return nil, err
 		}
-		base := filepath.Base(p)
-		if strings.HasPrefix(base, ".") || !strings.HasSuffix(base, ".yaml") {
+		walkDirFn := func(p string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			base := filepath.Base(p)
+			if strings.HasPrefix(base, ".") || !strings.HasSuffix(base, ".yaml") {
+				return nil
+			}
+			rel, err := filepath.Rel(realDir, p)
+			if err != nil {
+				return err
+			}
+			// Name is like "default", "debian", "deprecated/centos-7", ...
+			name := strings.TrimSuffix(filepath.ToSlash(rel), ".yaml")
+			if seen[name] {
+				// Shadowed by a template in a preceding directory
+				return nil
+			}
+			seen[name] = true
+			res = append(res, Template{
+				Name:     name,
+				Location: filepath.Join(templatesDir, rel),
+			})
 			return nil
 		}
-		x := Template{
-			// Name is like "default", "debian", "deprecated/centos-7", ...
-			Name:     strings.TrimSuffix(strings.TrimPrefix(p, templatesDir+"/"), ".yaml"),
-			Location: p,
+		if err = filepath.WalkDir(realDir, walkDirFn); err != nil {
+			return nil, err
 		}
-		res = append(res, x)
-		return nil
-	}
-	if err = filepath.WalkDir(templatesDir, walkDirFn); err != nil {
-		return nil, err
 	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
 	return res, nil
 }
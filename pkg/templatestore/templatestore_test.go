@@ -0,0 +1,67 @@
+package templatestore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestUserDirs(t *testing.T) {
+	limaHome := t.TempDir()
+	configHome := t.TempDir()
+	t.Setenv("LIMA_HOME", limaHome)
+	t.Setenv("XDG_CONFIG_HOME", configHome)
+	t.Setenv("LIMA_TEMPLATES_PATH", "/team/templates"+string(filepath.ListSeparator)+"/other/templates")
+	dirs, err := UserDirs()
+	assert.NilError(t, err)
+	assert.DeepEqual(t, dirs, []string{
+		"/team/templates",
+		"/other/templates",
+		filepath.Join(limaHome, "_templates"),
+		filepath.Join(configHome, "lima", "templates"),
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	userDir := t.TempDir()
+	bundledDir := t.TempDir()
+	write := func(dir, name, content string) {
+		p := filepath.Join(dir, name+".yaml")
+		assert.NilError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		assert.NilError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+	write(bundledDir, "default", "bundled default")
+	write(bundledDir, "debian", "bundled debian")
+	write(bundledDir, "deprecated/centos-7", "bundled centos-7")
+	write(userDir, "default", "user default")
+	write(userDir, "team/dev", "user dev")
+	dirs := []string{filepath.Join(t.TempDir(), "missing"), userDir, bundledDir}
+
+	res, err := templates(dirs)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, res, []Template{
+		{Name: "debian", Location: filepath.Join(bundledDir, "debian.yaml")},
+		{Name: "default", Location: filepath.Join(userDir, "default.yaml")},
+		{Name: "deprecated/centos-7", Location: filepath.Join(bundledDir, "deprecated", "centos-7.yaml")},
+		{Name: "team/dev", Location: filepath.Join(userDir, "team", "dev.yaml")},
+	})
+
+	for name, expected := range map[string]string{
+		"default":             "user default",
+		"debian":              "bundled debian",
+		"deprecated/centos-7": "bundled centos-7",
+		"team/dev":            "user dev",
+	} {
+		b, err := read(dirs, name)
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), expected)
+	}
+	_, err = read(dirs, "missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The bundled templates must exist
+	_, err = templates([]string{userDir, filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -33,3 +33,24 @@ func Select(message string, options []string) (int, error) {
 	}
 	return ans, nil
 }
+
+// Input is a regular text input. The answer is validated with validate, if not nil.
+func Input(message, help, defaultParam string, validate func(string) error) (string, error) {
+	var ans string
+	prompt := &survey.Input{
+		Message: message,
+		Help:    help,
+		Default: defaultParam,
+	}
+	var opts []survey.AskOpt
+	if validate != nil {
+		opts = append(opts, survey.WithValidator(func(ans any) error {
+			s, _ := ans.(string)
+			return validate(s)
+		}))
+	}
+	if err := survey.AskOne(prompt, &ans, opts...); err != nil {
+		return "", err
+	}
+	return ans, nil
+}
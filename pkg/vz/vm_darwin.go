@@ -113,7 +113,7 @@ func startVM(ctx context.Context, driver *driver.BaseDriver) (*virtualMachineWra
 					wrapper.mu.Lock()
 					wrapper.stopped = true
 					wrapper.mu.Unlock()
-					_ = usernetClient.UnExposeSSH(driver.SSHLocalPort)
+					_ = usernetClient.UnconfigureDriver(ctx, driver)
 					errCh <- errors.New("vz driver state stopped")
 				default:
 					logrus.Debugf("[VZ] - vm state change: %q", newState)